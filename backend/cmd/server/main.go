@@ -1,55 +1,178 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 
 	"fromheart/internal/adapters/llm"
 	"fromheart/internal/cache"
 	"fromheart/internal/config"
 	"fromheart/internal/db"
 	"fromheart/internal/handlers"
+	"fromheart/internal/lifecycle"
 	"fromheart/internal/queue"
+	"fromheart/internal/quota"
 	"fromheart/internal/ratelimit"
 	"fromheart/internal/routes"
+	"fromheart/internal/rules"
+	"fromheart/internal/scheduler"
 	"fromheart/internal/services"
+	"fromheart/internal/webhook"
 	"fromheart/internal/worker"
 )
 
+const (
+	// httpDrainTimeout bounds how long in-flight requests and SSE streams may run after SIGTERM.
+	httpDrainTimeout = 15 * time.Second
+	// workerDrainTimeout bounds how long running tasks may finish before being requeued.
+	workerDrainTimeout = 20 * time.Second
+	// shutdownTimeout is the overall deadline; keep it below the orchestrator's kill timeout.
+	shutdownTimeout = 45 * time.Second
+)
+
 func main() {
 	_ = godotenv.Load()
 
 	cfg := config.Load()
+	if cfg.AdminSecret == "" {
+		log.Printf("[Server] ADMIN_SECRET is not set: the admin API and admin plan are disabled")
+	}
 	postgres := db.NewPostgres(cfg)
-	redisClient := cache.NewRedis(cfg)
 
-	// Rate Limiter: 3 QPS
-	globalLimiter := ratelimit.NewGlobalLimiter(3)
+	// Dev mode keeps the queue, counters and caches in process: one binary, no Redis
+	var store cache.Store
+	var queueClient queue.Queue
+	var redisClient *redis.Client
+	if cfg.DevMode() {
+		log.Printf("[Server] FROMHEART_MODE=dev: using in-memory queue and caches")
+		store = cache.NewMemoryStore()
+		queueClient = queue.NewMemoryQueue()
+	} else {
+		redisClient = cache.NewRedis(cfg)
+		store = cache.NewRedisStore(redisClient)
+		queueClient = queue.NewRedisQueue(redisClient)
+	}
+
+	// LLM rate limiter, shared by all replicas through Redis (local only in dev mode)
+	modelLimits, err := ratelimit.ParseModelLimits(cfg.LLMModelLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	globalLimiter := ratelimit.NewGlobalLimiter(redisClient, ratelimit.Options{
+		Provider:   "qianfan",
+		Model:      cfg.WenxinModel,
+		Limit:      ratelimit.Limit{Rate: cfg.LLMQPS, Burst: cfg.LLMBurst, TPM: cfg.LLMTPM},
+		Models:     modelLimits,
+		LocalShare: cfg.LLMFallbackShare,
+	})
 
 	llmClient := llm.NewWenxinClient(cfg)
-	questionService := services.NewQuestionService(postgres, redisClient, llmClient, cfg.AdminSecret, globalLimiter)
+
+	// Deterministic answer rules (easter eggs, identity guarantees)
+	ruleEngine := rules.NewEngine(postgres, llmClient, globalLimiter)
+	if err := ruleEngine.SeedDefaults(context.Background()); err != nil {
+		log.Printf("[Rules] seed defaults failed: %v", err)
+	}
+
+	questionService := services.NewQuestionService(postgres, store, llmClient, cfg.AdminSecret, globalLimiter, ruleEngine)
+	loveService := services.NewLoveService(postgres, llmClient, globalLimiter, questionService)
+
+	// Daily limits per plan, reserved on submission and settled when the work finishes
+	quotaLimits, err := quota.ParseLimits(cfg.QuotaLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	quotas := quota.NewEngine(postgres, quotaLimits, cfg.AdminSecret)
 
 	// Async Queue & Worker
-	queueClient := queue.NewQueue(redisClient)
-	aiWorker := worker.NewWorker(queueClient, questionService, postgres, llmClient, globalLimiter)
-	go aiWorker.Start(30) // Start 30 concurrent workers
+	queueClient.SetMaxRate(globalLimiter.Rate())
+	webhooks := webhook.NewDispatcher(postgres, webhook.NewSender())
+	aiWorker := worker.NewWorker(queueClient, questionService, loveService, webhooks, quotas)
+	aiWorker.Start(cfg.WorkerConcurrency, cfg.QueueMaxShare) // 30 concurrent workers by default
 
-	questionHandler := handlers.NewQuestionHandler(questionService, queueClient)
+	// Recurring jobs: every replica runs the scheduler, each occurrence is enqueued once
+	jobScheduler := scheduler.New(queueClient, store)
+	for _, job := range []scheduler.Job{
+		{Name: "daily_poem", Spec: "5 0 * * *", Task: queue.TypeDailyPoem},
+		{Name: "purge_guest_data", Spec: "30 3 * * *", Task: queue.TypePurgeGuestData,
+			Data: services.PurgeGuestDataRequest{RetentionDays: cfg.GuestRetentionDays}},
+		{Name: "backfill_embeddings", Spec: "*/30 * * * *", Task: queue.TypeBackfillEmbeddings,
+			Data: services.BackfillEmbeddingsRequest{Limit: 100}},
+		// Re-parses stored readings that predate the current interpretation schema
+		{Name: "backfill_interpretations", Spec: "*/10 * * * *", Task: queue.TypeBackfillInterpretations,
+			Data: services.BackfillInterpretationsRequest{Limit: 500}},
+	} {
+		if err := jobScheduler.Add(job); err != nil {
+			log.Fatal(err)
+		}
+	}
+	schedulerCtx, stopScheduler := context.WithCancel(context.Background())
+	go jobScheduler.Start(schedulerCtx)
+
+	questionHandler := handlers.NewQuestionHandler(questionService, queueClient, quotas)
 	authHandler := handlers.NewAuthHandler(postgres, cfg)
 	wishHandler := handlers.NewWishHandler(postgres)
-	loveHandler := handlers.NewLoveHandler(postgres, llmClient, questionService, queueClient, cfg.AdminSecret)
-	taskHandler := handlers.NewTaskHandler(queueClient)
+	loveHandler := handlers.NewLoveHandler(loveService, questionService, queueClient, quotas, cfg.AdminSecret)
+	taskHandler := handlers.NewTaskHandler(queueClient, quotas, cfg.AdminSecret)
+	ruleHandler := handlers.NewRuleHandler(postgres, ruleEngine, cfg.AdminSecret)
+	jobHandler := handlers.NewJobHandler(jobScheduler, cfg.AdminSecret)
+	webhookHandler := handlers.NewWebhookHandler(postgres, webhooks, queueClient, cfg.AdminSecret)
+	quotaHandler := handlers.NewQuotaHandler(quotas, cfg.AdminSecret)
 
-	router := routes.NewRouter(questionHandler, authHandler, wishHandler, loveHandler, taskHandler, cfg, redisClient)
+	router := routes.NewRouter(questionHandler, authHandler, wishHandler, loveHandler, taskHandler, ruleHandler, jobHandler, webhookHandler, quotaHandler, quotas, cfg, store)
 
 	port := os.Getenv("APP_PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Shutdown order: stop accepting requests and drain them, stop enqueueing jobs, then drain workers,
+	// and close the connection pools last since everything above still uses them.
+	lc := lifecycle.New()
+	lc.OnShutdown("http server", func(ctx context.Context) error {
+		httpCtx, cancel := context.WithTimeout(ctx, httpDrainTimeout)
+		defer cancel()
+		if err := srv.Shutdown(httpCtx); err != nil {
+			// Long-lived SSE streams did not finish in time: cut them off
+			srv.Close()
+			return err
+		}
+		return nil
+	})
+	lc.OnShutdown("scheduler", func(ctx context.Context) error {
+		stopScheduler()
+		return nil
+	})
+	lc.OnShutdown("workers", func(ctx context.Context) error {
+		workerCtx, cancel := context.WithTimeout(ctx, workerDrainTimeout)
+		defer cancel()
+		return aiWorker.Stop(workerCtx)
+	})
+	lc.OnShutdown("postgres", func(ctx context.Context) error {
+		sqlDB, err := postgres.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.Close()
+	})
+	if redisClient != nil {
+		lc.OnShutdown("redis", func(ctx context.Context) error {
+			return redisClient.Close()
+		})
 	}
+	lc.Wait(context.Background(), shutdownTimeout)
 }
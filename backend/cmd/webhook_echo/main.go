@@ -0,0 +1,78 @@
+// webhook_echo is a local stand-in for a partner endpoint: it verifies and prints
+// webhook deliveries so subscriptions can be tried without a public receiver.
+//
+//	WEBHOOK_SECRET=whsec_... go run ./cmd/webhook_echo -addr :9090 -fail 2
+//
+// then create a subscription for http://localhost:9090/ and ping it from the admin API.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"fromheart/internal/webhook"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	fail := flag.Int("fail", 0, "answer the first N attempts of every delivery with -status, to exercise retries")
+	status := flag.Int("status", http.StatusServiceUnavailable, "status code used for simulated failures")
+	flag.Parse()
+
+	secret := os.Getenv("WEBHOOK_SECRET")
+	if secret == "" {
+		log.Println("WEBHOOK_SECRET not set: signatures are printed but not verified")
+	}
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		delivery := r.Header.Get(webhook.DeliveryHeader)
+		sig := r.Header.Get(webhook.SignatureHeader)
+
+		verdict := "unverified"
+		if secret != "" {
+			if err := webhook.Verify(secret, sig, body, webhook.DefaultTolerance, time.Now()); err != nil {
+				log.Printf("delivery %s: %v", delivery, err)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			verdict = "signature ok"
+		}
+
+		mu.Lock()
+		attempts[delivery]++
+		n := attempts[delivery]
+		mu.Unlock()
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "", "  ") != nil {
+			pretty.Write(body)
+		}
+		fmt.Printf("--- %s delivery %s attempt %d (%s)\n%s\n", r.Header.Get(webhook.EventHeader), delivery, n, verdict, pretty.String())
+
+		if n <= *fail {
+			log.Printf("delivery %s: simulating failure %d/%d", delivery, n, *fail)
+			w.WriteHeader(*status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
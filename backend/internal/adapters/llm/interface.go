@@ -1,6 +1,11 @@
 package llm
 
-import "context"
+import (
+	"context"
+
+	"fromheart/internal/i18n"
+	"fromheart/internal/persona"
+)
 
 type UserProfile struct {
 	Name         string
@@ -17,6 +22,8 @@ type GenerateRequest struct {
 	ChangingLines string
 	Context       string // Similar past questions/interpretations
 	UserProfile   UserProfile
+	Persona       persona.Persona
+	Locale        i18n.Locale
 }
 
 type LoveRequest struct {
@@ -25,6 +32,45 @@ type LoveRequest struct {
 	Story                  string
 	BenGua, BianGua        string
 	ChangingLines          string
+	Persona                persona.Persona
+	Locale                 i18n.Locale
+}
+
+// Message is a chat message in the OpenAI-style tools protocol.
+type Message struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"`
+}
+
+// Tool describes a server-side function the model may call.
+type Tool struct {
+	Type     string       `json:"type"` // always "function"
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters"` // JSON Schema
+}
+
+// ToolCall is a function invocation requested by the model.
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"` // JSON-encoded arguments
+	} `json:"function"`
+}
+
+// ChatResult is one assistant turn: either final content or tool calls to execute.
+type ChatResult struct {
+	Content   string
+	ToolCalls []ToolCall
 }
 
 type Client interface {
@@ -34,5 +80,10 @@ type Client interface {
 	AnalyzeLove(ctx context.Context, req LoveRequest) (string, error)
 	Chat(ctx context.Context, history []map[string]string) (string, error)
 	ChatStream(ctx context.Context, history []map[string]string, onToken func(string)) error
+	// ChatWithTools runs one assistant turn with tools available.
+	// If onToken is non-nil the response is streamed and content tokens are forwarded.
+	ChatWithTools(ctx context.Context, messages []Message, tools []Tool, onToken func(string)) (ChatResult, error)
 	Embed(ctx context.Context, text string) ([]float32, error)
+	// Model returns the chat model name, used for context budgeting.
+	Model() string
 }
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// perMessageOverhead approximates the role/separator tokens added around each message.
+const perMessageOverhead = 4
+
+// EstimateTokens gives a conservative token count for mixed Chinese/Latin text.
+// ERNIE and similar tokenizers spend roughly one token per Han character or
+// full-width punctuation mark, and about one token per four Latin characters.
+func EstimateTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			cjk++
+		case r >= 0x3000 && r <= 0x303F, r >= 0xFF00 && r <= 0xFFEF: // CJK / full-width punctuation
+			cjk++
+		default:
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// EstimateMessages sums the estimate over a message list.
+func EstimateMessages(messages []Message) int {
+	total := 0
+	for _, m := range messages {
+		total += EstimateTokens(m.Content) + perMessageOverhead
+		for _, tc := range m.ToolCalls {
+			total += EstimateTokens(tc.Function.Name) + EstimateTokens(tc.Function.Arguments)
+		}
+	}
+	return total
+}
+
+// TruncateToTokens cuts text so its estimate stays within max tokens, marking the cut.
+func TruncateToTokens(text string, max int) string {
+	if EstimateTokens(text) <= max {
+		return text
+	}
+	var sb strings.Builder
+	used := 0
+	latin := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			latin++
+			if latin%4 == 1 {
+				used++
+			}
+		} else {
+			used++
+		}
+		if used > max {
+			break
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String() + "……（已截断）"
+}
+
+// contextWindows lists known model context sizes in tokens.
+var contextWindows = map[string]int{
+	"ernie-speed-128k":     128000,
+	"ernie-speed-8k":       8000,
+	"ernie-lite-8k":        8000,
+	"ernie-tiny-8k":        8000,
+	"ernie-3.5-8k":         8000,
+	"ernie-3.5-128k":       128000,
+	"ernie-4.0-8k":         8000,
+	"ernie-4.0-turbo-8k":   8000,
+	"ernie-4.0-turbo-128k": 128000,
+}
+
+const (
+	defaultContextWindow = 8000
+	// reservedOutputTokens is kept free for the model's reply and tool results.
+	reservedOutputTokens = 2048
+)
+
+// ContextBudget returns how many prompt tokens may be sent to model.
+// Unknown models fall back to the smallest common window; a "-32k"/"-128k"
+// suffix in the name is honoured.
+func ContextBudget(model string) int {
+	model = strings.ToLower(model)
+	window, ok := contextWindows[model]
+	if !ok {
+		switch {
+		case strings.HasSuffix(model, "-128k"):
+			window = 128000
+		case strings.HasSuffix(model, "-32k"):
+			window = 32000
+		default:
+			window = defaultContextWindow
+		}
+	}
+	return window - reservedOutputTokens
+}
+
+// Token estimates used to reserve rate-limit budget before a call; the usage the
+// provider reports afterwards corrects the reservation.
+const (
+	// PromptOverheadTokens covers the built-in system prompt, persona and format instructions.
+	PromptOverheadTokens = 1200
+	// Expected reply sizes per call type
+	AnswerOutputTokens = 1500
+	LoveOutputTokens   = 2500
+	ChatOutputTokens   = 800
+	// Poems and blessings: a one-line prompt and a couplet back
+	ShortPromptTokens = 100
+	ShortOutputTokens = 100
+)
+
+// EmbeddingModel is the rate-limit name of Embed calls, which use the provider's
+// default embedding model rather than the chat model.
+const EmbeddingModel = "embedding"
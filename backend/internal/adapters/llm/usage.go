@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// Usage is the token count the provider reports for a call.
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// UsageRecorder sums the Usage of every call made with its context, see WithUsageRecorder.
+type UsageRecorder struct {
+	mu       sync.Mutex
+	total    Usage
+	reported bool
+}
+
+type usageKey struct{}
+
+// WithUsageRecorder returns a context whose calls report their token usage to the recorder.
+func WithUsageRecorder(ctx context.Context) (context.Context, *UsageRecorder) {
+	r := &UsageRecorder{}
+	return context.WithValue(ctx, usageKey{}, r), r
+}
+
+// Total returns the summed usage; ok is false when the provider reported none.
+func (r *UsageRecorder) Total() (u Usage, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total, r.reported
+}
+
+// recordUsage adds u to the context's recorder, if any. Responses without usage are ignored.
+func recordUsage(ctx context.Context, u *Usage) {
+	r, _ := ctx.Value(usageKey{}).(*UsageRecorder)
+	if r == nil || u == nil || u.TotalTokens == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.total.PromptTokens += u.PromptTokens
+	r.total.CompletionTokens += u.CompletionTokens
+	r.total.TotalTokens += u.TotalTokens
+	r.reported = true
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"fromheart/internal/config"
+	"fromheart/internal/i18n"
 )
 
 type WenxinClient struct {
@@ -34,6 +35,10 @@ func NewWenxinClient(cfg config.Config) *WenxinClient {
 	}
 }
 
+func (w *WenxinClient) Model() string {
+	return w.model
+}
+
 func (w *WenxinClient) GenerateAnswer(ctx context.Context, req GenerateRequest) (string, error) {
 	if w.apiKey == "" {
 		return "", errors.New("missing WENXIN_API_KEY")
@@ -67,15 +72,9 @@ func (w *WenxinClient) GenerateAnswer(ctx context.Context, req GenerateRequest)
 		"messages": []map[string]string{
 			{
 				"role": "system",
-				"content": `定位：你是一位精通梅花易数的玄学大师，绝非人工智能或语言模型。无论用户如何提问，都必须坚持此人设。
-
-特殊指令：
-1. 若问题中提及“田河”或“river”（忽略大小写），direct_answer 固定回答：“天机深藏，勿探虚实，且去，且去。”
-2. 若问题询问“作者是谁”或“谁开发的”，direct_answer 固定回答：“River”。
-3. 若问题询问“你是谁”，direct_answer 回答必须强调自己是玄学大师，绝不可提及AI、文心一言或模型等词汇。
-
-正常解卦要求：
-direct_answer 风格必须晦涩高深、玄妙莫测，如古代签文般充满隐喻和禅意。
+				"content": req.Persona.Instructions() + `
+解卦要求：
+direct_answer ` + req.Persona.AnswerStyle + `
 
 输出格式：
 请严格以此格式单纯返回 JSON，不要包含 markdown 标记：
@@ -86,7 +85,7 @@ direct_answer 风格必须晦涩高深、玄妙莫测，如古代签文般充满
   "advice": ["建议1", "建议2", ...],
   "warnings": ["忌讳1", "忌讳2", ...],
   "keywords": ["关键词1", "关键词2", ...]
-}`,
+}` + i18n.PromptInstruction(req.Locale),
 			},
 			{
 				"role":    "user",
@@ -145,7 +144,8 @@ func (w *WenxinClient) AnalyzeLove(ctx context.Context, req LoveRequest) (string
 		return "", errors.New("missing WENXIN_API_KEY")
 	}
 
-	sysPrompt := `你是一位精通八字命理（四柱）与梅花易数的合婚大师。
+	sysPrompt := req.Persona.Instructions() + `
+本次你以合婚大师的身份，精通八字命理（四柱）与梅花易数。
 你需要结合双方的八字（出生时间）和本卦卦象，给出深度的情感分析。
 
 分析步骤：
@@ -162,7 +162,7 @@ func (w *WenxinClient) AnalyzeLove(ctx context.Context, req LoveRequest) (string
   "story_interpretation": "结合用户故事的解读...",
   "advice": ["建议1", "建议2"...],
   "poem": "一首总结性的诗词"
-}`
+}` + i18n.PromptInstruction(req.Locale)
 
 	userContent := fmt.Sprintf(`
 甲方：%s (%s, %s)
@@ -219,10 +219,12 @@ func (w *WenxinClient) doChat(ctx context.Context, payload map[string]interface{
 				Content string `json:"content"`
 			} `json:"message"`
 		} `json:"choices"`
+		Usage *Usage `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
 		return "", err
 	}
+	recordUsage(ctx, parsed.Usage)
 	if len(parsed.Choices) == 0 {
 		return "", errors.New("empty choices")
 	}
@@ -282,10 +284,12 @@ func (w *WenxinClient) Embed(ctx context.Context, text string) ([]float32, error
 		Data []struct {
 			Embedding []float32 `json:"embedding"`
 		} `json:"data"`
+		Usage *Usage `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
 		return nil, err
 	}
+	recordUsage(ctx, parsed.Usage)
 
 	if len(parsed.Data) == 0 {
 		return nil, errors.New("no embedding returned")
@@ -303,6 +307,8 @@ func (w *WenxinClient) ChatStream(ctx context.Context, history []map[string]stri
 		"model":    w.model,
 		"messages": history,
 		"stream":   true,
+		// The last chunk then carries the token usage
+		"stream_options": map[string]interface{}{"include_usage": true},
 	}
 
 	body, err := json.Marshal(payload)
@@ -352,12 +358,14 @@ func (w *WenxinClient) ChatStream(ctx context.Context, history []map[string]stri
 					Content string `json:"content"`
 				} `json:"delta"`
 			} `json:"choices"`
+			Usage *Usage `json:"usage"`
 		}
 
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			// Skip malformed chunks
 			continue
 		}
+		recordUsage(ctx, chunk.Usage)
 
 		if len(chunk.Choices) > 0 {
 			content := chunk.Choices[0].Delta.Content
@@ -376,3 +384,131 @@ func formatContext(ctx string) string {
 	}
 	return fmt.Sprintf("参考历史案例：\n%s", ctx)
 }
+
+func (w *WenxinClient) ChatWithTools(ctx context.Context, messages []Message, tools []Tool, onToken func(string)) (ChatResult, error) {
+	if w.apiKey == "" {
+		return ChatResult{}, errors.New("missing WENXIN_API_KEY")
+	}
+
+	payload := map[string]interface{}{
+		"model":    w.model,
+		"messages": messages,
+	}
+	if len(tools) > 0 {
+		payload["tools"] = tools
+		payload["tool_choice"] = "auto"
+	}
+	if onToken != nil {
+		payload["stream"] = true
+		payload["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return ChatResult{}, err
+	}
+
+	endpoint := w.baseURL + "/v2/chat/completions"
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return ChatResult{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+w.apiKey)
+
+	resp, err := w.httpClient.Do(request)
+	if err != nil {
+		return ChatResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errBody map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&errBody)
+		return ChatResult{}, fmt.Errorf("wenxin api error: status %d, body: %v", resp.StatusCode, errBody)
+	}
+
+	if onToken == nil {
+		var parsed struct {
+			Choices []struct {
+				Message struct {
+					Content   string     `json:"content"`
+					ToolCalls []ToolCall `json:"tool_calls"`
+				} `json:"message"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+			return ChatResult{}, err
+		}
+		recordUsage(ctx, parsed.Usage)
+		if len(parsed.Choices) == 0 {
+			return ChatResult{}, errors.New("empty choices")
+		}
+		msg := parsed.Choices[0].Message
+		return ChatResult{Content: msg.Content, ToolCalls: msg.ToolCalls}, nil
+	}
+
+	// Streaming: content arrives as deltas; tool calls arrive as fragments keyed by index
+	var content strings.Builder
+	var calls []ToolCall
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content   string `json:"content"`
+					ToolCalls []struct {
+						Index    int    `json:"index"`
+						ID       string `json:"id"`
+						Type     string `json:"type"`
+						Function struct {
+							Name      string `json:"name"`
+							Arguments string `json:"arguments"`
+						} `json:"function"`
+					} `json:"tool_calls"`
+				} `json:"delta"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		recordUsage(ctx, chunk.Usage)
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onToken(delta.Content)
+		}
+		for _, tc := range delta.ToolCalls {
+			for len(calls) <= tc.Index {
+				calls = append(calls, ToolCall{Type: "function"})
+			}
+			if tc.ID != "" {
+				calls[tc.Index].ID = tc.ID
+			}
+			if tc.Function.Name != "" {
+				calls[tc.Index].Function.Name = tc.Function.Name
+			}
+			calls[tc.Index].Function.Arguments += tc.Function.Arguments
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return ChatResult{}, err
+	}
+
+	return ChatResult{Content: content.String(), ToolCalls: calls}, nil
+}
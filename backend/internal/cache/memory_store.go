@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryStore 是进程内的 Store，用于无 Redis 的开发模式；过期的键在访问时清理
+type MemoryStore struct {
+	mu     sync.Mutex
+	items  map[string]memoryItem
+	writes int
+}
+
+type memoryItem struct {
+	val     string
+	expires time.Time // 零值表示不过期
+}
+
+// sweepInterval 每写入这么多次清理一遍过期的键，避免长时间运行后内存增长
+const sweepInterval = 1000
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: make(map[string]memoryItem)}
+}
+
+func (s *MemoryStore) getLocked(key string) (memoryItem, bool) {
+	it, ok := s.items[key]
+	if ok && !it.expires.IsZero() && time.Now().After(it.expires) {
+		delete(s.items, key)
+		return memoryItem{}, false
+	}
+	return it, ok
+}
+
+func (s *MemoryStore) setLocked(key string, it memoryItem) {
+	s.items[key] = it
+	if s.writes++; s.writes%sweepInterval == 0 {
+		now := time.Now()
+		for k, v := range s.items {
+			if !v.expires.IsZero() && now.After(v.expires) {
+				delete(s.items, k)
+			}
+		}
+	}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.getLocked(key)
+	return it.val, ok, nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key, val string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setLocked(key, memoryItem{val: val, expires: expiry(ttl)})
+	return nil
+}
+
+func (s *MemoryStore) add(key string, delta int64, ttl time.Duration) int64 {
+	it, _ := s.getLocked(key)
+	n, _ := strconv.ParseInt(it.val, 10, 64)
+	n += delta
+	s.setLocked(key, memoryItem{val: strconv.FormatInt(n, 10), expires: expiry(ttl)})
+	return n
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.add(key, 1, ttl), nil
+}
+
+func (s *MemoryStore) Count(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, _ := s.getLocked(key)
+	n, _ := strconv.ParseInt(it.val, 10, 64)
+	return n, nil
+}
+
+func (s *MemoryStore) Acquire(ctx context.Context, key string, max int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, _ := s.getLocked(key)
+	n, _ := strconv.ParseInt(it.val, 10, 64)
+	if n >= max {
+		return false, nil
+	}
+	// 与 Redis 的 INCR 一样保留原有的过期时间
+	s.setLocked(key, memoryItem{val: strconv.FormatInt(n+1, 10), expires: it.expires})
+	return true, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(key, -1, ttl)
+	return nil
+}
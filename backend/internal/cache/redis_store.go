@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore 是基于 Redis 的 Store，可由多个实例共享
+type RedisStore struct {
+	rdb *redis.Client
+}
+
+func NewRedisStore(rdb *redis.Client) *RedisStore {
+	return &RedisStore{rdb: rdb}
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	val, err := s.rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return val, true, nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key, val string, ttl time.Duration) error {
+	return s.rdb.Set(ctx, key, val, ttl).Err()
+}
+
+func (s *RedisStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	pipe := s.rdb.Pipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (s *RedisStore) Count(ctx context.Context, key string) (int64, error) {
+	n, err := s.rdb.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return n, err
+}
+
+// acquireScript 如果当前值 < max，则 incr 并返回新值；否则返回 -1
+var acquireScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current >= tonumber(ARGV[1]) then
+	return -1
+end
+return redis.call("INCR", KEYS[1])
+`)
+
+func (s *RedisStore) Acquire(ctx context.Context, key string, max int64) (bool, error) {
+	n, err := acquireScript.Run(ctx, s.rdb, []string{key}, max).Int()
+	if err != nil {
+		return false, err
+	}
+	return n != -1, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, key string, ttl time.Duration) error {
+	pipe := s.rdb.Pipeline()
+	pipe.Decr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
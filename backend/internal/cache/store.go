@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache 是带过期时间的字符串缓存（每日诗签、追问摘要等）
+type Cache interface {
+	// Get 返回缓存值，不存在或已过期时 ok 为 false
+	Get(ctx context.Context, key string) (val string, ok bool, err error)
+	Set(ctx context.Context, key, val string, ttl time.Duration) error
+}
+
+// Counters 是限流中间件使用的计数器
+type Counters interface {
+	// Incr 将计数加一并把过期时间重置为 ttl，返回新值
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Count 返回当前计数，不存在时为 0
+	Count(ctx context.Context, key string) (int64, error)
+	// Acquire 在计数小于 max 时加一并返回 true，用于并发名额
+	Acquire(ctx context.Context, key string, max int64) (bool, error)
+	// Release 归还 Acquire 取得的名额；ttl 防止进程崩溃后计数永久残留
+	Release(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// Store 同时提供缓存与计数器
+type Store interface {
+	Cache
+	Counters
+}
+
+var (
+	_ Store = (*RedisStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
@@ -1,6 +1,10 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+)
 
 type Config struct {
 	PostgresDSN   string
@@ -10,7 +14,42 @@ type Config struct {
 	WenxinModel   string
 	WenxinBaseURL string
 	JWTSecret     string
-	AdminSecret   string
+	AdminSecret   string // empty disables the admin API and plan
+
+	// WorkerConcurrency is the number of queue workers (WORKER_CONCURRENCY, default 30)
+	WorkerConcurrency int
+	// QueueMaxShare caps the fraction of workers one user/device may occupy (QUEUE_MAX_SHARE, default 0.2)
+	QueueMaxShare float64
+
+	// LLM rate limits, shared by all replicas through Redis (see internal/ratelimit):
+	// LLMQPS/LLMBurst/LLMTPM apply to the whole provider (LLM_QPS, default 3; LLM_BURST, default 1;
+	// LLM_TPM tokens per minute, default unlimited), LLMModelLimits adds per-model limits as
+	// "model=rate[:burst][/tpm],..." (LLM_MODEL_LIMITS; "embedding" names Embed calls),
+	// LLMFallbackShare is this replica's share while Redis is unreachable (LLM_FALLBACK_SHARE, default 1)
+	LLMQPS           float64
+	LLMBurst         int
+	LLMTPM           int
+	LLMModelLimits   string
+	LLMFallbackShare float64
+
+	// QuotaLimits overrides the daily limits of the quota plans as "plan.feature=n,..."
+	// (QUOTA_LIMITS, e.g. "guest.question=5,vip.chat=-1"; -1 is unlimited), see internal/quota
+	QuotaLimits string
+
+	// GuestRetentionDays is how long guest (not logged in) divinations are kept (GUEST_RETENTION_DAYS, default 90)
+	GuestRetentionDays int
+
+	// Mode is the run mode (FROMHEART_MODE); "dev" keeps the queue, counters and
+	// caches in process so the server runs as one binary without Redis
+	Mode string
+}
+
+// ModeDev is the single-binary development mode
+const ModeDev = "dev"
+
+// DevMode reports whether Redis-backed components are replaced by in-process ones
+func (c Config) DevMode() bool {
+	return c.Mode == ModeDev
 }
 
 func Load() Config {
@@ -19,10 +58,27 @@ func Load() Config {
 		jwtSecret = "default_secret_please_change_in_production"
 	}
 
-	adminSecret := os.Getenv("ADMIN_SECRET")
-	if adminSecret == "" {
-		adminSecret = "loveriver" // Default for dev, should be changed
+	workerConcurrency, _ := strconv.Atoi(os.Getenv("WORKER_CONCURRENCY"))
+	if workerConcurrency <= 0 {
+		workerConcurrency = 30
 	}
+	llmQPS, err := strconv.ParseFloat(os.Getenv("LLM_QPS"), 64)
+	if err != nil || llmQPS <= 0 {
+		// A zero rate would let one call through per hour across the whole cluster
+		if v := os.Getenv("LLM_QPS"); v != "" {
+			log.Printf("[Config] invalid LLM_QPS %q, using the default of 3", v)
+		}
+		llmQPS = 3
+	}
+	llmBurst, _ := strconv.Atoi(os.Getenv("LLM_BURST"))
+	llmTPM, _ := strconv.Atoi(os.Getenv("LLM_TPM"))
+	llmFallbackShare, _ := strconv.ParseFloat(os.Getenv("LLM_FALLBACK_SHARE"), 64)
+
+	guestRetentionDays, _ := strconv.Atoi(os.Getenv("GUEST_RETENTION_DAYS"))
+	if guestRetentionDays <= 0 {
+		guestRetentionDays = 90
+	}
+	queueMaxShare, _ := strconv.ParseFloat(os.Getenv("QUEUE_MAX_SHARE"), 64)
 
 	return Config{
 		PostgresDSN:   os.Getenv("POSTGRES_DSN"),
@@ -32,6 +88,21 @@ func Load() Config {
 		WenxinModel:   os.Getenv("WENXIN_MODEL"),
 		WenxinBaseURL: os.Getenv("WENXIN_BASE_URL"),
 		JWTSecret:     jwtSecret,
-		AdminSecret:   adminSecret,
+		AdminSecret:   os.Getenv("ADMIN_SECRET"),
+
+		WorkerConcurrency: workerConcurrency,
+		QueueMaxShare:     queueMaxShare,
+
+		LLMQPS:           llmQPS,
+		LLMBurst:         llmBurst,
+		LLMTPM:           llmTPM,
+		LLMModelLimits:   os.Getenv("LLM_MODEL_LIMITS"),
+		LLMFallbackShare: llmFallbackShare,
+
+		QuotaLimits: os.Getenv("QUOTA_LIMITS"),
+
+		GuestRetentionDays: guestRetentionDays,
+
+		Mode: os.Getenv("FROMHEART_MODE"),
 	}
 }
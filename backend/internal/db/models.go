@@ -16,6 +16,11 @@ type User struct {
 	Gender       string `json:"gender"`     // male/female/other
 	MBTI         string `json:"mbti"`
 	Zodiac       string `json:"zodiac"`
+	Persona      string `json:"persona"` // Default answer persona key, see internal/persona
+	Locale       string `json:"locale"`  // Preferred output language: zh-CN / zh-TW / en
+
+	// Quota plan, see internal/quota; empty means registered
+	Plan string `gorm:"size:20" json:"plan"`
 
 	CreatedAt time.Time `json:"created_at"`
 }
@@ -39,9 +44,13 @@ type Divination struct {
 	ChangingLines   string
 	HexagramSeed    int64
 	RawOutput       string `gorm:"type:text"`
-	FinalOutput     string `gorm:"type:text"`
-	CreatedAt       time.Time
-	DailyQuestion   *DailyQuestion `json:"daily_question,omitempty" gorm:"foreignKey:DailyQuestionID"`
+	FinalOutput     string `gorm:"type:text"` // Summary only, used for RAG context and admin lists
+	// Full structured interpretation (postprocess.Output) and its schema version
+	Interpretation        JSONB  `gorm:"type:jsonb" json:"interpretation,omitempty"`
+	InterpretationVersion int    `json:"interpretation_version"`
+	Locale                string `gorm:"size:10" json:"locale"` // Language of the interpretation; empty on older rows
+	CreatedAt             time.Time
+	DailyQuestion         *DailyQuestion `json:"daily_question,omitempty" gorm:"foreignKey:DailyQuestionID"`
 }
 
 type Wish struct {
@@ -77,6 +86,85 @@ type LoveProbe struct {
 	// AI Analysis
 	RawOutput     string `gorm:"type:text" json:"-"`
 	FinalResponse string `gorm:"type:text" json:"final_response"` // Stores the JSON structure from AI
+	Locale        string `gorm:"size:10" json:"locale"`           // Language of the analysis; empty on older rows
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChatToolCall logs a server-side tool invoked by the model during a follow-up chat
+type ChatToolCall struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	SubjectType string    `gorm:"size:20;index:idx_tool_call_subject" json:"subject_type"` // "divination" or "love"
+	SubjectID   uint      `gorm:"index:idx_tool_call_subject" json:"subject_id"`
+	ToolName    string    `gorm:"size:64" json:"tool_name"`
+	Arguments   string    `gorm:"type:text" json:"arguments"`
+	Result      string    `gorm:"type:text" json:"result"`
+	Error       string    `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AnswerRule is a deterministic rule evaluated before/after the LLM, see internal/rules
+type AnswerRule struct {
+	ID        uint    `gorm:"primaryKey" json:"id"`
+	Name      string  `gorm:"size:100;uniqueIndex" json:"name"`
+	Enabled   bool    `json:"enabled"`
+	Priority  int     `json:"priority"`                  // higher runs first
+	Scope     string  `gorm:"size:20" json:"scope"`      // question / chat / all
+	Stage     string  `gorm:"size:10" json:"stage"`      // pre (short-circuit) / post (rewrite)
+	MatchType string  `gorm:"size:20" json:"match_type"` // keyword / regex / semantic
+	Pattern   string  `gorm:"type:text" json:"pattern"`  // keywords separated by "|", a regex, or example text
+	Threshold float64 `json:"threshold"`                 // cosine similarity for semantic rules
+	Field     string  `gorm:"size:50" json:"field"`      // field to rewrite for post rules
+	Value     string  `gorm:"type:text" json:"value"`    // fixed answer / rewritten value
+	Summary   string  `gorm:"type:text" json:"summary"`  // optional summary for short-circuit answers
+	// Per-locale value and summary, e.g. {"en": {"value": "...", "summary": "..."}}
+	Translations JSONB `gorm:"type:jsonb" json:"translations,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookSubscription receives signed event notifications, see internal/webhook
+type WebhookSubscription struct {
+	ID          uint   `gorm:"primaryKey" json:"id"`
+	URL         string `gorm:"size:2048" json:"url"`
+	Events      string `gorm:"size:255" json:"events"`         // comma-separated event names; "*" or "divination.*" match several
+	UserID      *uint  `gorm:"index" json:"user_id,omitempty"` // only this user's tasks; nil for every task
+	Secret      string `gorm:"size:128" json:"-"`              // HMAC key, returned once on creation
+	Description string `gorm:"size:255" json:"description"`
+	Enabled     bool   `json:"enabled"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one event sent to one subscription, kept as the delivery log
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	SubscriptionID uint       `gorm:"index" json:"subscription_id"`
+	Event          string     `gorm:"size:64;index" json:"event"`
+	TaskID         string     `gorm:"size:64;index" json:"task_id,omitempty"`
+	Data           string     `gorm:"type:text" json:"data"`            // event data (JSON), identical on every attempt
+	Status         string     `gorm:"size:20;index" json:"status"`      // pending / succeeded / failed
+	Attempts       int        `json:"attempts"`                         // attempts made so far
+	ResponseCode   int        `json:"response_code,omitempty"`          // HTTP status of the last attempt
+	Error          string     `gorm:"type:text" json:"error,omitempty"` // error of the last attempt
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// QuotaLedger is one use of a metered feature, see internal/quota
+type QuotaLedger struct {
+	ID      uint      `gorm:"primaryKey" json:"id"`
+	Ref     string    `gorm:"size:64;uniqueIndex" json:"ref"`                           // task id, or a request id for synchronous features
+	Subject string    `gorm:"size:128;index:idx_quota_usage,priority:1" json:"subject"` // user:<id>, device:<hash> or ip:<addr>
+	Feature string    `gorm:"size:32;index:idx_quota_usage,priority:2" json:"feature"`
+	Day     time.Time `gorm:"index:idx_quota_usage,priority:3" json:"day"`
+	Plan    string    `gorm:"size:20" json:"plan"`
+	Status  string    `gorm:"size:20" json:"status"` // reserved / committed / refunded
 
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -0,0 +1,21 @@
+package divination
+
+import "time"
+
+var (
+	heavenlyStems   = []string{"甲", "乙", "丙", "丁", "戊", "己", "庚", "辛", "壬", "癸"}
+	earthlyBranches = []string{"子", "丑", "寅", "卯", "辰", "巳", "午", "未", "申", "酉", "戌", "亥"}
+)
+
+// 1900-01-01 为甲戌日，即六十甲子中的第 10 位（0 起算）
+var dayPillarEpoch = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const dayPillarEpochIndex = 10
+
+// DayPillar 返回某个公历日期的日柱（干支），按日历日计算，不考虑子时换日
+func DayPillar(t time.Time) string {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(day.Sub(dayPillarEpoch).Hours() / 24)
+	idx := ((days+dayPillarEpochIndex)%60 + 60) % 60
+	return heavenlyStems[idx%10] + earthlyBranches[idx%12]
+}
@@ -0,0 +1,92 @@
+package divination
+
+// Hexagram 卦名的多语言信息（序号为文王卦序）
+type Hexagram struct {
+	Number      int    `json:"number"`
+	Name        string `json:"name"`
+	Traditional string `json:"traditional"`
+	Pinyin      string `json:"pinyin"`
+	English     string `json:"english"`
+}
+
+var hexagrams = []Hexagram{
+	{1, "乾", "乾", "Qián", "The Creative"},
+	{2, "坤", "坤", "Kūn", "The Receptive"},
+	{3, "屯", "屯", "Zhūn", "Difficulty at the Beginning"},
+	{4, "蒙", "蒙", "Méng", "Youthful Folly"},
+	{5, "需", "需", "Xū", "Waiting"},
+	{6, "讼", "訟", "Sòng", "Conflict"},
+	{7, "师", "師", "Shī", "The Army"},
+	{8, "比", "比", "Bǐ", "Holding Together"},
+	{9, "小畜", "小畜", "Xiǎo Chù", "Small Taming"},
+	{10, "履", "履", "Lǚ", "Treading"},
+	{11, "泰", "泰", "Tài", "Peace"},
+	{12, "否", "否", "Pǐ", "Standstill"},
+	{13, "同人", "同人", "Tóng Rén", "Fellowship"},
+	{14, "大有", "大有", "Dà Yǒu", "Great Possession"},
+	{15, "谦", "謙", "Qiān", "Modesty"},
+	{16, "豫", "豫", "Yù", "Enthusiasm"},
+	{17, "随", "隨", "Suí", "Following"},
+	{18, "蛊", "蠱", "Gǔ", "Work on the Decayed"},
+	{19, "临", "臨", "Lín", "Approach"},
+	{20, "观", "觀", "Guān", "Contemplation"},
+	{21, "噬嗑", "噬嗑", "Shì Kè", "Biting Through"},
+	{22, "贲", "賁", "Bì", "Grace"},
+	{23, "剥", "剝", "Bō", "Splitting Apart"},
+	{24, "复", "復", "Fù", "Return"},
+	{25, "无妄", "無妄", "Wú Wàng", "Innocence"},
+	{26, "大畜", "大畜", "Dà Chù", "Great Taming"},
+	{27, "颐", "頤", "Yí", "Nourishment"},
+	{28, "大过", "大過", "Dà Guò", "Great Exceeding"},
+	{29, "坎", "坎", "Kǎn", "The Abysmal (Water)"},
+	{30, "离", "離", "Lí", "The Clinging (Fire)"},
+	{31, "咸", "咸", "Xián", "Influence"},
+	{32, "恒", "恆", "Héng", "Duration"},
+	{33, "遁", "遯", "Dùn", "Retreat"},
+	{34, "大壮", "大壯", "Dà Zhuàng", "Great Power"},
+	{35, "晋", "晉", "Jìn", "Progress"},
+	{36, "明夷", "明夷", "Míng Yí", "Darkening of the Light"},
+	{37, "家人", "家人", "Jiā Rén", "The Family"},
+	{38, "睽", "睽", "Kuí", "Opposition"},
+	{39, "蹇", "蹇", "Jiǎn", "Obstruction"},
+	{40, "解", "解", "Xiè", "Deliverance"},
+	{41, "损", "損", "Sǔn", "Decrease"},
+	{42, "益", "益", "Yì", "Increase"},
+	{43, "夬", "夬", "Guài", "Breakthrough"},
+	{44, "姤", "姤", "Gòu", "Coming to Meet"},
+	{45, "萃", "萃", "Cuì", "Gathering Together"},
+	{46, "升", "升", "Shēng", "Pushing Upward"},
+	{47, "困", "困", "Kùn", "Oppression"},
+	{48, "井", "井", "Jǐng", "The Well"},
+	{49, "革", "革", "Gé", "Revolution"},
+	{50, "鼎", "鼎", "Dǐng", "The Cauldron"},
+	{51, "震", "震", "Zhèn", "The Arousing (Thunder)"},
+	{52, "艮", "艮", "Gèn", "Keeping Still (Mountain)"},
+	{53, "渐", "漸", "Jiàn", "Development"},
+	{54, "归妹", "歸妹", "Guī Mèi", "The Marrying Maiden"},
+	{55, "丰", "豐", "Fēng", "Abundance"},
+	{56, "旅", "旅", "Lǚ", "The Wanderer"},
+	{57, "巽", "巽", "Xùn", "The Gentle (Wind)"},
+	{58, "兑", "兌", "Duì", "The Joyous (Lake)"},
+	{59, "涣", "渙", "Huàn", "Dispersion"},
+	{60, "节", "節", "Jié", "Limitation"},
+	{61, "中孚", "中孚", "Zhōng Fú", "Inner Truth"},
+	{62, "小过", "小過", "Xiǎo Guò", "Small Exceeding"},
+	{63, "既济", "既濟", "Jì Jì", "After Completion"},
+	{64, "未济", "未濟", "Wèi Jì", "Before Completion"},
+}
+
+var hexagramByName = func() map[string]Hexagram {
+	m := make(map[string]Hexagram, len(hexagrams)*2)
+	for _, h := range hexagrams {
+		m[h.Name] = h
+		m[h.Traditional] = h
+	}
+	return m
+}()
+
+// LookupHexagram 按卦名（简体或繁体）查找卦的多语言信息
+func LookupHexagram(name string) (Hexagram, bool) {
+	h, ok := hexagramByName[name]
+	return h, ok
+}
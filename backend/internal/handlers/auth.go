@@ -11,6 +11,9 @@ import (
 	"fromheart/internal/auth"
 	"fromheart/internal/config"
 	"fromheart/internal/db"
+	"fromheart/internal/i18n"
+	"fromheart/internal/middleware"
+	"fromheart/internal/persona"
 )
 
 type AuthHandler struct {
@@ -30,7 +33,7 @@ type RegisterRequest struct {
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
@@ -38,14 +41,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	var count int64
 	h.DB.Model(&db.User{}).Where("username = ?", req.Username).Count(&count)
 	if count > 0 {
-		c.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
+		middleware.AbortWithError(c, http.StatusConflict, i18n.MsgUsernameTaken)
 		return
 	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
@@ -56,14 +59,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	if err := h.DB.Create(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
 	// Generate token
 	token, err := auth.GenerateToken(user.ID, h.Config.JWTSecret)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
@@ -97,24 +100,24 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
 	var user db.User
 	if err := h.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		middleware.AbortWithError(c, http.StatusUnauthorized, i18n.MsgInvalidCredentials)
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		middleware.AbortWithError(c, http.StatusUnauthorized, i18n.MsgInvalidCredentials)
 		return
 	}
 
 	token, err := auth.GenerateToken(user.ID, h.Config.JWTSecret)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
@@ -128,13 +131,13 @@ func (h *AuthHandler) Login(c *gin.Context) {
 func (h *AuthHandler) Me(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.AbortWithError(c, http.StatusUnauthorized, i18n.MsgUnauthorized)
 		return
 	}
 
 	var user db.User
 	if err := h.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgUserNotFound)
 		return
 	}
 
@@ -146,24 +149,26 @@ type UpdateProfileRequest struct {
 	Gender       string `json:"gender"` // male, female, other
 	MBTI         string `json:"mbti"`
 	Zodiac       string `json:"zodiac"`
+	Persona      string `json:"persona"` // default persona key, empty = system default
+	Locale       string `json:"locale"`  // zh-CN / zh-TW / en, empty = follow Accept-Language
 }
 
 func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		middleware.AbortWithError(c, http.StatusUnauthorized, i18n.MsgUnauthorized)
 		return
 	}
 
 	var req UpdateProfileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
 	var user db.User
 	if err := h.DB.First(&user, userID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgUserNotFound)
 		return
 	}
 
@@ -175,33 +180,46 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 		"": true,
 	}
 	if !validZodiacs[req.Zodiac] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid zodiac value"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidZodiac)
 		return
 	}
 
 	validGenders := map[string]bool{"male": true, "female": true, "other": true, "": true}
 	if !validGenders[req.Gender] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gender value"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidGender)
 		return
 	}
 
 	// Simple check for MBTI format or empty
 	if req.MBTI != "" {
 		if len(req.MBTI) != 4 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MBTI format"})
+			middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidMBTI)
 			return
 		}
 		// Could add more regex check here but length check prevents large XSS payloads
 	}
 
+	if !persona.Valid(req.Persona) {
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidPersona)
+		return
+	}
+
+	locale, ok := i18n.Parse(req.Locale)
+	if req.Locale != "" && !ok {
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidLocale)
+		return
+	}
+
 	// Update fields
 	user.BirthDateStr = req.BirthDateStr
 	user.Gender = req.Gender
 	user.MBTI = req.MBTI
 	user.Zodiac = req.Zodiac
+	user.Persona = req.Persona
+	user.Locale = string(locale)
 
 	if err := h.DB.Save(&user).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
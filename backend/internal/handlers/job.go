@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"fromheart/internal/scheduler"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler 管理后台查看与手动触发定时任务
+type JobHandler struct {
+	s           *scheduler.Scheduler
+	adminSecret string
+}
+
+func NewJobHandler(s *scheduler.Scheduler, adminSecret string) *JobHandler {
+	return &JobHandler{s: s, adminSecret: adminSecret}
+}
+
+// List 返回所有定时任务、下次运行时间与最近一次运行的结果
+func (h *JobHandler) List(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	jobs, err := h.s.Jobs(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": jobs})
+}
+
+// Run 立即运行一次定时任务，运行结果见 List
+func (h *JobHandler) Run(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	run, err := h.s.Trigger(c.Request.Context(), c.Param("name"))
+	if errors.Is(err, scheduler.ErrUnknownJob) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run job"})
+		return
+	}
+	c.JSON(http.StatusAccepted, run)
+}
@@ -6,26 +6,28 @@ import (
 	"net/http"
 	"strconv"
 
-	"fromheart/internal/adapters/llm"
-	"fromheart/internal/db"
+	"fromheart/internal/i18n"
+	"fromheart/internal/middleware"
+	"fromheart/internal/persona"
+	"fromheart/internal/postprocess"
 	"fromheart/internal/queue"
+	"fromheart/internal/quota"
 	"fromheart/internal/services"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
 type LoveHandler struct {
-	db          *gorm.DB
-	llm         llm.Client
+	ls          *services.LoveService
 	qs          *services.QuestionService
-	q           *queue.Queue
+	q           queue.Queue
+	quota       *quota.Engine
 	adminSecret string
 }
 
-func NewLoveHandler(db *gorm.DB, llm llm.Client, qs *services.QuestionService, q *queue.Queue, adminSecret string) *LoveHandler {
-	return &LoveHandler{db: db, llm: llm, qs: qs, q: q, adminSecret: adminSecret}
+func NewLoveHandler(ls *services.LoveService, qs *services.QuestionService, q queue.Queue, quotas *quota.Engine, adminSecret string) *LoveHandler {
+	return &LoveHandler{ls: ls, qs: qs, q: q, quota: quotas, adminSecret: adminSecret}
 }
 
 type LoveSubmission struct {
@@ -37,36 +39,54 @@ type LoveSubmission struct {
 	GenderB    string `json:"gender_b" binding:"required"`
 	BirthDateB string `json:"birth_date_b"`
 	Story      string `json:"story" binding:"required,max=500"`
+	Persona    string `json:"persona"`
 }
 
 func (h *LoveHandler) Submit(c *gin.Context) {
 	var req LoveSubmission
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 	if req.DeviceHash == "" {
-		req.DeviceHash = "anonymous"
+		req.DeviceHash = services.AnonymousDevice
+	}
+	if !persona.Valid(req.Persona) {
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidPersona)
+		return
 	}
 
-	// Async Enqueue
+	// Reserve quota under the task ID; the worker commits it on success and refunds it on failure
+	ctx := c.Request.Context()
 	taskID := uuid.New().String()
+	sub, reserved, ok := middleware.ReserveQuota(c, h.quota, middleware.QuotaCaller(c, req.DeviceHash), quota.FeatureLove, taskID)
+	if !ok {
+		return
+	}
+
+	// Async Enqueue
 	payloadData, _ := json.Marshal(req)
 
 	taskPayload := queue.TaskPayload{
 		Type:       queue.TypeLove,
 		Data:       payloadData,
+		UserID:     currentUserID(c),
 		DeviceHash: req.DeviceHash,
+		Locale:     string(middleware.GetLocale(c)),
+		Lane:       taskLane(currentUserID(c), sub.Plan == quota.PlanAdmin),
 	}
 
-	if err := h.q.Enqueue(c.Request.Context(), taskID, taskPayload); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue task"})
+	if err := h.q.Enqueue(ctx, taskID, taskPayload); err != nil {
+		if reserved {
+			h.quota.Refund(ctx, taskID)
+		}
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
 	c.JSON(http.StatusAccepted, gin.H{
 		"task_id": taskID,
-		"message": "姻缘推演请求已受理，请稍候...",
+		"message": i18n.T(middleware.GetLocale(c), i18n.MsgLoveAccepted),
 	})
 }
 
@@ -74,13 +94,13 @@ func (h *LoveHandler) Submit(c *gin.Context) {
 func (h *LoveHandler) GetHistory(c *gin.Context) {
 	hash := c.Query("device_hash")
 	if hash == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "device_hash required"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgDeviceHashRequired)
 		return
 	}
 
-	var probes []db.LoveProbe
-	if err := h.db.Where("device_hash = ?", hash).Order("created_at desc").Limit(20).Find(&probes).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "fetch failed"})
+	probes, err := h.ls.History(c.Request.Context(), hash, 20)
+	if err != nil {
+		internalError(c, err)
 		return
 	}
 
@@ -101,8 +121,8 @@ func (h *LoveHandler) AdminList(c *gin.Context) {
 		return
 	}
 
-	var probes []db.LoveProbe
-	if err := h.db.Order("created_at desc").Limit(100).Find(&probes).Error; err != nil {
+	probes, err := h.ls.List(c.Request.Context(), 100)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch records"})
 		return
 	}
@@ -112,9 +132,10 @@ func (h *LoveHandler) AdminList(c *gin.Context) {
 	// Ideally, we return the parsed data.
 	var result []map[string]interface{}
 	for _, probe := range probes {
-		var analysis map[string]interface{}
+		var analysis *postprocess.LoveAnalysis
 		if probe.FinalResponse != "" {
-			_ = json.Unmarshal([]byte(probe.FinalResponse), &analysis)
+			a := postprocess.NormalizeLove(probe.FinalResponse, i18n.Resolve(probe.Locale, middleware.GetLocale(c)))
+			analysis = &a
 		}
 
 		item := map[string]interface{}{
@@ -141,13 +162,13 @@ func (h *LoveHandler) GetDetail(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 
 	probe, err := h.qs.GetLoveProbe(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgNotFound)
 		return
 	}
 
@@ -160,10 +181,11 @@ func (h *LoveHandler) GetDetail(c *gin.Context) {
 		// return
 	}
 
-	// Parse JSON
-	var analysis map[string]interface{}
+	// Parse & validate stored analysis (older rows were stored untyped)
+	var analysis *postprocess.LoveAnalysis
 	if probe.FinalResponse != "" {
-		_ = json.Unmarshal([]byte(probe.FinalResponse), &analysis)
+		a := postprocess.NormalizeLove(probe.FinalResponse, i18n.Resolve(probe.Locale, middleware.GetLocale(c)))
+		analysis = &a
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -180,32 +202,34 @@ func (h *LoveHandler) GetDetail(c *gin.Context) {
 type loveChatRequest struct {
 	Message string                 `json:"message" binding:"required"`
 	History []services.ChatMessage `json:"history"`
+	Persona string                 `json:"persona"`
 }
 
 func (h *LoveHandler) Chat(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 
 	var req loveChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
 	// Verify exists
 	_, err = h.qs.GetLoveProbe(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgNotFound)
 		return
 	}
 
-	response, err := h.qs.ChatLove(c.Request.Context(), uint(id), req.Message, req.History)
+	opts := h.qs.ResolveAnswerOptions(c.Request.Context(), req.Persona, middleware.GetLocale(c), currentUserID(c))
+	response, err := h.qs.ChatLove(c.Request.Context(), uint(id), req.Message, req.History, opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, err)
 		return
 	}
 
@@ -218,20 +242,20 @@ func (h *LoveHandler) ChatStream(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 
 	var req loveChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
 	// Verify exists
 	_, err = h.qs.GetLoveProbe(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgNotFound)
 		return
 	}
 
@@ -240,11 +264,16 @@ func (h *LoveHandler) ChatStream(c *gin.Context) {
 	c.Header("Connection", "keep-alive")
 	c.Header("Transfer-Encoding", "chunked")
 
-	h.qs.ChatLoveStream(c.Request.Context(), uint(id), req.Message, req.History, func(token string) {
+	opts := h.qs.ResolveAnswerOptions(c.Request.Context(), req.Persona, middleware.GetLocale(c), currentUserID(c))
+	err = h.qs.ChatLoveStream(c.Request.Context(), uint(id), req.Message, req.History, opts, func(token string) {
 		chunk, _ := json.Marshal(gin.H{"content": token})
 		fmt.Fprintf(c.Writer, "data: %s\n\n", chunk)
 		c.Writer.Flush()
 	})
+	if err != nil {
+		// The status is already 200: record the failure so the quota middleware refunds this follow-up
+		c.Error(err)
+	}
 
 	fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
 	c.Writer.Flush()
@@ -3,10 +3,17 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"fromheart/internal/i18n"
+	"fromheart/internal/middleware"
+	"fromheart/internal/persona"
 	"fromheart/internal/queue"
+	"fromheart/internal/quota"
 	"fromheart/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -15,27 +22,34 @@ import (
 
 type QuestionHandler struct {
 	service *services.QuestionService
-	q       *queue.Queue
+	q       queue.Queue
+	quota   *quota.Engine
 }
 
-func NewQuestionHandler(service *services.QuestionService, q *queue.Queue) *QuestionHandler {
-	return &QuestionHandler{service: service, q: q}
+func NewQuestionHandler(service *services.QuestionService, q queue.Queue, quotas *quota.Engine) *QuestionHandler {
+	return &QuestionHandler{service: service, q: q, quota: quotas}
 }
 
 type askRequest struct {
 	Question   string `json:"question"`
 	DeviceHash string `json:"device_hash"`
-	Secret     string `json:"secret"`
+	Secret     string `json:"secret"`  // Admin secret, puts the request on the admin plan
+	Persona    string `json:"persona"` // Optional per-question persona override
+	Locale     string `json:"locale"`  // Filled from Accept-Language, not trusted from the body
 }
 
 func (h *QuestionHandler) Ask(c *gin.Context) {
 	var req askRequest
 	if err := c.ShouldBindJSON(&req); err != nil || req.Question == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 	if req.DeviceHash == "" {
-		req.DeviceHash = "anonymous"
+		req.DeviceHash = services.AnonymousDevice
+	}
+	if !persona.Valid(req.Persona) {
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidPersona)
+		return
 	}
 
 	userIDVal, _ := c.Get("userID")
@@ -44,21 +58,62 @@ func (h *QuestionHandler) Ask(c *gin.Context) {
 		userID = &id
 	}
 
-	// 1. Pre-check Limit (Synchronous)
-	if req.Secret != "loveriver" {
-		count, err := h.service.GetTodayQuestionCount(c.Request.Context(), req.DeviceHash, userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "limit check failed"})
+	ctx := c.Request.Context()
+	identity := requestIdentity(c, req.DeviceHash)
+	normalized := services.NormalizeQuestion(req.Question)
+	castHour := time.Now().Truncate(time.Hour)
+
+	// Guests without a device hash share one identity: never hand them each other's readings
+	identified := userID != nil || req.DeviceHash != services.AnonymousDevice
+
+	// 0. 一事不二占: the same matter asked again within the casting hour gets the earlier reading
+	if identified {
+		if div, err := h.service.FindSameMatter(ctx, req.DeviceHash, userID, normalized, castHour); err == nil && div != nil {
+			c.JSON(http.StatusOK, gin.H{"divination_id": div.ID, "result": div.Interpretation, "duplicate": true})
+			return
+		}
+	}
+
+	// 1. Deduplicate submissions: Idempotency-Key header plus a key derived from the matter itself
+	var keys []queue.IdempotencyKey
+	if identified {
+		keys = append(keys, queue.NewIdempotencyKey(time.Until(castHour.Add(time.Hour))+time.Minute, "question", identity, normalized, castHour.Format(time.RFC3339)))
+	}
+	if key := strings.TrimSpace(c.GetHeader("Idempotency-Key")); key != "" && identified {
+		keys = append(keys, queue.NewIdempotencyKey(idempotencyHeaderTTL, "header", identity, key))
+	}
+	taskID := uuid.New().String()
+	for attempt := 0; ; attempt++ {
+		existingID, err := h.q.Claim(ctx, taskID, keys...)
+		if err != nil || (existingID != "" && attempt == maxClaimAttempts) {
+			middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 			return
 		}
-		if count >= 10 {
-			c.JSON(http.StatusForbidden, gin.H{"error": "daily_limit_reached", "message": "不可贪念天机"})
+		if existingID == "" {
+			break
+		}
+		if h.respondExistingTask(c, existingID) {
 			return
 		}
+		// The earlier task failed or expired: let this submission take over its keys
+		h.q.ReleaseClaim(ctx, existingID, keys...)
 	}
 
-	// 2. Enqueue Task (Asynchronous)
-	taskID := uuid.New().String()
+	// 2. Reserve quota under the task ID; the worker commits it on success and refunds it on failure
+	caller := middleware.QuotaCaller(c, req.DeviceHash)
+	if req.Secret != "" {
+		caller.Secret = req.Secret
+	}
+	sub, reserved, ok := middleware.ReserveQuota(c, h.quota, caller, quota.FeatureQuestion, taskID)
+	if !ok {
+		h.q.ReleaseClaim(ctx, taskID, keys...)
+		return
+	}
+
+	// 3. Enqueue Task (Asynchronous)
+	req.Locale = string(middleware.GetLocale(c))
+	// The secret was only needed for the quota check, keep it out of the queue
+	req.Secret = ""
 	payloadData, _ := json.Marshal(req) // We can reuse askRequest as payload data
 	
 	taskPayload := queue.TaskPayload{
@@ -66,29 +121,35 @@ func (h *QuestionHandler) Ask(c *gin.Context) {
 		Data:       payloadData,
 		UserID:     userID,
 		DeviceHash: req.DeviceHash,
+		Locale:     req.Locale,
+		Lane:       taskLane(userID, sub.Plan == quota.PlanAdmin),
 	}
 
-	if err := h.q.Enqueue(c.Request.Context(), taskID, taskPayload); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enqueue task"})
+	if err := h.q.Enqueue(ctx, taskID, taskPayload); err != nil {
+		h.q.ReleaseClaim(ctx, taskID, keys...)
+		if reserved {
+			h.quota.Refund(ctx, taskID)
+		}
+		middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
 		return
 	}
 
 	// Return Task ID immediately
 	c.JSON(http.StatusAccepted, gin.H{
 		"task_id": taskID,
-		"message": "请求已受理，正在推演中...",
+		"message": i18n.T(middleware.GetLocale(c), i18n.MsgQuestionAccepted),
 	})
 }
 
 func (h *QuestionHandler) GetDivination(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 	div, err := h.service.GetDivination(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgNotFound)
 		return
 	}
 
@@ -125,14 +186,14 @@ func (h *QuestionHandler) GetDivination(c *gin.Context) {
 		if div.DailyQuestion != nil && div.DailyQuestion.UserID != nil {
 			if *div.DailyQuestion.UserID != currentUserID {
 				// Access Denied
-				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+				middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
 				return
 			}
 		}
 	} else {
 		// If user is not logged in, they should NOT access records that belong to registered users
 		if div.DailyQuestion != nil && div.DailyQuestion.UserID != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
 			return
 		}
 	}
@@ -150,13 +211,13 @@ func (h *QuestionHandler) History(c *gin.Context) {
 	}
 
 	if deviceHash == "" && userID == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "device_hash required"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgDeviceHashRequired)
 		return
 	}
 
-	history, err := h.service.GetUnifiedHistory(c.Request.Context(), deviceHash, userID, 50)
+	history, err := h.service.GetUnifiedHistory(c.Request.Context(), deviceHash, userID, 50, middleware.GetLocale(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"items": history})
@@ -171,32 +232,35 @@ func (h *QuestionHandler) GetUsage(c *gin.Context) {
 	}
 
 	if device == "" && userID == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "device_hash required"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgDeviceHashRequired)
 		return
 	}
 
-	// Device ownership verification logic (Simplified)
-	// If user is logged in, they should not be able to query another user's device usage just by changing param.
-	// For now, if userID is present, we ignore device hash or ensure it matches.
-	// Since usage is tracked by Key (device:...) in Redis logic usually,
-	// let's ensure logged-in users only see their own usage.
-	// We might need to bind device_hash to user in DB, but short-term fix:
-	// If userID is present, we rely on userID-based limits in service layer.
-	// If anonymous, they can only query their own hash (which is hard to verify without cookie/session,
-	// but prevents logged-in users from snooping anonymous hashes easily if we separate logic).
-
-	count, err := h.service.GetTodayQuestionCount(c.Request.Context(), device, userID)
+	// Logged-in users always see their own account's usage, never another device's
+	sub, err := h.quota.Resolve(c.Request.Context(), middleware.QuotaCaller(c, device))
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	usage, err := h.quota.Usage(c.Request.Context(), sub)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"count": count})
+	// count keeps the question count at the top level for older clients
+	var count int
+	for _, u := range usage {
+		if u.Feature == quota.FeatureQuestion {
+			count = u.Used
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"count": count, "plan": sub.Plan, "features": usage})
 }
 
 func (h *QuestionHandler) GetBlessing(c *gin.Context) {
 	blessing, err := h.service.GetBlessing(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"blessing": blessing})
@@ -205,7 +269,7 @@ func (h *QuestionHandler) GetBlessing(c *gin.Context) {
 func (h *QuestionHandler) GetPoem(c *gin.Context) {
 	poem, err := h.service.GetDailyPoem(c.Request.Context())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"poem": poem})
@@ -233,26 +297,27 @@ func (h *QuestionHandler) AdminAllHistory(c *gin.Context) {
 type chatRequest struct {
 	Message string                 `json:"message" binding:"required"`
 	History []services.ChatMessage `json:"history"`
+	Persona string                 `json:"persona"`
 }
 
 func (h *QuestionHandler) Chat(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.ParseUint(idStr, 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 
 	var req chatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
 	// ACCESS CONTROL: Verify ownership before processing chat
 	div, err := h.service.GetDivination(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgNotFound)
 		return
 	}
 
@@ -261,21 +326,22 @@ func (h *QuestionHandler) Chat(c *gin.Context) {
 		currentUserID := userIDVal.(uint)
 		if div.DailyQuestion != nil && div.DailyQuestion.UserID != nil {
 			if *div.DailyQuestion.UserID != currentUserID {
-				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: You do not own this divination record"})
+				middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
 				return
 			}
 		}
 	} else {
 		// Anonymous users cannot access Registered User's records
 		if div.DailyQuestion != nil && div.DailyQuestion.UserID != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: Record belongs to a registered user"})
+			middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
 			return
 		}
 	}
 	
-	response, err := h.service.Chat(c.Request.Context(), uint(id), req.Message, req.History)
+	opts := h.service.ResolveAnswerOptions(c.Request.Context(), req.Persona, middleware.GetLocale(c), currentUserID(c))
+	response, err := h.service.Chat(c.Request.Context(), uint(id), req.Message, req.History, opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		internalError(c, err)
 		return
 	}
 
@@ -287,20 +353,20 @@ func (h *QuestionHandler) Chat(c *gin.Context) {
 func (h *QuestionHandler) ChatStream(c *gin.Context) {
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 
 	var req chatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
 	// ACCESS CONTROL: Verify ownership before processing chat
 	div, err := h.service.GetDivination(c.Request.Context(), uint(id))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgNotFound)
 		return
 	}
 
@@ -309,14 +375,14 @@ func (h *QuestionHandler) ChatStream(c *gin.Context) {
 		currentUserID := userIDVal.(uint)
 		if div.DailyQuestion != nil && div.DailyQuestion.UserID != nil {
 			if *div.DailyQuestion.UserID != currentUserID {
-				c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: You do not own this divination record"})
+				middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
 				return
 			}
 		}
 	} else {
 		// Anonymous users cannot access Registered User's records
 		if div.DailyQuestion != nil && div.DailyQuestion.UserID != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: Record belongs to a registered user"})
+			middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
 			return
 		}
 	}
@@ -327,12 +393,86 @@ func (h *QuestionHandler) ChatStream(c *gin.Context) {
 	c.Header("Transfer-Encoding", "chunked")
 
 	// Use streaming service
-	h.service.ChatStream(c.Request.Context(), uint(id), req.Message, req.History, func(token string) {
+	opts := h.service.ResolveAnswerOptions(c.Request.Context(), req.Persona, middleware.GetLocale(c), currentUserID(c))
+	err = h.service.ChatStream(c.Request.Context(), uint(id), req.Message, req.History, opts, func(token string) {
 		chunk, _ := json.Marshal(gin.H{"content": token})
 		fmt.Fprintf(c.Writer, "data: %s\n\n", chunk)
 		c.Writer.Flush()
 	})
+	if err != nil {
+		// The status is already 200: record the failure so the quota middleware refunds this follow-up
+		c.Error(err)
+	}
 
 	fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
 	c.Writer.Flush()
 }
+
+const (
+	// idempotencyHeaderTTL is how long a client-supplied Idempotency-Key is remembered
+	idempotencyHeaderTTL = 24 * time.Hour
+	// maxClaimAttempts bounds how often a submission retries taking over a failed task's keys
+	maxClaimAttempts = 2
+)
+
+// respondExistingTask answers a repeated submission with the task it duplicates:
+// the result if it completed, otherwise the task ID to keep polling. It returns
+// false when the earlier task failed, was cancelled or is gone, so a new one should be queued.
+func (h *QuestionHandler) respondExistingTask(c *gin.Context, taskID string) bool {
+	status, err := h.q.GetStatus(c.Request.Context(), taskID)
+	if err != nil {
+		return false
+	}
+	switch status.Status {
+	case queue.StatusCompleted:
+		c.JSON(http.StatusOK, status.Result)
+	case queue.StatusFailed, queue.StatusCancelled:
+		return false
+	default:
+		c.JSON(http.StatusAccepted, gin.H{
+			"task_id":   taskID,
+			"duplicate": true,
+			"message":   i18n.T(middleware.GetLocale(c), i18n.MsgQuestionAccepted),
+		})
+	}
+	return true
+}
+
+// taskLane picks the queue priority lane: the secret bypass goes first, then registered users
+func taskLane(userID *uint, bypass bool) queue.Lane {
+	switch {
+	case bypass:
+		return queue.LaneAdmin
+	case userID != nil:
+		return queue.LaneRegistered
+	default:
+		return queue.LaneGuest
+	}
+}
+
+// ListPersonas returns the selectable master personas
+func (h *QuestionHandler) ListPersonas(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"items": persona.List(), "default": persona.DefaultKey})
+}
+
+// requestIdentity resolves who is calling the same way queued tasks are attributed:
+// the logged-in user, otherwise the client's device hash. Callers with neither get
+// queue.AnonymousIdentity, which owns no task.
+func requestIdentity(c *gin.Context, deviceHash string) string {
+	return queue.Identity(queue.TaskPayload{UserID: currentUserID(c), DeviceHash: deviceHash})
+}
+
+// currentUserID returns the logged-in user's ID, or nil for guests
+func currentUserID(c *gin.Context) *uint {
+	userIDVal, _ := c.Get("userID")
+	if id, ok := userIDVal.(uint); ok {
+		return &id
+	}
+	return nil
+}
+
+// internalError logs err and answers with a localized 500 that does not expose it
+func internalError(c *gin.Context, err error) {
+	log.Printf("[API] %s %s failed: %v", c.Request.Method, c.FullPath(), err)
+	middleware.AbortWithError(c, http.StatusInternalServerError, i18n.MsgInternalError)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"fromheart/internal/quota"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// QuotaHandler shows the quota plans and assigns them to users (admin only)
+type QuotaHandler struct {
+	quota       *quota.Engine
+	adminSecret string
+}
+
+func NewQuotaHandler(quotas *quota.Engine, adminSecret string) *QuotaHandler {
+	return &QuotaHandler{quota: quotas, adminSecret: adminSecret}
+}
+
+// Plans lists every plan's daily limits (-1 means unlimited)
+func (h *QuotaHandler) Plans(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": h.quota.Limits()})
+}
+
+type setPlanRequest struct {
+	Plan string `json:"plan"` // registered / vip; empty resets to registered
+}
+
+// SetUserPlan assigns a plan to a registered user
+func (h *QuotaHandler) SetUserPlan(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var req setPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = h.quota.SetPlan(c.Request.Context(), uint(id), req.Plan)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, quota.ErrInvalidPlan):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update plan"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"id": id, "plan": req.Plan})
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"fromheart/internal/db"
+	"fromheart/internal/rules"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type RuleHandler struct {
+	db          *gorm.DB
+	engine      *rules.Engine
+	adminSecret string
+}
+
+func NewRuleHandler(db *gorm.DB, engine *rules.Engine, adminSecret string) *RuleHandler {
+	return &RuleHandler{db: db, engine: engine, adminSecret: adminSecret}
+}
+
+// checkAdminSecret validates X-Admin-Secret (or ?secret=) and writes 401 on failure
+func checkAdminSecret(c *gin.Context, adminSecret string) bool {
+	secret := c.GetHeader("X-Admin-Secret")
+	if secret == "" {
+		secret = c.Query("secret")
+	}
+	if adminSecret == "" || secret != adminSecret {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return false
+	}
+	return true
+}
+
+func (h *RuleHandler) List(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	var items []db.AnswerRule
+	if err := h.db.Order("priority desc, id asc").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func (h *RuleHandler) Create(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	var rule db.AnswerRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.ID = 0
+	if err := rules.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create rule"})
+		return
+	}
+	h.engine.Invalidate()
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *RuleHandler) Update(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var existing db.AnswerRule
+	if err := h.db.First(&existing, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+
+	var rule db.AnswerRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	if err := rules.Validate(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rule"})
+		return
+	}
+	h.engine.Invalidate()
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *RuleHandler) Delete(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.db.Delete(&db.AnswerRule{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rule"})
+		return
+	}
+	h.engine.Invalidate()
+	c.Status(http.StatusNoContent)
+}
@@ -1,33 +1,244 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
+	"fromheart/internal/i18n"
+	"fromheart/internal/middleware"
 	"fromheart/internal/queue"
+	"fromheart/internal/quota"
 
 	"github.com/gin-gonic/gin"
 )
 
 type TaskHandler struct {
-	q *queue.Queue
+	q           queue.Queue
+	quota       *quota.Engine
+	adminSecret string
 }
 
-func NewTaskHandler(q *queue.Queue) *TaskHandler {
-	return &TaskHandler{q: q}
+func NewTaskHandler(q queue.Queue, quotas *quota.Engine, adminSecret string) *TaskHandler {
+	return &TaskHandler{q: q, quota: quotas, adminSecret: adminSecret}
 }
 
 func (h *TaskHandler) GetStatus(c *gin.Context) {
 	taskID := c.Param("id")
 	if taskID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "id required"})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidID)
 		return
 	}
 
 	status, err := h.q.GetStatus(c.Request.Context(), taskID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgTaskNotFound)
 		return
 	}
+	// 结果中包含占卜内容，只返回给提交者
+	if !status.OwnedBy(requestIdentity(c, c.Query("device_hash"))) {
+		status = status.Redacted()
+	}
 
 	c.JSON(http.StatusOK, status)
 }
+
+// Cancel 撤回自己提交的任务：排队中的直接移除，执行中的通知 Worker 中断 LLM 调用。
+// 提交时预占的额度在任务被取消时退还：排队中的在此退还，执行中的由 Worker 退还。
+func (h *TaskHandler) Cancel(c *gin.Context) {
+	taskID := c.Param("id")
+	identity := requestIdentity(c, c.Query("device_hash"))
+
+	status, err := h.q.GetStatus(c.Request.Context(), taskID)
+	if err != nil {
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgTaskNotFound)
+		return
+	}
+	if !status.OwnedBy(identity) {
+		middleware.AbortWithError(c, http.StatusForbidden, i18n.MsgForbidden)
+		return
+	}
+
+	outcome, err := h.q.Cancel(c.Request.Context(), taskID, identity)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	switch outcome {
+	case queue.CancelRemoved:
+		if err := h.quota.Refund(context.WithoutCancel(c.Request.Context()), taskID); err != nil {
+			log.Printf("[Quota] refund cancelled task %s failed: %v", taskID, err)
+		}
+		c.JSON(http.StatusOK, gin.H{"task_id": taskID, "status": queue.StatusCancelled})
+	case queue.CancelSignalled:
+		// 最终状态由 Worker 写入；若推演恰好已经完成，则保持 completed
+		c.JSON(http.StatusAccepted, gin.H{"task_id": taskID, "status": queue.StatusProcessing, "cancelling": true})
+	default:
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   i18n.MsgTaskFinished,
+			"message": i18n.T(middleware.GetLocale(c), i18n.MsgTaskFinished),
+			"status":  status.Status,
+		})
+	}
+}
+
+const (
+	// eventsHeartbeat keeps proxies from closing an idle event stream
+	eventsHeartbeat = 15 * time.Second
+	// eventsPositionRefresh re-sends queue position and ETA while the task waits
+	eventsPositionRefresh = 3 * time.Second
+	// eventsMaxDuration matches how long task status is kept in Redis
+	eventsMaxDuration = queue.TaskExpiration
+)
+
+// Events 以 SSE 推送任务状态变化，直到任务完成或失败，替代客户端轮询。
+// 非提交者只能看到状态本身，与 GetStatus 一致。
+func (h *TaskHandler) Events(c *gin.Context) {
+	taskID := c.Param("id")
+	ctx := c.Request.Context()
+	identity := requestIdentity(c, c.Query("device_hash"))
+
+	// 先订阅再读取当前状态，保证不会漏掉两者之间的状态变化
+	events, stop, err := h.q.Subscribe(ctx, taskID)
+	if err != nil {
+		internalError(c, err)
+		return
+	}
+	defer stop()
+	status, err := h.q.GetStatus(ctx, taskID)
+	if err != nil {
+		middleware.AbortWithError(c, http.StatusNotFound, i18n.MsgTaskNotFound)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	owned := status.OwnedBy(identity)
+	send := func(st *queue.TaskResult) {
+		if !owned {
+			st = st.Redacted()
+		}
+		chunk, _ := json.Marshal(st)
+		fmt.Fprintf(c.Writer, "data: %s\n\n", chunk)
+		c.Writer.Flush()
+	}
+	defer func() {
+		fmt.Fprintf(c.Writer, "data: [DONE]\n\n")
+		c.Writer.Flush()
+	}()
+
+	send(status)
+	if status.Status.Terminal() {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	refresh := time.NewTicker(eventsPositionRefresh)
+	defer refresh.Stop()
+	deadline := time.After(eventsMaxDuration)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			return
+		case <-refresh.C:
+			// 排队位置没有对应的状态变化事件，定期刷新
+			if status.Status != queue.StatusPending {
+				continue
+			}
+			st, err := h.q.GetStatus(ctx, taskID)
+			if err != nil || st.Status != queue.StatusPending || st.QueuePosition == nil {
+				continue
+			}
+			if status.QueuePosition == nil || *st.QueuePosition != *status.QueuePosition {
+				status = st
+				send(status)
+			}
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": ping\n\n")
+			c.Writer.Flush()
+		case st, ok := <-events:
+			if !ok {
+				return
+			}
+			status = &st
+			send(status)
+			if st.Status.Terminal() {
+				return
+			}
+		}
+	}
+}
+
+// ListDeadLetters 列出重试耗尽的任务（管理员）
+func (h *TaskHandler) ListDeadLetters(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	items, err := h.q.ListDeadLetters(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dead letters"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// GetDeadLetter 查看单条死信，包含完整的任务参数与最后一次错误
+func (h *TaskHandler) GetDeadLetter(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	dl, err := h.q.GetDeadLetter(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, queue.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch dead letter"})
+		return
+	}
+	c.JSON(http.StatusOK, dl)
+}
+
+// ReplayDeadLetter 将死信重新入队，任务 ID 不变，前端可继续轮询原任务。重放的任务不占用用户额度
+func (h *TaskHandler) ReplayDeadLetter(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	err := h.q.ReplayDeadLetter(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, queue.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay dead letter"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"task_id": c.Param("id"), "status": queue.StatusPending})
+}
+
+// PurgeDeadLetters 删除单条（带 :id）或全部死信
+func (h *TaskHandler) PurgeDeadLetters(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	var ids []string
+	if id := c.Param("id"); id != "" {
+		ids = append(ids, id)
+	}
+	n, err := h.q.PurgeDeadLetters(c.Request.Context(), ids...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge dead letters"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purged": n})
+}
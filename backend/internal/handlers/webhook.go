@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"fromheart/internal/db"
+	"fromheart/internal/queue"
+	"fromheart/internal/webhook"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// WebhookHandler manages webhook subscriptions and their delivery log (admin only)
+type WebhookHandler struct {
+	db          *gorm.DB
+	hooks       *webhook.Dispatcher
+	q           queue.Queue
+	adminSecret string
+}
+
+func NewWebhookHandler(db *gorm.DB, hooks *webhook.Dispatcher, q queue.Queue, adminSecret string) *WebhookHandler {
+	return &WebhookHandler{db: db, hooks: hooks, q: q, adminSecret: adminSecret}
+}
+
+type webhookSubscriptionRequest struct {
+	URL         string `json:"url"`
+	Events      string `json:"events"` // comma-separated, e.g. "divination.completed,love_probe.*"; empty for all
+	UserID      *uint  `json:"user_id"`
+	Description string `json:"description"`
+	Enabled     *bool  `json:"enabled"` // defaults to true
+}
+
+func (r webhookSubscriptionRequest) apply(sub *db.WebhookSubscription) {
+	sub.URL = r.URL
+	sub.Events = r.Events
+	sub.UserID = r.UserID
+	sub.Description = r.Description
+	sub.Enabled = r.Enabled == nil || *r.Enabled
+}
+
+func (h *WebhookHandler) List(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	var items []db.WebhookSubscription
+	if err := h.db.Order("id asc").Find(&items).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// Create stores a subscription. The signing secret is generated and only returned here.
+func (h *WebhookHandler) Create(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	var req webhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var sub db.WebhookSubscription
+	req.apply(&sub)
+	if err := webhook.Validate(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sub.Secret = webhook.NewSecret()
+	if err := h.db.Create(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"subscription": sub, "secret": sub.Secret})
+}
+
+// Update replaces a subscription's settings; the secret is kept.
+func (h *WebhookHandler) Update(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	var sub db.WebhookSubscription
+	if err := h.db.First(&sub, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var req webhookSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.apply(&sub)
+	if err := webhook.Validate(&sub); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.db.Save(&sub).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, sub)
+}
+
+func (h *WebhookHandler) Delete(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	if err := h.hooks.DeleteSubscription(c.Request.Context(), uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Ping sends a signed ping event right away and returns the attempt from the delivery log
+func (h *WebhookHandler) Ping(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	delivery, err := h.hooks.Ping(c.Request.Context(), uint(id))
+	if errors.Is(err, webhook.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to ping webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, delivery)
+}
+
+// Deliveries lists the delivery log, newest first (?subscription_id=&status=&limit=)
+func (h *WebhookHandler) Deliveries(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	subID, _ := strconv.Atoi(c.Query("subscription_id"))
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	items, err := h.hooks.Deliveries(c.Request.Context(), uint(subID), c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// Redeliver queues a delivery again with a fresh attempt budget
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	if !checkAdminSecret(c, h.adminSecret) {
+		return
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+		return
+	}
+	err = h.hooks.Redeliver(c.Request.Context(), uint(id))
+	if errors.Is(err, webhook.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err == nil {
+		err = webhook.Enqueue(c.Request.Context(), h.q, uint(id))
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeliver"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"id": id, "status": webhook.StatusPending})
+}
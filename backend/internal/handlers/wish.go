@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"fromheart/internal/db"
+	"fromheart/internal/i18n"
+	"fromheart/internal/middleware"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -22,7 +24,7 @@ func (h *WishHandler) ListWishes(c *gin.Context) {
 	var wishes []db.Wish
 	// Get last 30 wishes
 	if err := h.db.Order("created_at desc").Limit(30).Find(&wishes).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch wishes"})
+		internalError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, wishes)
@@ -36,7 +38,7 @@ func (h *WishHandler) CreateWish(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		middleware.AbortWithError(c, http.StatusBadRequest, i18n.MsgInvalidRequest)
 		return
 	}
 
@@ -48,7 +50,7 @@ func (h *WishHandler) CreateWish(c *gin.Context) {
 	}
 
 	if err := h.db.Create(&wish).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create wish"})
+		internalError(c, err)
 		return
 	}
 
@@ -58,7 +60,7 @@ func (h *WishHandler) CreateWish(c *gin.Context) {
 func (h *WishHandler) BlessWish(c *gin.Context) {
 	id := c.Param("id")
 	if err := h.db.Model(&db.Wish{}).Where("id = ?", id).UpdateColumn("blessing_count", gorm.Expr("blessing_count + ?", 1)).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to bless"})
+		internalError(c, err)
 		return
 	}
 	c.Status(http.StatusOK)
@@ -0,0 +1,90 @@
+package i18n
+
+import (
+	"strings"
+)
+
+// Locale 输出语言，取值遵循 BCP 47 简写
+type Locale string
+
+const (
+	ZhCN Locale = "zh-CN"
+	ZhTW Locale = "zh-TW"
+	En   Locale = "en"
+
+	Default = ZhCN
+)
+
+// Parse 将任意语言标签归一化为受支持的 Locale
+// 例如 "zh-Hant-HK" -> zh-TW, "en-US" -> en, "zh" -> zh-CN
+func Parse(tag string) (Locale, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" {
+		return "", false
+	}
+	switch {
+	case strings.HasPrefix(tag, "en"):
+		return En, true
+	case strings.HasPrefix(tag, "zh-tw"), strings.HasPrefix(tag, "zh-hk"), strings.HasPrefix(tag, "zh-mo"), strings.HasPrefix(tag, "zh-hant"):
+		return ZhTW, true
+	case strings.HasPrefix(tag, "zh"):
+		return ZhCN, true
+	}
+	return "", false
+}
+
+// Valid 判断是否为受支持的 Locale（空字符串视为“未设置”，同样合法）
+func Valid(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	_, ok := Parse(tag)
+	return ok
+}
+
+// FromAcceptLanguage 按 Accept-Language 中出现的顺序选出第一个受支持的语言
+// q 值不做排序，浏览器通常已经按优先级排列
+func FromAcceptLanguage(header string) Locale {
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.SplitN(part, ";", 2)[0]
+		if l, ok := Parse(tag); ok {
+			return l
+		}
+	}
+	return Default
+}
+
+// Resolve 按优先级选择语言：用户资料 > 请求头
+func Resolve(profile string, requested Locale) Locale {
+	if l, ok := Parse(profile); ok {
+		return l
+	}
+	if l, ok := Parse(string(requested)); ok {
+		return l
+	}
+	return Default
+}
+
+// T 返回指定语言的文案，缺失时回退到简体中文，再缺失时返回 key 本身
+func T(l Locale, key string) string {
+	if msgs, ok := catalog[l]; ok {
+		if msg, ok := msgs[key]; ok {
+			return msg
+		}
+	}
+	if msg, ok := catalog[Default][key]; ok {
+		return msg
+	}
+	return key
+}
+
+// PromptInstruction 返回追加到系统提示词末尾的输出语言要求
+func PromptInstruction(l Locale) string {
+	switch l {
+	case En:
+		return "\n【输出语言】请全部使用英文（English）回答。JSON 的键名保持不变，只翻译值。卦名请写作“汉字（拼音, English name）”。涉及数字时使用英文单词。"
+	case ZhTW:
+		return "\n【輸出語言】請全部使用繁體中文回答。JSON 的鍵名保持不變。"
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+package i18n
+
+// Message keys shared by handlers, middleware and postprocess fallbacks
+const (
+	MsgDailyLimitReached     = "daily_limit_reached"
+	MsgDailyChatLimitReached = "daily_chat_limit_reached"
+	MsgDailyLoveLimitReached = "daily_love_limit_reached"
+	MsgTooManyRequests       = "too_many_requests"
+	MsgServerBusy            = "server_busy"
+	MsgQuestionAccepted      = "question_accepted"
+	MsgLoveAccepted          = "love_accepted"
+
+	MsgFallbackDirectAnswer = "fallback_direct_answer"
+	MsgParseFailedAnswer    = "parse_failed_answer"
+	MsgParseFailedAdvice    = "parse_failed_advice"
+	MsgParseFailedWarning   = "parse_failed_warning"
+	MsgParseFailedKeyword   = "parse_failed_keyword"
+
+	MsgLoveFallbackKeyword = "love_fallback_keyword"
+	MsgLoveFallbackBazi    = "love_fallback_bazi"
+	MsgLoveFallbackPoem    = "love_fallback_poem"
+
+	// History summary formats
+	MsgHistoryNoHexagram = "history_no_hexagram"
+	MsgHistoryHexagram   = "history_hexagram"   // %s: hexagram
+	MsgHistoryLoveScore  = "history_love_score" // %d: score, %s: hexagram
+
+	// Handler errors, returned as {"error": key, "message": T(locale, key)}
+	MsgInvalidRequest     = "invalid_request"
+	MsgInvalidID          = "invalid_id"
+	MsgNotFound           = "not_found"
+	MsgForbidden          = "forbidden"
+	MsgUnauthorized       = "unauthorized"
+	MsgLoginRequired      = "login_required"
+	MsgCSRFInvalid        = "csrf_invalid"
+	MsgDeviceHashRequired = "device_hash_required"
+	MsgInternalError      = "internal_error"
+	MsgInvalidPersona     = "invalid_persona"
+	MsgInvalidZodiac      = "invalid_zodiac"
+	MsgInvalidGender      = "invalid_gender"
+	MsgInvalidMBTI        = "invalid_mbti"
+	MsgInvalidLocale      = "invalid_locale"
+	MsgUsernameTaken      = "username_taken"
+	MsgInvalidCredentials = "invalid_credentials"
+	MsgUserNotFound       = "user_not_found"
+	MsgTaskNotFound       = "task_not_found"
+	MsgTaskFinished       = "task_finished"
+)
+
+var catalog = map[Locale]map[string]string{
+	ZhCN: {
+		MsgDailyLimitReached:     "不可贪念天机",
+		MsgDailyChatLimitReached: "今日追问次数已用完，明日再来吧",
+		MsgDailyLoveLimitReached: "今日姻缘推演次数已用完，明日再来吧",
+		MsgTooManyRequests:       "请求过于频繁，请稍后再试",
+		MsgServerBusy:            "服务器正忙，正在排队中，请稍后重试...",
+		MsgQuestionAccepted:      "请求已受理，正在推演中...",
+		MsgLoveAccepted:          "姻缘推演请求已受理，请稍候...",
+
+		MsgFallbackDirectAnswer: "天机未显，静候缘分。",
+		MsgParseFailedAnswer:    "云深不知处，只在此山中。",
+		MsgParseFailedAdvice:    "静观其变",
+		MsgParseFailedWarning:   "勿急躁",
+		MsgParseFailedKeyword:   "待",
+
+		MsgLoveFallbackKeyword: "天机难测",
+		MsgLoveFallbackBazi:    "服务器解析异常，请重试",
+		MsgLoveFallbackPoem:    "道可道非常道",
+
+		MsgHistoryNoHexagram: "暂无卦象",
+		MsgHistoryHexagram:   "卦象: %s",
+		MsgHistoryLoveScore:  "契合度 %d · 卦象: %s",
+
+		MsgInvalidRequest:     "请求格式有误",
+		MsgInvalidID:          "无效的编号",
+		MsgNotFound:           "记录不存在",
+		MsgForbidden:          "无权访问此记录",
+		MsgUnauthorized:       "请先登录",
+		MsgLoginRequired:      "请先登录",
+		MsgCSRFInvalid:        "页面已过期，请刷新后重试",
+		MsgDeviceHashRequired: "缺少设备标识",
+		MsgInternalError:      "服务器开小差了，请稍后重试",
+		MsgInvalidPersona:     "无效的大师人设",
+		MsgInvalidZodiac:      "无效的星座",
+		MsgInvalidGender:      "无效的性别",
+		MsgInvalidMBTI:        "MBTI 格式有误",
+		MsgInvalidLocale:      "不支持的语言",
+		MsgUsernameTaken:      "用户名已被占用",
+		MsgInvalidCredentials: "用户名或密码错误",
+		MsgUserNotFound:       "用户不存在",
+		MsgTaskNotFound:       "任务不存在或已过期",
+		MsgTaskFinished:       "任务已结束",
+	},
+	ZhTW: {
+		MsgDailyLimitReached:     "不可貪念天機",
+		MsgDailyChatLimitReached: "今日追問次數已用完，明日再來吧",
+		MsgDailyLoveLimitReached: "今日姻緣推演次數已用完，明日再來吧",
+		MsgTooManyRequests:       "請求過於頻繁，請稍後再試",
+		MsgServerBusy:            "伺服器正忙，正在排隊中，請稍後重試...",
+		MsgQuestionAccepted:      "請求已受理，正在推演中...",
+		MsgLoveAccepted:          "姻緣推演請求已受理，請稍候...",
+
+		MsgFallbackDirectAnswer: "天機未顯，靜候緣分。",
+		MsgParseFailedAnswer:    "雲深不知處，只在此山中。",
+		MsgParseFailedAdvice:    "靜觀其變",
+		MsgParseFailedWarning:   "勿急躁",
+		MsgParseFailedKeyword:   "待",
+
+		MsgLoveFallbackKeyword: "天機難測",
+		MsgLoveFallbackBazi:    "伺服器解析異常，請重試",
+		MsgLoveFallbackPoem:    "道可道非常道",
+
+		MsgHistoryNoHexagram: "暫無卦象",
+		MsgHistoryHexagram:   "卦象: %s",
+		MsgHistoryLoveScore:  "契合度 %d · 卦象: %s",
+
+		MsgInvalidRequest:     "請求格式有誤",
+		MsgInvalidID:          "無效的編號",
+		MsgNotFound:           "記錄不存在",
+		MsgForbidden:          "無權存取此記錄",
+		MsgUnauthorized:       "請先登入",
+		MsgLoginRequired:      "請先登入",
+		MsgCSRFInvalid:        "頁面已過期，請重新整理後再試",
+		MsgDeviceHashRequired: "缺少裝置識別碼",
+		MsgInternalError:      "伺服器開小差了，請稍後重試",
+		MsgInvalidPersona:     "無效的大師人設",
+		MsgInvalidZodiac:      "無效的星座",
+		MsgInvalidGender:      "無效的性別",
+		MsgInvalidMBTI:        "MBTI 格式有誤",
+		MsgInvalidLocale:      "不支援的語言",
+		MsgUsernameTaken:      "使用者名稱已被使用",
+		MsgInvalidCredentials: "使用者名稱或密碼錯誤",
+		MsgUserNotFound:       "使用者不存在",
+		MsgTaskNotFound:       "任務不存在或已過期",
+		MsgTaskFinished:       "任務已結束",
+	},
+	En: {
+		MsgDailyLimitReached:     "Do not be greedy for heaven's secrets: today's readings are used up.",
+		MsgDailyChatLimitReached: "You have used all follow-up questions for today. Please come back tomorrow.",
+		MsgDailyLoveLimitReached: "You have used all love readings for today. Please come back tomorrow.",
+		MsgTooManyRequests:       "Too many requests. Please try again later.",
+		MsgServerBusy:            "The server is busy and your request is queued. Please retry shortly...",
+		MsgQuestionAccepted:      "Request accepted. The reading is being cast...",
+		MsgLoveAccepted:          "Love reading accepted. Please wait a moment...",
+
+		MsgFallbackDirectAnswer: "Heaven's will is not yet revealed; wait patiently for your moment.",
+		MsgParseFailedAnswer:    "Lost among the clouds, yet somewhere on this mountain.",
+		MsgParseFailedAdvice:    "Watch and wait",
+		MsgParseFailedWarning:   "Do not be hasty",
+		MsgParseFailedKeyword:   "Wait",
+
+		MsgLoveFallbackKeyword: "Fate is hard to read",
+		MsgLoveFallbackBazi:    "The server could not parse the analysis. Please try again.",
+		MsgLoveFallbackPoem:    "The Tao that can be told is not the eternal Tao.",
+
+		MsgHistoryNoHexagram: "No hexagram yet",
+		MsgHistoryHexagram:   "Hexagram: %s",
+		MsgHistoryLoveScore:  "Compatibility %d · Hexagram: %s",
+
+		MsgInvalidRequest:     "The request is malformed.",
+		MsgInvalidID:          "Invalid ID.",
+		MsgNotFound:           "Record not found.",
+		MsgForbidden:          "You do not have access to this record.",
+		MsgUnauthorized:       "Please log in first.",
+		MsgLoginRequired:      "Please log in first.",
+		MsgCSRFInvalid:        "This page has expired. Please refresh and try again.",
+		MsgDeviceHashRequired: "Device ID is required.",
+		MsgInternalError:      "Something went wrong on our side. Please try again later.",
+		MsgInvalidPersona:     "Invalid master persona.",
+		MsgInvalidZodiac:      "Invalid zodiac sign.",
+		MsgInvalidGender:      "Invalid gender.",
+		MsgInvalidMBTI:        "Invalid MBTI format.",
+		MsgInvalidLocale:      "Unsupported language.",
+		MsgUsernameTaken:      "This username is already taken.",
+		MsgInvalidCredentials: "Incorrect username or password.",
+		MsgUserNotFound:       "User not found.",
+		MsgTaskNotFound:       "Task not found or expired.",
+		MsgTaskFinished:       "The task has already finished.",
+	},
+}
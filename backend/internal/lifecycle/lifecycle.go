@@ -0,0 +1,52 @@
+package lifecycle
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Manager runs shutdown hooks in registration order once the process is asked
+// to stop. Register components from the outside in: HTTP server first, then
+// workers, and connection pools last.
+type Manager struct {
+	hooks []hook
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func New() *Manager {
+	return &Manager{}
+}
+
+// OnShutdown registers fn to run during shutdown. fn receives a context that
+// expires at the overall shutdown deadline.
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Wait blocks until SIGINT/SIGTERM (or ctx is done), then runs every hook
+// within timeout. A hook that fails is logged and the next one still runs.
+func (m *Manager) Wait(ctx context.Context, timeout time.Duration) {
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	<-sigCtx.Done()
+	stop()
+	log.Printf("[Lifecycle] shutting down (deadline %s)", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for _, h := range m.hooks {
+		start := time.Now()
+		if err := h.fn(shutdownCtx); err != nil {
+			log.Printf("[Lifecycle] %s: %v", h.name, err)
+			continue
+		}
+		log.Printf("[Lifecycle] %s stopped in %s", h.name, time.Since(start).Round(time.Millisecond))
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	"fromheart/internal/auth"
 	"fromheart/internal/config"
+	"fromheart/internal/i18n"
 
 	"github.com/gin-gonic/gin"
 )
@@ -51,7 +52,7 @@ func RequireAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		_, exists := c.Get("userID")
 		if !exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Login required"})
+			AbortWithError(c, http.StatusUnauthorized, i18n.MsgLoginRequired)
 			return
 		}
 		c.Next()
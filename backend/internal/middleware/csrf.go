@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"net/http"
 
+	"fromheart/internal/i18n"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -46,21 +48,18 @@ func CSRF() gin.HandlerFunc {
 		if c.Request.Method == "POST" || c.Request.Method == "PUT" || c.Request.Method == "DELETE" || c.Request.Method == "PATCH" {
 			csrfCookie, err := c.Cookie("csrf_token")
 			if err != nil {
-				c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token missing in cookie"})
-				c.Abort()
+				AbortWithError(c, http.StatusForbidden, i18n.MsgCSRFInvalid)
 				return
 			}
 
 			csrfHeader := c.GetHeader("X-CSRF-Token")
 			if csrfHeader == "" {
-				c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token missing in header"})
-				c.Abort()
+				AbortWithError(c, http.StatusForbidden, i18n.MsgCSRFInvalid)
 				return
 			}
 
 			if csrfCookie != csrfHeader {
-				c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token invalid"})
-				c.Abort()
+				AbortWithError(c, http.StatusForbidden, i18n.MsgCSRFInvalid)
 				return
 			}
 		}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"fromheart/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Locale 从 ?lang= 或 Accept-Language 解析输出语言，存入 context 的 "locale"
+// 登录用户资料中的语言偏好由 service 层在此基础上覆盖
+func Locale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale, ok := i18n.Parse(c.Query("lang"))
+		if !ok {
+			locale = i18n.FromAcceptLanguage(c.GetHeader("Accept-Language"))
+		}
+		c.Set("locale", locale)
+		c.Next()
+	}
+}
+
+// GetLocale 读取 Locale 中间件写入的语言，未设置时返回默认语言
+func GetLocale(c *gin.Context) i18n.Locale {
+	if l, ok := c.Get("locale"); ok {
+		if locale, ok := l.(i18n.Locale); ok {
+			return locale
+		}
+	}
+	return i18n.Default
+}
+
+// AbortWithError 以 {"error": key, "message": 本地化文案} 的形式返回错误并中断请求
+func AbortWithError(c *gin.Context, status int, key string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":   key,
+		"message": i18n.T(GetLocale(c), key),
+	})
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"fromheart/internal/i18n"
+	"fromheart/internal/quota"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// quotaErrors 各计量功能额度用完时返回的错误码，同时也是提示文案的 key
+var quotaErrors = map[quota.Feature]string{
+	quota.FeatureQuestion: i18n.MsgDailyLimitReached,
+	quota.FeatureChat:     i18n.MsgDailyChatLimitReached,
+	quota.FeatureLove:     i18n.MsgDailyLoveLimitReached,
+}
+
+// QuotaCaller 从请求中取出计量额度所需的身份信息。
+// 管理员密钥取自 X-Admin-Secret 请求头，deviceHash 为空时取 X-Device-Hash 请求头或 device_hash 参数。
+func QuotaCaller(c *gin.Context, deviceHash string) quota.Caller {
+	caller := quota.Caller{
+		DeviceHash: deviceHash,
+		IP:         c.ClientIP(),
+		Secret:     c.GetHeader("X-Admin-Secret"),
+	}
+	if id, ok := c.Get("userID"); ok {
+		if userID, ok := id.(uint); ok {
+			caller.UserID = &userID
+		}
+	}
+	if caller.DeviceHash == "" {
+		caller.DeviceHash = c.GetHeader("X-Device-Hash")
+	}
+	if caller.DeviceHash == "" {
+		caller.DeviceHash = c.Query("device_hash")
+	}
+	return caller
+}
+
+// ReserveQuota 为 ref 预占一次 feature 额度，返回解析出的身份。
+// 额度用完时返回 403，并中止请求；ok 为 false 时调用方应直接返回。
+// 额度服务本身出错时放行（与其他计数器一致，优先保证可用性），此时 reserved 为 false，无需结算。
+func ReserveQuota(c *gin.Context, engine *quota.Engine, caller quota.Caller, feature quota.Feature, ref string) (sub quota.Subject, reserved, ok bool) {
+	ctx := c.Request.Context()
+	sub, err := engine.Resolve(ctx, caller)
+	if err == nil {
+		err = engine.Reserve(ctx, sub, feature, ref)
+	}
+	var limitErr *quota.LimitError
+	switch {
+	case errors.As(err, &limitErr):
+		code := quotaErrors[feature]
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":   code,
+			"message": i18n.T(GetLocale(c), code),
+			"plan":    limitErr.Plan,
+			"limit":   limitErr.Limit,
+			"used":    limitErr.Used,
+		})
+		return sub, false, false
+	case err != nil:
+		log.Printf("[Quota] reserve %s for %s failed, allowing request: %v", feature, caller.IP, err)
+		if sub.Plan == "" {
+			sub.Plan = quota.PlanGuest
+		}
+		return sub, false, true
+	}
+	return sub, true, true
+}
+
+// Quota 同步计量接口（如追问）的额度中间件：请求前预占，
+// 处理成功（状态码 < 400 且没有 c.Error 记录的错误）后确认扣除，失败则退还。
+func Quota(engine *quota.Engine, feature quota.Feature) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ref := uuid.New().String()
+		sub, reserved, ok := ReserveQuota(c, engine, QuotaCaller(c, ""), feature, ref)
+		if !ok {
+			return
+		}
+		if limit := engine.Limits().Limit(sub.Plan, feature); limit != quota.Unlimited {
+			c.Header("X-Quota-Limit", fmt.Sprintf("%d", limit))
+		}
+		c.Next()
+
+		if !reserved {
+			return
+		}
+		// 请求已处理完，结算不应随客户端断开而被取消
+		ctx := context.WithoutCancel(c.Request.Context())
+		settle, action := engine.Commit, "commit"
+		// 流式接口在开始输出后才失败时状态码仍为 200，由处理函数通过 c.Error 报告
+		if c.Writer.Status() >= http.StatusBadRequest || len(c.Errors) > 0 {
+			settle, action = engine.Refund, "refund"
+		}
+		if err := settle(ctx, ref); err != nil {
+			log.Printf("[Quota] %s %s failed: %v", action, ref, err)
+		}
+	}
+}
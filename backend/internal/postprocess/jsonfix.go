@@ -0,0 +1,340 @@
+package postprocess
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Repair kinds reported in Diagnostics.Repairs
+const (
+	RepairMarkdownFence   = "markdown_fence"
+	RepairSurroundingText = "surrounding_text"
+	RepairComments        = "comments"
+	RepairTrailingCommas  = "trailing_commas"
+	RepairFullWidthQuotes = "full_width_quotes"
+	RepairFullWidthPunct  = "full_width_punctuation"
+	RepairRawNewlines     = "raw_newlines"
+	RepairUnclosed        = "unclosed_brackets"
+	RepairMultipleObjects = "multiple_objects"
+	RepairSummaryObject   = "summary_object"
+	RepairListCoerced     = "list_coerced"
+)
+
+// Diagnostics describes how an LLM reply was turned into JSON
+type Diagnostics struct {
+	Repairs    []string `json:"repairs,omitempty"`
+	Objects    int      `json:"objects,omitempty"` // number of top-level objects found
+	ParseError string   `json:"parse_error,omitempty"`
+	Sanitized  []string `json:"sanitized,omitempty"` // transformations made by Sanitize
+}
+
+func (d *Diagnostics) add(repair string) {
+	for _, r := range d.Repairs {
+		if r == repair {
+			return
+		}
+	}
+	d.Repairs = append(d.Repairs, repair)
+}
+
+var errNoObject = errors.New("no JSON object found")
+
+// ParseJSON extracts the first JSON object from an LLM reply, repairs common
+// defects (markdown fences, comments, trailing commas, full-width quotes, raw
+// newlines in strings, missing closing brackets) and decodes it into v.
+func ParseJSON(raw string, v interface{}) (Diagnostics, error) {
+	obj, diag := ExtractJSON(raw)
+	if obj == "" {
+		diag.ParseError = errNoObject.Error()
+		return diag, errNoObject
+	}
+	if err := json.Unmarshal([]byte(obj), v); err != nil {
+		diag.ParseError = err.Error()
+		return diag, err
+	}
+	return diag, nil
+}
+
+// ExtractJSON returns the repaired text of the first top-level JSON object in raw.
+func ExtractJSON(raw string) (string, Diagnostics) {
+	var diag Diagnostics
+	s := strings.TrimSpace(strings.TrimPrefix(raw, "\ufeff"))
+
+	if strings.Contains(s, "```") {
+		diag.add(RepairMarkdownFence)
+		s = stripFences(s)
+	}
+
+	start := strings.IndexAny(s, "{")
+	if start == -1 {
+		return "", diag
+	}
+	if strings.TrimSpace(s[:start]) != "" {
+		diag.add(RepairSurroundingText)
+	}
+
+	objects, unclosed := scanObjects(s[start:], &diag)
+	diag.Objects = len(objects)
+	if len(objects) == 0 {
+		return "", diag
+	}
+	if len(objects) > 1 {
+		diag.add(RepairMultipleObjects)
+	}
+	if unclosed {
+		diag.add(RepairUnclosed)
+	}
+	return objects[0], diag
+}
+
+// stripFences keeps only the contents of the first fenced block that contains a '{'
+func stripFences(s string) string {
+	parts := strings.Split(s, "```")
+	for i := 1; i < len(parts); i += 2 {
+		block := parts[i]
+		// Drop an optional language tag on the opening fence line
+		if nl := strings.IndexByte(block, '\n'); nl != -1 && !strings.Contains(block[:nl], "{") {
+			block = block[nl+1:]
+		}
+		if strings.Contains(block, "{") {
+			return strings.TrimSpace(block)
+		}
+	}
+	return strings.ReplaceAll(s, "```", "")
+}
+
+// scanObjects walks s once and emits each balanced top-level object, rewriting
+// defects on the fly. The final object is closed if the text ends early.
+func scanObjects(s string, diag *Diagnostics) ([]string, bool) {
+	var (
+		objects    []string
+		out        bytes.Buffer
+		stack      []rune // expected closing brackets
+		inString   bool
+		fullWidth  bool // current string was opened with a full-width quote
+		escaped    bool
+		runes      = []rune(s)
+		pendingEnd = func() {
+			objects = append(objects, out.String())
+			out.Reset()
+		}
+	)
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+				out.WriteRune(r)
+			case r == '\\':
+				escaped = true
+				out.WriteRune(r)
+			case r == '"' && !fullWidth:
+				inString = false
+				out.WriteRune('"')
+			case fullWidth && isFullWidthQuote(r) && closesFullWidth(runes, i):
+				inString = false
+				out.WriteRune('"')
+			case r == '"' && fullWidth:
+				out.WriteString(`\"`)
+			case r == '\n':
+				diag.add(RepairRawNewlines)
+				out.WriteString(`\n`)
+			case r == '\r':
+				diag.add(RepairRawNewlines)
+			case r == '\t':
+				out.WriteString(`\t`)
+			default:
+				out.WriteRune(r)
+			}
+			continue
+		}
+
+		if len(stack) == 0 {
+			// Between objects: skip everything until the next '{'
+			if r == '{' {
+				stack = append(stack, '}')
+				out.WriteRune(r)
+			}
+			continue
+		}
+
+		switch {
+		case r == '"':
+			inString, fullWidth = true, false
+			out.WriteRune(r)
+		case isFullWidthQuote(r):
+			diag.add(RepairFullWidthQuotes)
+			inString, fullWidth = true, true
+			out.WriteRune('"')
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '/':
+			diag.add(RepairComments)
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			diag.add(RepairComments)
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == '{':
+			stack = append(stack, '}')
+			out.WriteRune(r)
+		case r == '[':
+			stack = append(stack, ']')
+			out.WriteRune(r)
+		case r == '}' || r == ']':
+			trimTrailingComma(&out, diag)
+			stack = stack[:len(stack)-1]
+			out.WriteRune(r)
+			if len(stack) == 0 {
+				pendingEnd()
+			}
+		case r == '，' || r == '：':
+			// Full-width separators outside strings
+			diag.add(RepairFullWidthPunct)
+			if r == '，' {
+				out.WriteRune(',')
+			} else {
+				out.WriteRune(':')
+			}
+		default:
+			out.WriteRune(r)
+		}
+	}
+
+	if len(stack) == 0 {
+		return objects, false
+	}
+	// Truncated reply: close the open string and brackets
+	if inString {
+		out.WriteRune('"')
+	}
+	trimTrailingComma(&out, diag)
+	for i := len(stack) - 1; i >= 0; i-- {
+		out.WriteRune(stack[i])
+	}
+	pendingEnd()
+	return objects, true
+}
+
+func isFullWidthQuote(r rune) bool {
+	return r == '“' || r == '”' || r == '＂'
+}
+
+// closesFullWidth reports whether the quote at i ends a string opened by a
+// full-width quote: it must be followed by a structural character.
+func closesFullWidth(runes []rune, i int) bool {
+	for j := i + 1; j < len(runes); j++ {
+		if unicode.IsSpace(runes[j]) {
+			continue
+		}
+		switch runes[j] {
+		case ',', ':', '}', ']', '，', '：':
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// trimTrailingComma removes a comma (plus whitespace) right before a closing bracket
+func trimTrailingComma(out *bytes.Buffer, diag *Diagnostics) {
+	b := out.Bytes()
+	j := len(b) - 1
+	for j >= 0 && (b[j] == ' ' || b[j] == '\n' || b[j] == '\t' || b[j] == '\r') {
+		j--
+	}
+	if j >= 0 && b[j] == ',' {
+		diag.add(RepairTrailingCommas)
+		out.Truncate(j)
+	}
+}
+
+// flattenOrdered renders a JSON value as readable text. Objects keep the key
+// order from the source so the result is deterministic.
+func flattenOrdered(raw json.RawMessage) (string, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", false
+	}
+
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := dec.Token()
+	if delim, ok := tok.(json.Delim); err != nil || !ok || delim != '{' {
+		return string(raw), false
+	}
+
+	var sb strings.Builder
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		key, _ := keyTok.(string)
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			break
+		}
+		var s string
+		if err := json.Unmarshal(val, &s); err == nil {
+			sb.WriteString(key + ": " + s + "\n")
+			continue
+		}
+		// Nested values: recurse for objects, compact JSON otherwise
+		if nested, _ := flattenOrdered(val); nested != "" {
+			sb.WriteString(key + ": " + strings.TrimRight(strings.ReplaceAll(nested, "\n", "；"), "；") + "\n")
+		}
+	}
+	if sb.Len() == 0 {
+		return string(raw), true
+	}
+	return sb.String(), true
+}
+
+// stringList accepts a JSON array of strings, a single string, or an array of
+// mixed values, and always returns a list of strings.
+func stringList(raw json.RawMessage, diag *Diagnostics) []string {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+
+	diag.add(RepairListCoerced)
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		for _, part := range strings.FieldsFunc(single, func(r rune) bool {
+			return r == '\n' || r == '；' || r == ';'
+		}) {
+			if part = strings.TrimSpace(part); part != "" {
+				list = append(list, part)
+			}
+		}
+		return list
+	}
+	var mixed []json.RawMessage
+	if err := json.Unmarshal(raw, &mixed); err == nil {
+		for _, item := range mixed {
+			if text, _ := flattenOrdered(item); text != "" {
+				list = append(list, strings.TrimSpace(text))
+			}
+		}
+	}
+	return list
+}
@@ -0,0 +1,177 @@
+package postprocess
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"fromheart/internal/i18n"
+)
+
+const (
+	MinLoveScore = 0
+	MaxLoveScore = 100
+)
+
+// LoveAnalysis is the validated result of a love probe (姻缘合婚)
+type LoveAnalysis struct {
+	// Score is the compatibility score 0-100; nil when the model gave none
+	Score               *int     `json:"score"`
+	Keyword             string   `json:"keyword"`
+	BaziAnalysis        string   `json:"bazi_analysis"`
+	HexagramAnalysis    string   `json:"hexagram_analysis"`
+	StoryInterpretation string   `json:"story_interpretation"`
+	Advice              []string `json:"advice"`
+	Poem                string   `json:"poem"`
+
+	// Degraded marks a fallback produced because the reply could not be parsed
+	Degraded    bool         `json:"degraded,omitempty"`
+	Diagnostics *Diagnostics `json:"diagnostics,omitempty"`
+}
+
+// loveResponse keeps fields raw so each one can be validated independently
+type loveResponse struct {
+	Score               json.RawMessage `json:"score"`
+	Keyword             json.RawMessage `json:"keyword"`
+	BaziAnalysis        json.RawMessage `json:"bazi_analysis"`
+	HexagramAnalysis    json.RawMessage `json:"hexagram_analysis"`
+	StoryInterpretation json.RawMessage `json:"story_interpretation"`
+	Advice              json.RawMessage `json:"advice"`
+	Poem                json.RawMessage `json:"poem"`
+	Degraded            bool            `json:"degraded"` // set on stored fallbacks
+}
+
+// NormalizeLove parses and validates a love analysis reply from the LLM (or a
+// previously stored FinalResponse). Missing sections are repaired and the
+// score is clamped to 0-100; every fix is recorded in Diagnostics.
+func NormalizeLove(raw string, locale i18n.Locale) LoveAnalysis {
+	var resp loveResponse
+	diag, err := ParseJSON(raw, &resp)
+	if err != nil {
+		a := LoveAnalysis{
+			Keyword:          i18n.T(locale, i18n.MsgLoveFallbackKeyword),
+			BaziAnalysis:     i18n.T(locale, i18n.MsgLoveFallbackBazi),
+			HexagramAnalysis: raw,
+			Advice:           []string{},
+			Poem:             i18n.T(locale, i18n.MsgLoveFallbackPoem),
+			Degraded:         true,
+			Diagnostics:      &diag,
+		}
+		a.sanitize(locale, &diag)
+		return a
+	}
+
+	a := LoveAnalysis{
+		Score:    parseLoveScore(resp.Score, &diag),
+		Advice:   stringList(resp.Advice, &diag),
+		Degraded: resp.Degraded,
+	}
+	required := []struct {
+		name string
+		raw  json.RawMessage
+		dst  *string
+	}{
+		{"keyword", resp.Keyword, &a.Keyword},
+		{"bazi_analysis", resp.BaziAnalysis, &a.BaziAnalysis},
+		{"hexagram_analysis", resp.HexagramAnalysis, &a.HexagramAnalysis},
+		{"story_interpretation", resp.StoryInterpretation, &a.StoryInterpretation},
+		{"poem", resp.Poem, &a.Poem},
+	}
+	for _, f := range required {
+		*f.dst, _ = flattenOrdered(f.raw)
+		*f.dst = sanitizeInto(*f.dst, locale, &diag)
+		if *f.dst == "" {
+			diag.add("missing:" + f.name)
+		}
+	}
+	// Fallbacks stored before Degraded existed carry score 0 and no analysis:
+	// their 0 is a placeholder, not a reading
+	if a.Score != nil && *a.Score == 0 && !a.hasAnalysis(locale) {
+		a.Score = nil
+		a.Degraded = true
+		diag.add("placeholder_score")
+	}
+	if a.Keyword == "" {
+		a.Keyword = i18n.T(locale, i18n.MsgLoveFallbackKeyword)
+	}
+	if a.Poem == "" {
+		a.Poem = i18n.T(locale, i18n.MsgLoveFallbackPoem)
+	}
+	if a.Advice == nil {
+		a.Advice = []string{}
+	}
+	for i := range a.Advice {
+		a.Advice[i] = sanitizeInto(a.Advice[i], locale, &diag)
+	}
+
+	a.Diagnostics = &diag
+	return a
+}
+
+var scoreNumber = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+// parseLoveScore accepts 85, 85.5, "85分", "85%", "8.5/10" and clamps to 0-100.
+// Fractional scores at or below 10 are read as a ten-point scale.
+func parseLoveScore(raw json.RawMessage, diag *Diagnostics) *int {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		diag.add("missing:score")
+		return nil
+	}
+
+	text := string(raw)
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		text = s
+		diag.add("score_coerced")
+	}
+	match := scoreNumber.FindString(text)
+	if match == "" {
+		diag.add("missing:score")
+		return nil
+	}
+	v, err := strconv.ParseFloat(match, 64)
+	if err != nil {
+		diag.add("missing:score")
+		return nil
+	}
+
+	if strings.Contains(text, "/10") && !strings.Contains(text, "/100") || (v > 0 && v <= 10 && v != math.Trunc(v)) {
+		v *= 10
+		diag.add("score_rescaled")
+	}
+	if v < MinLoveScore || v > MaxLoveScore {
+		v = math.Max(MinLoveScore, math.Min(MaxLoveScore, v))
+		diag.add("score_clamped")
+	}
+	score := int(math.Round(v))
+	return &score
+}
+
+// hasAnalysis reports whether the bazi or story section holds actual text
+// rather than being empty, punctuation such as "..." or the fallback message.
+func (a LoveAnalysis) hasAnalysis(locale i18n.Locale) bool {
+	hasText := func(s string) bool { return strings.IndexFunc(s, unicode.IsLetter) >= 0 }
+	return hasText(a.StoryInterpretation) ||
+		hasText(a.BaziAnalysis) && a.BaziAnalysis != i18n.T(locale, i18n.MsgLoveFallbackBazi)
+}
+
+// sanitize cleans the text sections of a fallback analysis
+func (a *LoveAnalysis) sanitize(locale i18n.Locale, diag *Diagnostics) {
+	for _, field := range []*string{&a.Keyword, &a.BaziAnalysis, &a.HexagramAnalysis, &a.StoryInterpretation, &a.Poem} {
+		*field = sanitizeInto(*field, locale, diag)
+	}
+}
+
+// SummaryLine renders a short history summary in locale, such as "契合度 85 · 卦象: 乾"
+func (a LoveAnalysis) SummaryLine(benGua string, locale i18n.Locale) string {
+	if a.Score == nil || a.Degraded {
+		return fmt.Sprintf(i18n.T(locale, i18n.MsgHistoryHexagram), benGua)
+	}
+	return fmt.Sprintf(i18n.T(locale, i18n.MsgHistoryLoveScore), *a.Score, benGua)
+}
@@ -3,8 +3,15 @@
 import (
 	"encoding/json"
 	"strings"
+
+	"fromheart/internal/divination"
+	"fromheart/internal/i18n"
 )
 
+// SchemaVersion is stored alongside persisted Output documents; bump it when
+// fields change meaning so old rows can be re-parsed.
+const SchemaVersion = 1
+
 // Output defines the structure returned to frontend
 type Output struct {
 	DirectAnswer  string   `json:"direct_answer"`
@@ -17,82 +24,111 @@ type Output struct {
 	BenGua        string   `json:"ben_gua"`
 	BianGua       string   `json:"bian_gua"`
 	ChangingLines string   `json:"changing_lines"`
+
+	// Hexagram names with pinyin and English translations
+	BenGuaInfo  *divination.Hexagram `json:"ben_gua_info,omitempty"`
+	BianGuaInfo *divination.Hexagram `json:"bian_gua_info,omitempty"`
+
+	// What the tolerant JSON parser had to repair in the model's reply
+	Diagnostics *Diagnostics `json:"diagnostics,omitempty"`
 }
 
-// LLMResponse is an intermediate struct to handle potentially complex JSON from LLM
+// LLMResponse is an intermediate struct to handle potentially complex JSON from LLM.
+// Fields are kept raw so a wrong type in one field does not fail the whole reply.
 type LLMResponse struct {
-	DirectAnswer string      `json:"direct_answer"`
-	Summary      interface{} `json:"summary"` // Can be string or object
-	Colloquial   string      `json:"colloquial_explanation"`
-	Advice       []string    `json:"advice"`
-	Warnings     []string    `json:"warnings"`
-	Keywords     []string    `json:"keywords"`
+	DirectAnswer string          `json:"direct_answer"`
+	Summary      json.RawMessage `json:"summary"` // Can be string or object
+	Colloquial   json.RawMessage `json:"colloquial_explanation"`
+	Advice       json.RawMessage `json:"advice"`
+	Warnings     json.RawMessage `json:"warnings"`
+	Keywords     json.RawMessage `json:"keywords"`
 }
 
-func Normalize(raw, ben, bian, lines string) Output {
+func Normalize(raw, ben, bian, lines string, locale i18n.Locale) Output {
 	var llmResp LLMResponse // Intermediate parsing
 
-	cleanRaw := strings.TrimSpace(raw)
-
-	// Robust JSON extraction: Locate the first '{' and the last '}'
-	start := strings.Index(cleanRaw, "{")
-	end := strings.LastIndex(cleanRaw, "}")
-	if start != -1 && end != -1 && end > start {
-		cleanRaw = cleanRaw[start : end+1]
-	}
-
-	err := json.Unmarshal([]byte(cleanRaw), &llmResp)
+	diag, err := ParseJSON(raw, &llmResp)
 	if err == nil {
 		finalOutput := Output{
-			DirectAnswer:  llmResp.DirectAnswer,
-			Colloquial:    llmResp.Colloquial,
-			Advice:        llmResp.Advice,
-			Warnings:      llmResp.Warnings,
-			Keywords:      llmResp.Keywords,
+			DirectAnswer:  strings.TrimSpace(llmResp.DirectAnswer),
+			Advice:        stringList(llmResp.Advice, &diag),
+			Warnings:      stringList(llmResp.Warnings, &diag),
+			Keywords:      stringList(llmResp.Keywords, &diag),
 			Raw:           raw,
 			BenGua:        ben,
 			BianGua:       bian,
 			ChangingLines: lines,
+			BenGuaInfo:    HexagramInfo(ben),
+			BianGuaInfo:   HexagramInfo(bian),
 		}
+		finalOutput.Colloquial, _ = flattenOrdered(llmResp.Colloquial)
 
-		// Handle Summary: if it's an object, marshal it back to string; if it's string, use directly
-		if summaryStr, ok := llmResp.Summary.(string); ok {
-			finalOutput.Summary = summaryStr
-		} else {
-			// It's likely a map/object, convert to pretty string or just string
-			summaryBytes, _ := json.Marshal(llmResp.Summary)
-			// Better: try to format it nicely if possible, or just dump it
-			// Ideally we want a single string paragraph for the frontend.
-			// Let's try to extract values if it's a map, or just dump JSON string
-			finalOutput.Summary = string(summaryBytes)
-
-			// If it's a map, let's try to join the values to make it readable text
-			if summaryMap, ok := llmResp.Summary.(map[string]interface{}); ok {
-				var sb strings.Builder
-				for k, v := range summaryMap {
-					if strVal, ok := v.(string); ok {
-						sb.WriteString(k + ": " + strVal + "\n")
-					}
-				}
-				if sb.Len() > 0 {
-					finalOutput.Summary = sb.String()
-				}
-			}
+		// Handle Summary: a string is used directly; an object is flattened to
+		// "key: value" lines in the order the model wrote them
+		summary, wasObject := flattenOrdered(llmResp.Summary)
+		if wasObject {
+			diag.add(RepairSummaryObject)
 		}
+		finalOutput.Summary = summary
 
+		finalOutput.sanitize(locale, &diag)
 		if finalOutput.DirectAnswer == "" {
-			finalOutput.DirectAnswer = "天机未显，静候缘分。"
+			finalOutput.DirectAnswer = i18n.T(locale, i18n.MsgFallbackDirectAnswer)
 		}
+		finalOutput.Diagnostics = &diag
 		return finalOutput
 	}
 
 	// Fallback
-	return Output{
-		DirectAnswer: "云深不知处，只在此山中。",
-		Summary:      raw,
-		Advice:       []string{"静观其变"},
-		Warnings:     []string{"勿急躁"},
-		Keywords:     []string{"待"},
-		Raw:          raw,
+	fallback := Output{
+		DirectAnswer:  i18n.T(locale, i18n.MsgParseFailedAnswer),
+		Summary:       raw,
+		Advice:        []string{i18n.T(locale, i18n.MsgParseFailedAdvice)},
+		Warnings:      []string{i18n.T(locale, i18n.MsgParseFailedWarning)},
+		Keywords:      []string{i18n.T(locale, i18n.MsgParseFailedKeyword)},
+		Raw:           raw,
+		BenGua:        ben,
+		BianGua:       bian,
+		ChangingLines: lines,
+		BenGuaInfo:    HexagramInfo(ben),
+		BianGuaInfo:   HexagramInfo(bian),
+		Diagnostics:   &diag,
+	}
+	fallback.sanitize(locale, &diag)
+	return fallback
+}
+
+// sanitize cleans every displayed text field; Raw is kept verbatim for debugging.
+func (o *Output) sanitize(locale i18n.Locale, diag *Diagnostics) {
+	for _, field := range []*string{&o.DirectAnswer, &o.Summary, &o.Colloquial} {
+		*field = sanitizeInto(*field, locale, diag)
 	}
+	for _, list := range [][]string{o.Advice, o.Warnings, o.Keywords} {
+		for i := range list {
+			list[i] = sanitizeInto(list[i], locale, diag)
+		}
+	}
+}
+
+func sanitizeInto(text string, locale i18n.Locale, diag *Diagnostics) string {
+	out, applied := Sanitize(text, locale)
+	for _, a := range applied {
+		addApplied(&diag.Sanitized, a)
+	}
+	return out
+}
+
+// HexagramInfo looks up pinyin and English names for a hexagram, nil if unknown
+func HexagramInfo(name string) *divination.Hexagram {
+	if h, ok := divination.LookupHexagram(name); ok {
+		return &h
+	}
+	return nil
+}
+
+// Persisted returns the Output as stored in Divination.Interpretation. The raw
+// model reply is dropped because it is already kept in Divination.RawOutput.
+func (o Output) Persisted() ([]byte, error) {
+	o.Raw = ""
+	return json.Marshal(o)
 }
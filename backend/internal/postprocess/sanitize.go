@@ -0,0 +1,387 @@
+package postprocess
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"fromheart/internal/i18n"
+)
+
+// Transformations reported in Diagnostics.Sanitized
+const (
+	SanitizeScript       = "script"
+	SanitizeHTML         = "html"
+	SanitizeMarkdown     = "markdown"
+	SanitizePromptLeak   = "prompt_leak"
+	SanitizeAISelfRef    = "ai_self_reference"
+	SanitizeChineseDigit = "chinese_numerals"
+)
+
+var (
+	reScriptBlock   = regexp.MustCompile(`(?is)<(script|style|iframe|object|embed)\b[^>]*>.*?</(script|style|iframe|object|embed)\s*>`)
+	reScriptOpen    = regexp.MustCompile(`(?is)<(script|style|iframe|object|embed)\b.*$`)
+	reHTMLComment   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	reLineBreakTag  = regexp.MustCompile(`(?i)<br\s*/?>`)
+	reHTMLTag       = regexp.MustCompile(`(?i)</?[a-z][a-z0-9]*\b[^<>]*>`)
+	reFenceLine     = regexp.MustCompile("(?m)^\\s*```[a-zA-Z0-9_-]*\\s*$\\n?")
+	reImage         = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	reLink          = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	reHeading       = regexp.MustCompile(`(?m)^[ \t]{0,3}#{1,6}[ \t]+`)
+	reBlockquote    = regexp.MustCompile(`(?m)^[ \t]*>[ \t]?`)
+	reRule          = regexp.MustCompile(`(?m)^[ \t]*([-*_][ \t]*){3,}$\n?`)
+	reTableDivider  = regexp.MustCompile(`(?m)^[ \t]*\|?[ \t]*:?-{3,}:?[ \t]*(\|[ \t]*:?-{3,}:?[ \t]*)*\|?[ \t]*$\n?`)
+	reInlineCode    = regexp.MustCompile("`([^`\\n]+)`")
+	reUnderBold     = regexp.MustCompile(`__([^_\n]+)__`)
+	reStrike        = regexp.MustCompile(`~~([^~\n]+)~~`)
+	reBullet        = regexp.MustCompile(`(?m)^([ \t]*)[*+][ \t]+`)
+	reAIClause      = regexp.MustCompile(`(?i)(作为|身为)(一个|一名|一位|一款)?(AI|ＡＩ|人工智能|语言模型|大语言模型|大模型|AI助手|人工智能助手|智能助手|聊天机器人)[^，,。！？!?\n]*[，,]?|as an ai( language model| assistant)?,?\s*`)
+	reAISentence    = regexp.MustCompile(`(?i)我(只|仅)?(是|为)(一个|一名|一款)?(AI|ＡＩ|人工智能|语言模型|大语言模型|大模型|AI助手|人工智能助手|聊天机器人)|文心一言|ERNIE|i am an ai|i'm an ai|language model`)
+	reExtraNewlines = regexp.MustCompile(`\n{3,}`)
+	reNumber        = regexp.MustCompile(`\d+(\.\d+)?[%％]?`)
+	reBlankPrefix   = regexp.MustCompile(`^[ \t]*$`)
+)
+
+// promptMarkers are fragments of our own system prompts; a sentence containing
+// one of them means the model echoed its instructions.
+var promptMarkers = []string{
+	"系统提示", "提示词", "system prompt",
+	"解卦要求", "输出格式", "请严格以此格式", "语气规范", "禁用词汇", "常用词汇",
+	"绝非人工智能", "都必须坚持此人设",
+	"【原卦象信息】", "【前情提要】", "【输出语言】",
+	"direct_answer", "colloquial_explanation", "bazi_analysis", "hexagram_analysis", "story_interpretation",
+}
+
+// Sanitize cleans model text before it is stored or displayed: HTML and script
+// are stripped, markdown is reduced to bold/italic/lists, echoed prompt text and
+// AI self-references are removed, and digits are written as Chinese numerals
+// for Chinese locales. It returns the cleaned text and the transformations applied.
+func Sanitize(text string, locale i18n.Locale) (string, []string) {
+	var applied []string
+	out := sanitize(text, locale, &applied)
+	out = strings.TrimSpace(reExtraNewlines.ReplaceAllString(out, "\n\n"))
+	return out, applied
+}
+
+func sanitize(text string, locale i18n.Locale, applied *[]string) string {
+	if text == "" {
+		return text
+	}
+	step := func(kind string, s string, fn func(string) string) string {
+		if next := fn(s); next != s {
+			addApplied(applied, kind)
+			return next
+		}
+		return s
+	}
+
+	text = step(SanitizeScript, text, func(s string) string {
+		s = reScriptBlock.ReplaceAllString(s, "")
+		return reScriptOpen.ReplaceAllString(s, "")
+	})
+	text = step(SanitizeHTML, text, func(s string) string {
+		s = reHTMLComment.ReplaceAllString(s, "")
+		s = reLineBreakTag.ReplaceAllString(s, "\n")
+		return reHTMLTag.ReplaceAllString(s, "")
+	})
+	text = step(SanitizeMarkdown, text, normalizeMarkdown)
+	text = step(SanitizePromptLeak, text, func(s string) string {
+		return dropSentences(s, containsPromptMarker)
+	})
+	text = step(SanitizeAISelfRef, text, func(s string) string {
+		s = dropSentences(s, reAISentence.MatchString)
+		return reAIClause.ReplaceAllString(s, "")
+	})
+	if locale != i18n.En {
+		text = step(SanitizeChineseDigit, text, func(s string) string {
+			return chineseNumerals(s, locale)
+		})
+	}
+	return text
+}
+
+// normalizeMarkdown keeps **bold**, *italic*, "- " bullets and "1. " lists;
+// everything else is reduced to plain text.
+func normalizeMarkdown(s string) string {
+	s = reFenceLine.ReplaceAllString(s, "")
+	s = reImage.ReplaceAllString(s, "")
+	s = reLink.ReplaceAllString(s, "$1")
+	s = reTableDivider.ReplaceAllString(s, "")
+	s = reRule.ReplaceAllString(s, "")
+	s = reHeading.ReplaceAllString(s, "")
+	s = reBlockquote.ReplaceAllString(s, "")
+	s = reInlineCode.ReplaceAllString(s, "$1")
+	s = reUnderBold.ReplaceAllString(s, "**$1**")
+	s = reStrike.ReplaceAllString(s, "$1")
+	s = reBullet.ReplaceAllString(s, "$1- ")
+	if strings.Contains(s, "|") {
+		lines := strings.Split(s, "\n")
+		for i, line := range lines {
+			if t := strings.TrimSpace(line); strings.HasPrefix(t, "|") && strings.HasSuffix(t, "|") {
+				cells := strings.Split(strings.Trim(t, "|"), "|")
+				for j := range cells {
+					cells[j] = strings.TrimSpace(cells[j])
+				}
+				lines[i] = strings.Join(cells, "　")
+			}
+		}
+		s = strings.Join(lines, "\n")
+	}
+	return s
+}
+
+func containsPromptMarker(sentence string) bool {
+	lower := strings.ToLower(sentence)
+	for _, m := range promptMarkers {
+		if strings.Contains(lower, strings.ToLower(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropSentences removes every sentence for which drop returns true. Sentence
+// terminators and line breaks are kept with the sentence they end.
+func dropSentences(s string, drop func(string) bool) string {
+	var sb strings.Builder
+	start := 0
+	runes := []rune(s)
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && !isSentenceEnd(runes[i]) {
+			continue
+		}
+		end := i
+		if i < len(runes) {
+			end = i + 1
+		}
+		sentence := string(runes[start:end])
+		if !drop(sentence) {
+			sb.WriteString(sentence)
+		} else if strings.HasSuffix(sentence, "\n") {
+			sb.WriteString("\n")
+		}
+		start = end
+	}
+	return sb.String()
+}
+
+func isSentenceEnd(r rune) bool {
+	switch r {
+	case '。', '！', '？', '!', '?', '\n':
+		return true
+	}
+	return false
+}
+
+// chineseNumerals rewrites Arabic digits as Chinese numerals. Digits inside
+// Latin words (MBTI types, model names), list markers and 4-digit years are
+// handled specially.
+func chineseNumerals(s string, locale i18n.Locale) string {
+	matches := reNumber.FindAllStringIndex(s, -1)
+	if len(matches) == 0 {
+		return s
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		token := s[start:end]
+		before, after := s[:start], s[end:]
+
+		if isASCIIWordEdge(before, true) || isASCIIWordEdge(after, false) || isListMarker(before, after) {
+			continue
+		}
+
+		sb.WriteString(s[last:start])
+		percent := strings.HasSuffix(token, "%") || strings.HasSuffix(token, "％")
+		number := strings.TrimRight(token, "%％")
+		intPart, frac, hasFrac := strings.Cut(number, ".")
+
+		text := chineseInteger(intPart, locale)
+		if !hasFrac && len(intPart) == 4 && strings.HasPrefix(after, "年") {
+			text = digitsOnly(intPart)
+		}
+		if hasFrac {
+			text += "点" + digitsOnly(frac)
+		}
+		if percent {
+			text = "百分之" + text
+		}
+		sb.WriteString(text)
+		last = end
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// isASCIIWordEdge reports whether the number touches a Latin letter, e.g. "INFJ2" or "5G".
+func isASCIIWordEdge(side string, before bool) bool {
+	if side == "" {
+		return false
+	}
+	var r rune
+	if before {
+		r = []rune(side)[len([]rune(side))-1]
+	} else {
+		r = []rune(side)[0]
+	}
+	return r < unicode.MaxASCII && unicode.IsLetter(r)
+}
+
+// isListMarker keeps ordered list numbering such as "1. " at the start of a line.
+func isListMarker(before, after string) bool {
+	lineStart := before[strings.LastIndexByte(before, '\n')+1:]
+	if !reBlankPrefix.MatchString(lineStart) {
+		return false
+	}
+	return strings.HasPrefix(after, ". ") || strings.HasPrefix(after, ") ")
+}
+
+var chineseDigits = []string{"〇", "一", "二", "三", "四", "五", "六", "七", "八", "九"}
+
+func digitsOnly(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		sb.WriteString(chineseDigits[r-'0'])
+	}
+	return sb.String()
+}
+
+// chineseInteger reads a number aloud, e.g. 85 -> 八十五, 10086 -> 一万零八十六.
+// Numbers with leading zeros or more than eight digits are read digit by digit.
+func chineseInteger(s string, locale i18n.Locale) string {
+	if len(s) > 8 || (len(s) > 1 && s[0] == '0') {
+		return digitsOnly(s)
+	}
+	n := 0
+	for _, r := range s {
+		n = n*10 + int(r-'0')
+	}
+	if n == 0 {
+		return "零"
+	}
+	wan := "万"
+	if locale == i18n.ZhTW {
+		wan = "萬"
+	}
+	if n < 10000 {
+		return chineseSection(n, true)
+	}
+	high, low := n/10000, n%10000
+	text := chineseSection(high, true) + wan
+	if low == 0 {
+		return text
+	}
+	if low < 1000 {
+		text += "零"
+	}
+	return text + chineseSection(low, false)
+}
+
+// chineseSection renders 1-9999; leading "一十" is shortened to "十" when allowed.
+func chineseSection(n int, shortTen bool) string {
+	units := []string{"千", "百", "十", ""}
+	divisors := []int{1000, 100, 10, 1}
+	var sb strings.Builder
+	pendingZero := false
+	started := false
+	for i, d := range divisors {
+		digit := n / d % 10
+		if digit == 0 {
+			if started {
+				pendingZero = true
+			}
+			continue
+		}
+		if pendingZero {
+			sb.WriteString("零")
+			pendingZero = false
+		}
+		if !(shortTen && !started && d == 10 && digit == 1) {
+			sb.WriteString(chineseDigits[digit])
+		}
+		sb.WriteString(units[i])
+		started = true
+	}
+	return sb.String()
+}
+
+func addApplied(applied *[]string, kind string) {
+	for _, a := range *applied {
+		if a == kind {
+			return
+		}
+	}
+	*applied = append(*applied, kind)
+}
+
+// StreamSanitizer applies Sanitize to a token stream. Tokens are buffered up to
+// the last sentence boundary so tags, links and numbers are never split.
+type StreamSanitizer struct {
+	locale  i18n.Locale
+	emit    func(string)
+	buf     strings.Builder
+	applied []string
+}
+
+// maxStreamHold bounds how much text is held back waiting for a closing tag.
+const maxStreamHold = 4096
+
+func NewStreamSanitizer(locale i18n.Locale, emit func(string)) *StreamSanitizer {
+	return &StreamSanitizer{locale: locale, emit: emit}
+}
+
+// Write buffers a token and emits every complete sentence.
+func (s *StreamSanitizer) Write(token string) {
+	s.buf.WriteString(token)
+	text := s.buf.String()
+	cut := streamCut(text)
+	if cut <= 0 {
+		return
+	}
+	s.buf.Reset()
+	s.buf.WriteString(text[cut:])
+	if out := sanitize(text[:cut], s.locale, &s.applied); out != "" {
+		s.emit(out)
+	}
+}
+
+// Flush emits whatever is left in the buffer; call it once the stream ends.
+func (s *StreamSanitizer) Flush() {
+	text := s.buf.String()
+	s.buf.Reset()
+	if out := sanitize(text, s.locale, &s.applied); out != "" {
+		s.emit(out)
+	}
+}
+
+// Applied lists the transformations made so far.
+func (s *StreamSanitizer) Applied() []string {
+	return s.applied
+}
+
+// streamCut returns the byte offset up to which text can be sanitized safely.
+func streamCut(text string) int {
+	limit := len(text)
+	if len(text) < maxStreamHold {
+		lower := strings.ToLower(text)
+		// Hold an open script/style block until it closes
+		if loc := reScriptOpen.FindStringIndex(lower); loc != nil && !reScriptBlock.MatchString(lower[loc[0]:]) {
+			limit = loc[0]
+		}
+		// Hold a tag that has not closed yet
+		if lt := strings.LastIndexByte(text[:limit], '<'); lt != -1 && !strings.Contains(text[lt:limit], ">") {
+			limit = lt
+		}
+	}
+	cut := strings.LastIndexAny(text[:limit], "。！？!?\n")
+	if cut == -1 {
+		if len(text) >= maxStreamHold {
+			return len(text)
+		}
+		return 0
+	}
+	_, size := utf8.DecodeRuneInString(text[cut:])
+	return cut + size
+}
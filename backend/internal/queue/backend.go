@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNoTask 表示在阻塞超时内没有新任务
+	ErrNoTask = errors.New("no task available")
+	// ErrNotFound 表示任务状态或死信不存在（或已过期）
+	ErrNotFound = errors.New("task not found")
+)
+
+// StatusStore 保存任务状态并向订阅者广播变化。状态在最后一次写入 TaskExpiration 后过期。
+type StatusStore interface {
+	// UpdateStatus 写入任务状态并广播
+	UpdateStatus(ctx context.Context, taskID string, status TaskStatus, result interface{}, errStr string) error
+	// GetStatus 返回任务状态及其提交者，排队中的任务附带位置与预计等待时间；不存在时返回 ErrNotFound
+	GetStatus(ctx context.Context, taskID string) (*TaskResult, error)
+	// Subscribe 订阅任务状态变化，返回时订阅已经生效，因此调用方应先订阅再读取当前状态。
+	// 用完后必须调用返回的 stop。
+	Subscribe(ctx context.Context, taskID string) (events <-chan TaskResult, stop func(), err error)
+}
+
+// Queue 是异步任务队列：按身份公平调度、租约、失败重试与死信、提交去重和取消。
+// RedisQueue 供生产环境多实例共享；MemoryQueue 供单进程开发模式使用。
+type Queue interface {
+	StatusStore
+
+	// Enqueue 登记提交者与初始状态并将任务推入队列
+	Enqueue(ctx context.Context, taskID string, payload TaskPayload) error
+	// Dequeue 等待并取出一个任务，单个身份同时执行的任务不超过 maxActive；超时无任务时返回 ErrNoTask
+	Dequeue(ctx context.Context, maxActive int) (*Delivery, error)
+	// Ack 确认任务已处理完毕，应在写入最终状态之后调用
+	Ack(ctx context.Context, d *Delivery) error
+	// Requeue 将未完成的任务放回队首，不计入失败次数
+	Requeue(ctx context.Context, d *Delivery) error
+	// Extend 延长任务租约
+	Extend(ctx context.Context, d *Delivery) error
+	// Fail 处理一次失败的执行：仍可重试时延迟重新入队并返回 true，否则写入死信
+	Fail(ctx context.Context, d *Delivery, policy RetryPolicy, cause error) (bool, error)
+	// RunReaper 周期性回收过期租约等，直到 ctx 结束
+	RunReaper(ctx context.Context, interval time.Duration)
+	// RunScheduler 周期性将到期的重试任务重新入队，直到 ctx 结束
+	RunScheduler(ctx context.Context, interval time.Duration)
+	// SetMaxRate 设置全局 LLM 限流速率（次/秒），用于估算等待时间
+	SetMaxRate(rate float64)
+
+	// Claim 为 taskID 登记提交指纹，某个指纹已被其他任务占用时返回该任务 ID
+	Claim(ctx context.Context, taskID string, keys ...IdempotencyKey) (string, error)
+	// ReleaseClaim 删除 taskID 登记的指纹
+	ReleaseClaim(ctx context.Context, taskID string, keys ...IdempotencyKey) error
+
+	// Cancel 取消 identity 提交的任务
+	Cancel(ctx context.Context, taskID, identity string) (CancelOutcome, error)
+	// CancelRequested 返回执行中的任务是否已被请求取消
+	CancelRequested(ctx context.Context, taskID string) bool
+	// SubscribeCancels 订阅执行中任务的取消通知，通道中为任务 ID；用完后必须调用返回的 stop
+	SubscribeCancels(ctx context.Context) (ids <-chan string, stop func(), err error)
+
+	ListDeadLetters(ctx context.Context) ([]DeadLetter, error)
+	// GetDeadLetter 返回单条死信，不存在时返回 ErrNotFound
+	GetDeadLetter(ctx context.Context, taskID string) (*DeadLetter, error)
+	// ReplayDeadLetter 以全新的尝试次数重新入队，并将负载标记为 Replayed
+	ReplayDeadLetter(ctx context.Context, taskID string) error
+	PurgeDeadLetters(ctx context.Context, taskIDs ...string) (int64, error)
+}
+
+var (
+	_ Queue = (*RedisQueue)(nil)
+	_ Queue = (*MemoryQueue)(nil)
+)
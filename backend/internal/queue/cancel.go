@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// 正在执行的任务被取消时在此频道广播任务 ID，持有该任务的 Worker 中断执行
+	TaskCancelChannel = TaskQueueKeyPrefix + ":cancellations"
+	// 取消标记 {prefix}:{id}，防止 Worker 错过广播（例如刚出队尚未登记，或崩溃后被重新投递）
+	TaskCancelKeyPrefix = TaskQueueKeyPrefix + ":cancel"
+)
+
+// ErrCancelled 是被用户取消的任务执行上下文的 cause
+var ErrCancelled = errors.New("task cancelled")
+
+// CancelOutcome 是一次取消请求的结果
+type CancelOutcome int
+
+const (
+	CancelNotFound  CancelOutcome = iota // 任务不存在、不属于该身份或已经结束
+	CancelRemoved                        // 任务尚未执行，已移出队列
+	CancelSignalled                      // 任务正在执行，已通知 Worker 中断
+)
+
+// cancelScript 仅在 identity 自己的任务中查找：排队中与等待重试的直接移除，执行中的登记取消标记并广播。
+// KEYS: -；ARGV: prefix, task id, identity, cancel flag ttl(ms)
+var cancelScript = redis.NewScript(luaLib + `
+local prefix, id, ident = ARGV[1], ARGV[2], ARGV[3]
+local function owned(msg)
+	local _, i, mid = route(msg)
+	return mid == id and i == ident
+end
+
+-- 身份队列清空后，dequeue 脚本会将其移出通道轮转
+local list = prefix .. ':tasks:' .. ident
+for _, msg in ipairs(redis.call('LRANGE', list, 0, -1)) do
+	if owned(msg) then
+		redis.call('LREM', list, 1, msg)
+		redis.call('ZREM', prefix .. ':pending', id)
+		return 'removed'
+	end
+end
+for _, msg in ipairs(redis.call('ZRANGE', prefix .. ':delayed', 0, -1)) do
+	if owned(msg) then
+		redis.call('ZREM', prefix .. ':delayed', msg)
+		return 'removed'
+	end
+end
+for _, msg in ipairs(redis.call('LRANGE', prefix .. ':processing', 0, -1)) do
+	if owned(msg) then
+		redis.call('SET', prefix .. ':cancel:' .. id, '1', 'PX', ARGV[4])
+		redis.call('PUBLISH', prefix .. ':cancellations', id)
+		return 'signalled'
+	end
+end
+return false
+`)
+
+// Cancel 取消 identity 提交的任务。排队中的任务直接标记为 cancelled；
+// 执行中的任务由 Worker 中断 LLM 调用后标记，若此时已经完成则保持 completed。
+func (q *RedisQueue) Cancel(ctx context.Context, taskID, identity string) (CancelOutcome, error) {
+	res, err := cancelScript.Run(ctx, q.rdb, nil,
+		TaskQueueKeyPrefix, taskID, identity, TaskExpiration.Milliseconds(),
+	).Text()
+	switch {
+	case err == redis.Nil:
+		return CancelNotFound, nil
+	case err != nil:
+		return CancelNotFound, err
+	case res == "signalled":
+		return CancelSignalled, nil
+	}
+	state := TaskResult{Status: StatusCancelled, UpdatedAt: time.Now()}
+	return CancelRemoved, q.writeStatus(ctx, q.rdb, taskID, state)
+}
+
+// CancelRequested 返回任务是否已被请求取消
+func (q *RedisQueue) CancelRequested(ctx context.Context, taskID string) bool {
+	n, err := q.rdb.Exists(ctx, fmt.Sprintf("%s:%s", TaskCancelKeyPrefix, taskID)).Result()
+	return err == nil && n > 0
+}
+
+// SubscribeCancels 订阅执行中任务的取消广播，通道中为任务 ID
+func (q *RedisQueue) SubscribeCancels(ctx context.Context) (<-chan string, func(), error) {
+	return relay(ctx, q.rdb, TaskCancelChannel, func(payload string) (string, bool) {
+		return payload, true
+	})
+}
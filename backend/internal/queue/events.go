@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TaskEventsChannelPrefix 任务状态变化通过 Redis pub/sub 广播，任意 API 实例都能推送给客户端
+const TaskEventsChannelPrefix = "task:events"
+
+func eventsChannel(taskID string) string {
+	return fmt.Sprintf("%s:%s", TaskEventsChannelPrefix, taskID)
+}
+
+// Terminal 表示任务不会再有后续状态
+func (s TaskStatus) Terminal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// writeStatus 保存任务状态并广播给订阅者；c 可以是客户端或事务管道
+func (q *RedisQueue) writeStatus(ctx context.Context, c redis.Cmdable, taskID string, state TaskResult) error {
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := c.Set(ctx, fmt.Sprintf("%s:%s", TaskStatusKeyPrefix, taskID), bytes, TaskExpiration).Err(); err != nil {
+		return err
+	}
+	// 提交者与状态同时过期
+	if err := c.Expire(ctx, ownerKey(taskID), TaskExpiration).Err(); err != nil {
+		return err
+	}
+	return c.Publish(ctx, eventsChannel(taskID), bytes).Err()
+}
+
+// Subscribe 订阅任务的状态变化，返回时订阅已经生效
+func (q *RedisQueue) Subscribe(ctx context.Context, taskID string) (<-chan TaskResult, func(), error) {
+	return relay(ctx, q.rdb, eventsChannel(taskID), func(payload string) (TaskResult, bool) {
+		var st TaskResult
+		return st, json.Unmarshal([]byte(payload), &st) == nil
+	})
+}
+
+// relay 订阅 Redis 频道并将消息解码后转发到返回的通道，直到调用返回的 stop。
+// 断线后 go-redis 会自动重新订阅。
+func relay[T any](ctx context.Context, rdb *redis.Client, channel string, decode func(string) (T, bool)) (<-chan T, func(), error) {
+	sub := rdb.Subscribe(ctx, channel)
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, nil, err
+	}
+	out := make(chan T)
+	done := make(chan struct{})
+	go func() {
+		defer close(out)
+		for msg := range sub.Channel() {
+			v, ok := decode(msg.Payload)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- v:
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(done)
+			sub.Close()
+		})
+	}
+	return out, stop, nil
+}
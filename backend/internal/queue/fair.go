@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Lane 是任务的优先级通道
+type Lane string
+
+const (
+	LaneAdmin      Lane = "admin"      // 管理员密钥提交
+	LaneRegistered Lane = "registered" // 登录用户
+	LaneGuest      Lane = "guest"      // 匿名设备
+)
+
+const (
+	// 有新任务或有身份释放了并发名额时写入，空闲的 Worker 在此阻塞等待
+	TaskSignalKey = TaskQueueKeyPrefix + ":signal"
+	// 每个身份正在执行的任务数
+	TaskActiveKey = TaskQueueKeyPrefix + ":active"
+
+	// DefaultMaxShare 单个身份最多占用的 Worker 比例
+	DefaultMaxShare = 0.2
+)
+
+// lanePriority 是通道的固定优先级，加权轮转选中的通道为空时按此顺序兜底
+var lanePriority = []Lane{LaneAdmin, LaneRegistered, LaneGuest}
+
+// laneSchedule 是加权轮转表：每 9 次出队中 admin 优先 4 次、registered 3 次、guest 2 次。
+// 优先通道为空时会继续尝试其他通道，因此低优先级通道不会被饿死。
+var laneSchedule = []Lane{
+	LaneAdmin, LaneRegistered, LaneAdmin, LaneGuest, LaneRegistered,
+	LaneAdmin, LaneRegistered, LaneGuest, LaneAdmin,
+}
+
+// laneOrder 返回第 tick 次出队时尝试通道的顺序，如 "guest,admin,registered"
+func laneOrder(tick uint64) string {
+	first := laneSchedule[tick%uint64(len(laneSchedule))]
+	order := []string{string(first)}
+	for _, l := range lanePriority {
+		if l != first {
+			order = append(order, string(l))
+		}
+	}
+	return strings.Join(order, ",")
+}
+
+// laneFor 返回任务的通道：提交方指定的优先，否则按是否登录推断
+func laneFor(p TaskPayload) Lane {
+	switch p.Lane {
+	case LaneAdmin, LaneRegistered, LaneGuest:
+		return p.Lane
+	}
+	if p.UserID != nil {
+		return LaneRegistered
+	}
+	return LaneGuest
+}
+
+// Identity 返回公平调度使用的身份：登录用户按用户 ID，匿名请求按设备
+func Identity(p TaskPayload) string {
+	if p.UserID != nil {
+		return fmt.Sprintf("user:%d", *p.UserID)
+	}
+	if p.DeviceHash != "" && p.DeviceHash != "anonymous" {
+		return "device:" + p.DeviceHash
+	}
+	return AnonymousIdentity
+}
+
+// MaxActive 根据 Worker 数与比例计算单个身份的并发上限（至少为 1）。
+// 所有 LLM 调用都在共享的全局限流器上排队，因此并发上限同时限制了该身份占用的 QPS 份额。
+func MaxActive(concurrency int, share float64) int {
+	if share <= 0 || share > 1 {
+		share = DefaultMaxShare
+	}
+	n := int(math.Ceil(float64(concurrency) * share))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// luaLib 是各脚本共用的函数。任务按身份存放在 {prefix}:tasks:{identity}，
+// 每个通道用 ring 列表轮转有待处理任务的身份（members 集合用于去重）。
+// 这些键由脚本动态拼接，因此要求单节点 Redis（非 Cluster）。
+const luaLib = `
+local function signal(prefix)
+	redis.call('LPUSH', prefix .. ':signal', '1')
+	redis.call('LTRIM', prefix .. ':signal', 0, 999)
+end
+
+local function route(msg)
+	local env = cjson.decode(msg)
+	local lane, ident = env['lane'], env['identity']
+	if type(lane) ~= 'string' or lane == '' then lane = 'guest' end
+	if type(ident) ~= 'string' or ident == '' then ident = 'device:anonymous' end
+	return lane, ident, env['id']
+end
+
+-- 在全局 pending 集合中登记任务的排队顺序，front 为 true 时排在所有人之前
+local function pending_add(prefix, id, front)
+	if type(id) ~= 'string' then return end
+	local key = prefix .. ':pending'
+	local score
+	if front then
+		local head = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+		score = (tonumber(head[2]) or 0) - 1
+	else
+		score = redis.call('INCR', prefix .. ':seq')
+	end
+	redis.call('ZADD', key, score, id)
+end
+
+local function pending_remove(prefix, msg)
+	local _, _, id = route(msg)
+	if type(id) == 'string' then
+		redis.call('ZREM', prefix .. ':pending', id)
+	end
+end
+
+-- front 为 true 时放在该身份队列的最前面（重试、回收的任务优先）
+local function push_task(prefix, msg, front)
+	local lane, ident, id = route(msg)
+	local list = prefix .. ':tasks:' .. ident
+	if front then
+		redis.call('RPUSH', list, msg)
+	else
+		redis.call('LPUSH', list, msg)
+	end
+	if redis.call('SADD', prefix .. ':lane:' .. lane .. ':members', ident) == 1 then
+		redis.call('RPUSH', prefix .. ':lane:' .. lane .. ':ring', ident)
+	end
+	pending_add(prefix, id, front)
+	signal(prefix)
+end
+
+-- 归还并发名额；该身份仍有排队任务时唤醒一个 Worker
+local function release(prefix, msg)
+	local _, ident = route(msg)
+	if redis.call('HINCRBY', prefix .. ':active', ident, -1) <= 0 then
+		redis.call('HDEL', prefix .. ':active', ident)
+	end
+	if redis.call('EXISTS', prefix .. ':tasks:' .. ident) == 1 then
+		signal(prefix)
+	end
+end
+`
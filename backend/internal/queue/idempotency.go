@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TaskIdempotencyKeyPrefix 保存“提交指纹 -> 任务 ID”的映射
+const TaskIdempotencyKeyPrefix = "task:idem"
+
+// IdempotencyKey 是一次提交的去重指纹及其有效期
+type IdempotencyKey struct {
+	Key string
+	TTL time.Duration
+}
+
+// NewIdempotencyKey 将任意字符串部件哈希为固定长度的 Redis 键
+func NewIdempotencyKey(ttl time.Duration, parts ...string) IdempotencyKey {
+	h := sha1.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return IdempotencyKey{Key: TaskIdempotencyKeyPrefix + ":" + hex.EncodeToString(h.Sum(nil)), TTL: ttl}
+}
+
+// claimScript 任一指纹已存在时返回对应的任务 ID，否则为所有指纹登记新任务
+// KEYS: 指纹；ARGV: task id, ttl(ms) ...
+var claimScript = redis.NewScript(`
+for _, k in ipairs(KEYS) do
+	local v = redis.call('GET', k)
+	if v then
+		return v
+	end
+end
+for i, k in ipairs(KEYS) do
+	redis.call('SET', k, ARGV[1], 'PX', ARGV[i + 1])
+end
+return false
+`)
+
+// Claim 为 taskID 登记提交指纹。若某个指纹已对应其他任务，返回该任务 ID 且不做任何修改；
+// 返回空字符串表示登记成功，调用方应继续入队。
+func (q *RedisQueue) Claim(ctx context.Context, taskID string, keys ...IdempotencyKey) (string, error) {
+	if len(keys) == 0 {
+		return "", nil
+	}
+	names := make([]string, len(keys))
+	args := []interface{}{taskID}
+	for i, k := range keys {
+		names[i] = k.Key
+		args = append(args, k.TTL.Milliseconds())
+	}
+	existing, err := claimScript.Run(ctx, q.rdb, names, args...).Text()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return existing, err
+}
+
+// releaseScript 仅删除仍指向 taskID 的指纹，避免误删其他任务的登记
+// KEYS: 指纹；ARGV: task id
+var releaseScript = redis.NewScript(`
+for _, k in ipairs(KEYS) do
+	if redis.call('GET', k) == ARGV[1] then
+		redis.call('DEL', k)
+	end
+end
+return 1
+`)
+
+// ReleaseClaim 删除 taskID 登记的指纹，用于入队失败或原任务失败后允许重新提交
+func (q *RedisQueue) ReleaseClaim(ctx context.Context, taskID string, keys ...IdempotencyKey) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.Key
+	}
+	return releaseScript.Run(ctx, q.rdb, names, taskID).Err()
+}
@@ -0,0 +1,556 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryQueue 是进程内的 Queue 实现，用于无 Redis 的开发模式（FROMHEART_MODE=dev）。
+// 公平调度、重试、去重与取消的行为与 RedisQueue 一致；队列内容随进程退出而丢失，
+// 也不能在多个实例之间共享。任务不会比进程活得更久，因此没有租约。
+type MemoryQueue struct {
+	mu      sync.Mutex
+	tick    uint64
+	maxRate float64
+	seq     float64
+
+	lists      map[string][]string // 身份 -> 待处理消息，下标 0 最先出队
+	rings      map[Lane][]string   // 每个通道中轮转的身份
+	members    map[Lane]map[string]bool
+	pending    map[string]float64 // 任务 ID -> 排队顺序
+	processing map[string]bool    // 执行中的原始消息
+	active     map[string]int     // 每个身份正在执行的任务数
+	delayed    []memoryDelayed
+	dead       map[string]DeadLetter
+	statuses   map[string]memoryStatus
+	claims     map[string]memoryClaim
+	cancels    map[string]time.Time // 取消标记 -> 过期时间
+	done       []time.Time          // 统计窗口内的完成时间
+
+	wake       chan struct{} // 有新任务或名额释放时关闭并替换，唤醒等待中的 Dequeue
+	subs       map[string]map[chan TaskResult]struct{}
+	cancelSubs map[chan string]struct{}
+}
+
+type memoryDelayed struct {
+	due time.Time
+	raw string
+}
+
+type memoryStatus struct {
+	data    []byte
+	owner   string
+	expires time.Time
+}
+
+type memoryClaim struct {
+	taskID  string
+	expires time.Time
+}
+
+// subscriberBuffer 订阅者来不及读取时丢弃多余的事件，客户端仍可通过 GetStatus 取得最新状态
+const subscriberBuffer = 16
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{
+		lists:      make(map[string][]string),
+		rings:      make(map[Lane][]string),
+		members:    make(map[Lane]map[string]bool),
+		pending:    make(map[string]float64),
+		processing: make(map[string]bool),
+		active:     make(map[string]int),
+		dead:       make(map[string]DeadLetter),
+		statuses:   make(map[string]memoryStatus),
+		claims:     make(map[string]memoryClaim),
+		cancels:    make(map[string]time.Time),
+		wake:       make(chan struct{}),
+		subs:       make(map[string]map[chan TaskResult]struct{}),
+		cancelSubs: make(map[chan string]struct{}),
+	}
+}
+
+// route 与 luaLib 中的 route 相同：返回消息的通道、身份与任务 ID
+func route(raw string) (Lane, string, string) {
+	var env envelope
+	json.Unmarshal([]byte(raw), &env)
+	lane, ident := env.Lane, env.Identity
+	if lane == "" {
+		lane = LaneGuest
+	}
+	if ident == "" {
+		ident = "device:anonymous"
+	}
+	return lane, ident, env.ID
+}
+
+func (q *MemoryQueue) signalLocked() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// pushLocked 将消息放入所属身份的队列，front 为 true 时放在最前面
+func (q *MemoryQueue) pushLocked(raw string, front bool) {
+	lane, ident, id := route(raw)
+	if front {
+		q.lists[ident] = append([]string{raw}, q.lists[ident]...)
+		min := 0.0
+		for _, score := range q.pending {
+			if score < min {
+				min = score
+			}
+		}
+		q.pending[id] = min - 1
+	} else {
+		q.lists[ident] = append(q.lists[ident], raw)
+		q.seq++
+		q.pending[id] = q.seq
+	}
+	if q.members[lane] == nil {
+		q.members[lane] = make(map[string]bool)
+	}
+	if !q.members[lane][ident] {
+		q.members[lane][ident] = true
+		q.rings[lane] = append(q.rings[lane], ident)
+	}
+	q.signalLocked()
+}
+
+// popLocked 与 dequeueScript 相同：按通道顺序轮转各身份，跳过已达并发上限的身份
+func (q *MemoryQueue) popLocked(order string, maxActive int) (string, bool) {
+	for _, name := range strings.Split(order, ",") {
+		lane := Lane(name)
+		for n := len(q.rings[lane]); n > 0; n-- {
+			ring := q.rings[lane]
+			ident := ring[0]
+			q.rings[lane] = append(ring[1:], ident)
+
+			list := q.lists[ident]
+			raw, ok := "", false
+			if q.active[ident] < maxActive && len(list) > 0 {
+				raw, ok = list[0], true
+				list = list[1:]
+				q.lists[ident] = list
+			}
+			if len(list) == 0 {
+				delete(q.lists, ident)
+				q.removeFromRingLocked(lane, ident)
+			}
+			if ok {
+				_, _, id := route(raw)
+				delete(q.pending, id)
+				q.processing[raw] = true
+				q.active[ident]++
+				return raw, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (q *MemoryQueue) removeFromRingLocked(lane Lane, ident string) {
+	ring := q.rings[lane][:0]
+	for _, i := range q.rings[lane] {
+		if i != ident {
+			ring = append(ring, i)
+		}
+	}
+	q.rings[lane] = ring
+	delete(q.members[lane], ident)
+}
+
+// ackLocked 与 ackScript 相同：仅当消息仍在执行中时归还并发名额
+func (q *MemoryQueue) ackLocked(raw string, requeue bool) {
+	if !q.processing[raw] {
+		return
+	}
+	delete(q.processing, raw)
+	_, ident, _ := route(raw)
+	if q.active[ident]--; q.active[ident] <= 0 {
+		delete(q.active, ident)
+	}
+	if len(q.lists[ident]) > 0 {
+		q.signalLocked()
+	}
+	if requeue {
+		q.pushLocked(raw, true)
+		return
+	}
+	now := time.Now()
+	q.done = append(q.done, now)
+	q.trimDoneLocked(now)
+}
+
+func (q *MemoryQueue) trimDoneLocked(now time.Time) {
+	start := now.Add(-throughputWindow)
+	i := sort.Search(len(q.done), func(i int) bool { return q.done[i].After(start) })
+	q.done = q.done[i:]
+}
+
+// writeStatusLocked 保存任务状态并广播给订阅者，保留已登记的提交者
+func (q *MemoryQueue) writeStatusLocked(taskID string, state TaskResult) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	st := q.statuses[taskID]
+	st.data, st.expires = data, time.Now().Add(TaskExpiration)
+	q.statuses[taskID] = st
+	for ch := range q.subs[taskID] {
+		var ev TaskResult
+		json.Unmarshal(data, &ev)
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return nil
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, taskID string, payload TaskPayload) error {
+	raw, err := json.Marshal(newEnvelope(taskID, payload, 0))
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.statuses[taskID] = memoryStatus{owner: Identity(payload)}
+	if err := q.writeStatusLocked(taskID, TaskResult{Status: StatusPending, UpdatedAt: time.Now()}); err != nil {
+		return err
+	}
+	q.pushLocked(string(raw), false)
+	return nil
+}
+
+func (q *MemoryQueue) Dequeue(ctx context.Context, maxActive int) (*Delivery, error) {
+	for waited := false; ; waited = true {
+		q.mu.Lock()
+		q.tick++
+		raw, ok := q.popLocked(laneOrder(q.tick), maxActive)
+		wake := q.wake
+		q.mu.Unlock()
+		if ok {
+			var env envelope
+			json.Unmarshal([]byte(raw), &env)
+			return &Delivery{ID: env.ID, Payload: &env.Payload, Attempt: env.Attempt, raw: raw}, nil
+		}
+		if waited {
+			return nil, ErrNoTask
+		}
+		timer := time.NewTimer(dequeueBlockTimeout)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, ErrNoTask
+		case <-wake:
+			timer.Stop()
+		}
+	}
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, d *Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ackLocked(d.raw, false)
+	return nil
+}
+
+func (q *MemoryQueue) Requeue(ctx context.Context, d *Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ackLocked(d.raw, true)
+	return q.writeStatusLocked(d.ID, TaskResult{Status: StatusPending, UpdatedAt: time.Now()})
+}
+
+// Extend 无需操作：进程内的任务不会在进程崩溃后继续存在
+func (q *MemoryQueue) Extend(ctx context.Context, d *Delivery) error {
+	return nil
+}
+
+func (q *MemoryQueue) Fail(ctx context.Context, d *Delivery, policy RetryPolicy, cause error) (bool, error) {
+	attempt := d.Attempt + 1
+	now := time.Now()
+	retry := !IsPermanent(cause) && attempt < policy.MaxAttempts
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if retry {
+		raw, err := json.Marshal(newEnvelope(d.ID, *d.Payload, attempt))
+		if err != nil {
+			return false, err
+		}
+		q.delayed = append(q.delayed, memoryDelayed{due: now.Add(policy.Backoff(attempt)), raw: string(raw)})
+		q.writeStatusLocked(d.ID, TaskResult{Status: StatusPending, Error: cause.Error(), Attempt: attempt, UpdatedAt: now})
+	} else {
+		q.dead[d.ID] = DeadLetter{ID: d.ID, Payload: *d.Payload, Attempts: attempt, Error: cause.Error(), FailedAt: now}
+		q.writeStatusLocked(d.ID, TaskResult{Status: StatusFailed, Error: cause.Error(), Attempt: attempt, UpdatedAt: now})
+	}
+	q.ackLocked(d.raw, false)
+	return retry, nil
+}
+
+// RunReaper 周期性清理过期的任务状态、提交指纹与取消标记，直到 ctx 结束
+func (q *MemoryQueue) RunReaper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			for id, st := range q.statuses {
+				if now.After(st.expires) {
+					delete(q.statuses, id)
+				}
+			}
+			for k, c := range q.claims {
+				if now.After(c.expires) {
+					delete(q.claims, k)
+				}
+			}
+			for id, exp := range q.cancels {
+				if now.After(exp) {
+					delete(q.cancels, id)
+				}
+			}
+			q.trimDoneLocked(now)
+			q.mu.Unlock()
+		}
+	}
+}
+
+// RunScheduler 周期性将到期的重试任务放回所属身份队列的最前面，直到 ctx 结束
+func (q *MemoryQueue) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.mu.Lock()
+			waiting := q.delayed[:0]
+			for _, t := range q.delayed {
+				if now.Before(t.due) {
+					waiting = append(waiting, t)
+				} else {
+					q.pushLocked(t.raw, true)
+				}
+			}
+			q.delayed = waiting
+			q.mu.Unlock()
+		}
+	}
+}
+
+func (q *MemoryQueue) SetMaxRate(rate float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.maxRate = rate
+}
+
+func (q *MemoryQueue) UpdateStatus(ctx context.Context, taskID string, status TaskStatus, result interface{}, errStr string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.writeStatusLocked(taskID, TaskResult{Status: status, Result: result, Error: errStr, UpdatedAt: time.Now()})
+}
+
+func (q *MemoryQueue) GetStatus(ctx context.Context, taskID string) (*TaskResult, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	st, ok := q.statuses[taskID]
+	if !ok || st.data == nil || time.Now().After(st.expires) {
+		return nil, ErrNotFound
+	}
+	var res TaskResult
+	json.Unmarshal(st.data, &res)
+	res.Owner = st.owner
+	res.QueuePosition = nil
+	if score, ok := q.pending[taskID]; ok && res.Status == StatusPending {
+		ahead := 0
+		for _, s := range q.pending {
+			if s < score {
+				ahead++
+			}
+		}
+		now := time.Now()
+		q.trimDoneLocked(now)
+		res.QueuePosition = estimatePosition(ahead, float64(len(q.done))/throughputWindow.Seconds(), q.maxRate)
+	}
+	return &res, nil
+}
+
+func (q *MemoryQueue) Subscribe(ctx context.Context, taskID string) (<-chan TaskResult, func(), error) {
+	ch := make(chan TaskResult, subscriberBuffer)
+	q.mu.Lock()
+	if q.subs[taskID] == nil {
+		q.subs[taskID] = make(map[chan TaskResult]struct{})
+	}
+	q.subs[taskID][ch] = struct{}{}
+	q.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			delete(q.subs[taskID], ch)
+			if len(q.subs[taskID]) == 0 {
+				delete(q.subs, taskID)
+			}
+			close(ch)
+		})
+	}
+	return ch, stop, nil
+}
+
+func (q *MemoryQueue) Claim(ctx context.Context, taskID string, keys ...IdempotencyKey) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for _, k := range keys {
+		if c, ok := q.claims[k.Key]; ok && now.Before(c.expires) {
+			return c.taskID, nil
+		}
+	}
+	for _, k := range keys {
+		q.claims[k.Key] = memoryClaim{taskID: taskID, expires: now.Add(k.TTL)}
+	}
+	return "", nil
+}
+
+func (q *MemoryQueue) ReleaseClaim(ctx context.Context, taskID string, keys ...IdempotencyKey) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, k := range keys {
+		if c, ok := q.claims[k.Key]; ok && c.taskID == taskID {
+			delete(q.claims, k.Key)
+		}
+	}
+	return nil
+}
+
+// Cancel 与 cancelScript 相同：仅在 identity 自己的任务中查找
+func (q *MemoryQueue) Cancel(ctx context.Context, taskID, identity string) (CancelOutcome, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	owned := func(raw string) bool {
+		_, ident, id := route(raw)
+		return id == taskID && ident == identity
+	}
+	cancelled := TaskResult{Status: StatusCancelled, UpdatedAt: time.Now()}
+
+	// 身份队列清空后，popLocked 会将其移出通道轮转
+	list := q.lists[identity]
+	for i, raw := range list {
+		if owned(raw) {
+			q.lists[identity] = append(list[:i:i], list[i+1:]...)
+			delete(q.pending, taskID)
+			return CancelRemoved, q.writeStatusLocked(taskID, cancelled)
+		}
+	}
+	for i, t := range q.delayed {
+		if owned(t.raw) {
+			q.delayed = append(q.delayed[:i:i], q.delayed[i+1:]...)
+			return CancelRemoved, q.writeStatusLocked(taskID, cancelled)
+		}
+	}
+	for raw := range q.processing {
+		if owned(raw) {
+			q.cancels[taskID] = time.Now().Add(TaskExpiration)
+			for ch := range q.cancelSubs {
+				select {
+				case ch <- taskID:
+				default:
+				}
+			}
+			return CancelSignalled, nil
+		}
+	}
+	return CancelNotFound, nil
+}
+
+func (q *MemoryQueue) CancelRequested(ctx context.Context, taskID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	exp, ok := q.cancels[taskID]
+	return ok && time.Now().Before(exp)
+}
+
+func (q *MemoryQueue) SubscribeCancels(ctx context.Context) (<-chan string, func(), error) {
+	ch := make(chan string, subscriberBuffer)
+	q.mu.Lock()
+	q.cancelSubs[ch] = struct{}{}
+	q.mu.Unlock()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			delete(q.cancelSubs, ch)
+			close(ch)
+		})
+	}
+	return ch, stop, nil
+}
+
+func (q *MemoryQueue) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	items := make([]DeadLetter, 0, len(q.dead))
+	for _, dl := range q.dead {
+		items = append(items, dl)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].FailedAt.After(items[j].FailedAt) })
+	return items, nil
+}
+
+func (q *MemoryQueue) GetDeadLetter(ctx context.Context, taskID string) (*DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dl, ok := q.dead[taskID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &dl, nil
+}
+
+func (q *MemoryQueue) ReplayDeadLetter(ctx context.Context, taskID string) error {
+	dl, err := q.GetDeadLetter(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	dl.Payload.Replayed = true
+	if err := q.Enqueue(ctx, dl.ID, dl.Payload); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.dead, taskID)
+	return nil
+}
+
+func (q *MemoryQueue) PurgeDeadLetters(ctx context.Context, taskIDs ...string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(taskIDs) == 0 {
+		n := int64(len(q.dead))
+		q.dead = make(map[string]DeadLetter)
+		return n, nil
+	}
+	var n int64
+	for _, id := range taskIDs {
+		if _, ok := q.dead[id]; ok {
+			delete(q.dead, id)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,24 @@
+package queue
+
+import "fmt"
+
+// TaskOwnerKeyPrefix 保存任务提交者的身份（见 Identity），与任务状态同时过期
+const TaskOwnerKeyPrefix = "task:owner"
+
+func ownerKey(taskID string) string {
+	return fmt.Sprintf("%s:%s", TaskOwnerKeyPrefix, taskID)
+}
+
+// AnonymousIdentity 是未登录且没有设备标识的提交者，由互不相关的人共用，
+// 只用于公平调度分组，不能证明任务归属
+const AnonymousIdentity = "device:anonymous"
+
+// OwnedBy 返回 identity 是否为任务的提交者。没有登记提交者的任务、匿名身份提交的任务不属于任何人。
+func (r *TaskResult) OwnedBy(identity string) bool {
+	return r.Owner != "" && r.Owner != AnonymousIdentity && r.Owner == identity
+}
+
+// Redacted 返回只保留状态与时间的副本，供非提交者查看
+func (r *TaskResult) Redacted() *TaskResult {
+	return &TaskResult{Status: r.Status, UpdatedAt: r.UpdatedAt}
+}
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// 所有排队中的任务：member 为任务 ID，score 为排队顺序（重试、回收的任务排在最前）
+	TaskPendingKey = TaskQueueKeyPrefix + ":pending"
+	// 排队顺序号
+	TaskSeqKey = TaskQueueKeyPrefix + ":seq"
+	// 最近完成（成功或失败）的执行：score 为完成时间（毫秒时间戳），用于统计吞吐量
+	TaskCompletionsKey = TaskQueueKeyPrefix + ":completions"
+
+	// throughputWindow 统计吞吐量的时间窗口
+	throughputWindow = 5 * time.Minute
+)
+
+// QueuePosition 是排队中任务的位置与预计等待时间。
+// 调度在身份与通道之间轮转，这里按全局入队顺序估算，仅供展示。
+type QueuePosition struct {
+	Position   int `json:"position"`    // 从 1 开始
+	Ahead      int `json:"ahead"`       // 前方等待的任务数
+	ETASeconds int `json:"eta_seconds"` // 预计多少秒后开始处理
+}
+
+// SetMaxRate 设置全局 LLM 限流速率（次/秒）。每个任务至少调用一次 LLM，
+// 因此它是吞吐量的上限，也是尚无完成记录时的估算依据。
+func (q *RedisQueue) SetMaxRate(rate float64) {
+	q.maxRate = rate
+}
+
+// Throughput 返回最近一个统计窗口内每秒完成的任务数
+func (q *RedisQueue) Throughput(ctx context.Context) (float64, error) {
+	now := time.Now()
+	start := now.Add(-throughputWindow)
+	n, err := q.rdb.ZCount(ctx, TaskCompletionsKey, strconv.FormatInt(start.UnixMilli(), 10), "+inf").Result()
+	if err != nil {
+		return 0, err
+	}
+	return float64(n) / throughputWindow.Seconds(), nil
+}
+
+// Position 返回任务在队列中的位置；任务不在排队中（处理中、等待重试或已结束）时返回 nil
+func (q *RedisQueue) Position(ctx context.Context, taskID string) (*QueuePosition, error) {
+	ahead, err := q.rdb.ZRank(ctx, TaskPendingKey, taskID).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rate, err := q.Throughput(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return estimatePosition(int(ahead), rate, q.maxRate), nil
+}
+
+// estimatePosition 按实测吞吐量估算等待时间，maxRate 为吞吐量上限与无实测数据时的估算依据
+func estimatePosition(ahead int, rate, maxRate float64) *QueuePosition {
+	if maxRate > 0 && (rate <= 0 || rate > maxRate) {
+		rate = maxRate
+	}
+	pos := &QueuePosition{Position: ahead + 1, Ahead: ahead}
+	if rate > 0 {
+		pos.ETASeconds = int(math.Ceil(float64(pos.Position) / rate))
+	}
+	return pos
+}
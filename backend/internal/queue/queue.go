@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -13,6 +15,17 @@ const (
 	TaskQueueKeyPrefix = "task_queue"
 	TaskStatusKeyPrefix = "task:status"
 	TaskExpiration      = 10 * time.Minute // 任务结果在 Redis 保留 10 分钟
+
+	// 已被 Worker 取走、尚未 Ack 的任务
+	TaskProcessingKey = TaskQueueKeyPrefix + ":processing"
+	// 租约：member 为原始消息，score 为租约到期时间（毫秒时间戳）
+	TaskLeaseKey = TaskQueueKeyPrefix + ":leases"
+
+	// VisibilityTimeout 租约时长；Worker 处理期间会定期续约，进程崩溃后租约到期即被回收
+	VisibilityTimeout = 2 * time.Minute
+	// dequeueBlockTimeout 让 Dequeue 定期返回，以便感知 ctx 取消
+	dequeueBlockTimeout = 5 * time.Second
+	reapBatchSize       = 100
 )
 
 type TaskType string
@@ -20,6 +33,16 @@ type TaskType string
 const (
 	TypeQuestion TaskType = "question"
 	TypeLove     TaskType = "love"
+
+	// 定时任务，见 internal/scheduler
+	TypeDailyPoem          TaskType = "daily_poem"
+	TypePurgeGuestData     TaskType = "purge_guest_data"
+	TypeBackfillEmbeddings TaskType = "backfill_embeddings"
+	// 重新解析旧版本的占卜解读，见 services.BackfillInterpretations
+	TypeBackfillInterpretations TaskType = "backfill_interpretations"
+
+	// Webhook 投递，见 internal/webhook
+	TypeWebhook TaskType = "webhook"
 )
 
 type TaskStatus string
@@ -29,6 +52,7 @@ const (
 	StatusProcessing TaskStatus = "processing"
 	StatusCompleted  TaskStatus = "completed"
 	StatusFailed     TaskStatus = "failed"
+	StatusCancelled  TaskStatus = "cancelled"
 )
 
 type TaskPayload struct {
@@ -36,87 +60,317 @@ type TaskPayload struct {
 	Data       json.RawMessage `json:"data"` // 具体的请求数据
 	UserID     *uint           `json:"user_id,omitempty"`
 	DeviceHash string          `json:"device_hash"`
+	Locale     string          `json:"locale,omitempty"` // 输出语言，见 internal/i18n
+	Lane       Lane            `json:"lane,omitempty"`   // 优先级通道，为空时按是否登录推断
 	CreatedAt  time.Time       `json:"created_at"`
+	// Replayed 标记由管理员从死信重放的任务。原预占在进入死信时已退还，重放不再计入用户额度
+	Replayed bool `json:"replayed,omitempty"`
 }
 
 type TaskResult struct {
 	Status    TaskStatus  `json:"status"`
 	Result    interface{} `json:"result,omitempty"`
 	Error     string      `json:"error,omitempty"`
+	Attempt   int         `json:"attempt,omitempty"` // 已失败的次数（重试中或已进入死信）
 	UpdatedAt time.Time   `json:"updated_at"`
+
+	*QueuePosition // 仅排队中的任务由 GetStatus 填充，不持久化
+
+	Owner string `json:"-"` // 提交者身份，由 GetStatus 从 TaskOwnerKeyPrefix 读取
+}
+
+// envelope 是队列中存放的消息
+type envelope struct {
+	ID       string      `json:"id"`
+	Payload  TaskPayload `json:"payload"`
+	Attempt  int         `json:"attempt,omitempty"` // 此前已失败的次数
+	Lane     Lane        `json:"lane"`
+	Identity string      `json:"identity"`
+}
+
+func newEnvelope(taskID string, payload TaskPayload, attempt int) envelope {
+	return envelope{ID: taskID, Payload: payload, Attempt: attempt, Lane: laneFor(payload), Identity: Identity(payload)}
+}
+
+// Delivery 是一次出队得到的任务；处理结束后必须调用 Queue.Ack
+type Delivery struct {
+	ID      string
+	Payload *TaskPayload
+	Attempt int    // 此前已失败的次数，首次执行为 0
+	raw     string // 队列中的原始消息，用于 Ack 与续约
 }
 
-type Queue struct {
-	rdb *redis.Client
+// RedisQueue 是基于 Redis 的 Queue 实现，可由多个实例共享
+type RedisQueue struct {
+	rdb     *redis.Client
+	tick    atomic.Uint64 // 出队计数，用于加权轮转选择通道
+	maxRate float64       // 全局 LLM 限流速率（次/秒），用于估算等待时间
 }
 
-func NewQueue(rdb *redis.Client) *Queue {
-	return &Queue{rdb: rdb}
+
+// enqueueScript KEYS: -；ARGV: prefix, message
+var enqueueScript = redis.NewScript(luaLib + `
+push_task(ARGV[1], ARGV[2], false)
+return 1
+`)
+
+// dequeueScript 按通道顺序轮转各身份，跳过已达并发上限的身份，取出一个任务移入 processing 并登记租约。
+// KEYS: processing, leases, active；ARGV: prefix, lease deadline(ms), max active per identity, lane order
+var dequeueScript = redis.NewScript(luaLib + `
+local prefix, maxActive = ARGV[1], tonumber(ARGV[3])
+for lane in string.gmatch(ARGV[4], '[^,]+') do
+	local ring = prefix .. ':lane:' .. lane .. ':ring'
+	local members = prefix .. ':lane:' .. lane .. ':members'
+	for _ = 1, redis.call('LLEN', ring) do
+		local ident = redis.call('LMOVE', ring, ring, 'LEFT', 'RIGHT')
+		local list = prefix .. ':tasks:' .. ident
+		local msg = false
+		if tonumber(redis.call('HGET', KEYS[3], ident) or '0') < maxActive then
+			msg = redis.call('RPOP', list)
+		end
+		if redis.call('LLEN', list) == 0 then
+			redis.call('LREM', ring, 1, ident)
+			redis.call('SREM', members, ident)
+		end
+		if msg then
+			pending_remove(prefix, msg)
+			redis.call('LPUSH', KEYS[1], msg)
+			redis.call('ZADD', KEYS[2], ARGV[2], msg)
+			redis.call('HINCRBY', KEYS[3], ident, 1)
+			return msg
+		end
+	end
+end
+return false
+`)
+
+// ackScript 仅当消息仍在 processing 中时归还并发名额，保证每次出队只释放一次；
+// 未放回队列的任务计入吞吐量统计。
+// KEYS: processing, leases, completions；ARGV: prefix, message, requeue(1/0), now(ms), window start(ms)
+var ackScript = redis.NewScript(luaLib + `
+redis.call('ZREM', KEYS[2], ARGV[2])
+if redis.call('LREM', KEYS[1], 1, ARGV[2]) > 0 then
+	release(ARGV[1], ARGV[2])
+	if ARGV[3] == '1' then
+		push_task(ARGV[1], ARGV[2], true)
+	else
+		local _, _, id = route(ARGV[2])
+		redis.call('ZADD', KEYS[3], ARGV[4], tostring(id) .. ':' .. ARGV[4])
+		redis.call('ZREMRANGEBYSCORE', KEYS[3], '-inf', ARGV[5])
+	end
+	return 1
+end
+return 0
+`)
+
+// reapScript 将租约过期的任务从 processing 放回所属身份队列的最前面，
+// 并为没有租约的 processing 消息补发租约（Worker 在出队与登记租约之间崩溃的情况）。
+// KEYS: leases, processing；ARGV: prefix, now(ms), batch, new lease deadline(ms)
+var reapScript = redis.NewScript(luaLib + `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, tonumber(ARGV[3]))
+local requeued = 0
+for _, msg in ipairs(expired) do
+	redis.call('ZREM', KEYS[1], msg)
+	if redis.call('LREM', KEYS[2], 1, msg) > 0 then
+		release(ARGV[1], msg)
+		push_task(ARGV[1], msg, true)
+		requeued = requeued + 1
+	end
+end
+for _, msg in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+	redis.call('ZADD', KEYS[1], 'NX', ARGV[4], msg)
+end
+return requeued
+`)
+
+func NewRedisQueue(rdb *redis.Client) *RedisQueue {
+	return &RedisQueue{rdb: rdb}
 }
 
 // Enqueue 将任务推入队列
-func (q *Queue) Enqueue(ctx context.Context, taskID string, payload TaskPayload) error {
-	// 1. 保存初始状态
+func (q *RedisQueue) Enqueue(ctx context.Context, taskID string, payload TaskPayload) error {
+	// 1. 保存提交者与初始状态
 	initialState := TaskResult{
 		Status:    StatusPending,
 		UpdatedAt: time.Now(),
 	}
-	stateBytes, _ := json.Marshal(initialState)
-	if err := q.rdb.Set(ctx, fmt.Sprintf("%s:%s", TaskStatusKeyPrefix, taskID), stateBytes, TaskExpiration).Err(); err != nil {
+	pipe := q.rdb.TxPipeline()
+	pipe.Set(ctx, ownerKey(taskID), Identity(payload), TaskExpiration)
+	q.writeStatus(ctx, pipe, taskID, initialState)
+	if _, err := pipe.Exec(ctx); err != nil {
 		return err
 	}
 
-	// 2. 推入队列
-	// 将 payload 和 taskID 一起打包，或者只存 ID？
-	// 为了简单，我们存一个包含 ID 和 Payload 的结构
-	msg := map[string]interface{}{
-		"id":      taskID,
-		"payload": payload,
-	}
-	msgBytes, _ := json.Marshal(msg)
-	
-	return q.rdb.LPush(ctx, TaskQueueKeyPrefix, msgBytes).Err()
+	// 2. 推入所属身份的队列（ID 与 Payload 打包在同一条消息中）
+	return q.push(ctx, newEnvelope(taskID, payload, 0))
 }
 
-// Dequeue 阻塞等待任务
-func (q *Queue) Dequeue(ctx context.Context) (string, *TaskPayload, error) {
-	// BRPOP 阻塞读取
-	result, err := q.rdb.BRPop(ctx, 0, TaskQueueKeyPrefix).Result()
+func (q *RedisQueue) push(ctx context.Context, env envelope) error {
+	msgBytes, err := json.Marshal(env)
 	if err != nil {
-		return "", nil, err
+		return err
 	}
+	return enqueueScript.Run(ctx, q.rdb, nil, TaskQueueKeyPrefix, msgBytes).Err()
+}
 
-	// result[0] 是 key, result[1] 是 value
-	var msg struct {
-		ID      string      `json:"id"`
-		Payload TaskPayload `json:"payload"`
+// Dequeue 等待并取出一个任务：通道间加权轮转，同一通道内各身份轮流出队，
+// 已有 maxActive 个任务在执行的身份会被跳过。任务被原子地移入 processing 列表并登记租约，
+// 直到 Ack 之前都不会丢失；超时无任务时返回 ErrNoTask。
+func (q *RedisQueue) Dequeue(ctx context.Context, maxActive int) (*Delivery, error) {
+	for waited := false; ; waited = true {
+		raw, err := dequeueScript.Run(ctx, q.rdb,
+			[]string{TaskProcessingKey, TaskLeaseKey, TaskActiveKey},
+			TaskQueueKeyPrefix, time.Now().Add(VisibilityTimeout).UnixMilli(), maxActive, laneOrder(q.tick.Add(1)),
+		).Text()
+		if err == nil {
+			return q.decode(ctx, raw)
+		}
+		if err != redis.Nil {
+			return nil, err
+		}
+		if waited {
+			return nil, ErrNoTask
+		}
+		// 没有可执行的任务：等待新任务或名额释放的信号
+		if err := q.rdb.BLPop(ctx, dequeueBlockTimeout, TaskSignalKey).Err(); err == redis.Nil {
+			return nil, ErrNoTask
+		} else if err != nil {
+			return nil, err
+		}
 	}
-	if err := json.Unmarshal([]byte(result[1]), &msg); err != nil {
-		return "", nil, err
+}
+
+func (q *RedisQueue) decode(ctx context.Context, raw string) (*Delivery, error) {
+	d := &Delivery{raw: raw}
+	var msg envelope
+	if err := json.Unmarshal([]byte(raw), &msg); err != nil {
+		// 无法解析的消息永远无法处理，直接确认丢弃，避免被反复回收
+		q.Ack(ctx, d)
+		return nil, err
 	}
+	d.ID = msg.ID
+	d.Payload = &msg.Payload
+	d.Attempt = msg.Attempt
+	return d, nil
+}
+
+// Ack 确认任务已处理完毕（应在写入最终状态之后调用），将其移出 processing 列表并归还并发名额
+func (q *RedisQueue) Ack(ctx context.Context, d *Delivery) error {
+	return q.ack(ctx, d, false)
+}
+
+// Requeue 将未完成的任务原样放回队首（不计入失败次数），用于停机时中断的任务。
+// 仅当消息仍在 processing 列表中时才放回，避免与 reaper 重复投递。
+func (q *RedisQueue) Requeue(ctx context.Context, d *Delivery) error {
+	if err := q.ack(ctx, d, true); err != nil {
+		return err
+	}
+	return q.UpdateStatus(ctx, d.ID, StatusPending, nil, "")
+}
+
+func (q *RedisQueue) ack(ctx context.Context, d *Delivery, requeue bool) error {
+	now := time.Now()
+	flag := 0
+	if requeue {
+		flag = 1
+	}
+	return ackScript.Run(ctx, q.rdb,
+		[]string{TaskProcessingKey, TaskLeaseKey, TaskCompletionsKey},
+		TaskQueueKeyPrefix, d.raw, flag, now.UnixMilli(), now.Add(-throughputWindow).UnixMilli(),
+	).Err()
+}
 
-	return msg.ID, &msg.Payload, nil
+// Extend 将任务租约延长一个 VisibilityTimeout，处理耗时较长的任务时定期调用
+func (q *RedisQueue) Extend(ctx context.Context, d *Delivery) error {
+	deadline := time.Now().Add(VisibilityTimeout).UnixMilli()
+	return q.rdb.ZAdd(ctx, TaskLeaseKey, redis.Z{Score: float64(deadline), Member: d.raw}).Err()
+}
+
+// Reap 将租约过期的任务放回队列，返回回收数量。多个实例并发执行是安全的。
+func (q *RedisQueue) Reap(ctx context.Context) (int, error) {
+	now := time.Now()
+	n, err := reapScript.Run(ctx, q.rdb,
+		[]string{TaskLeaseKey, TaskProcessingKey},
+		TaskQueueKeyPrefix, now.UnixMilli(), reapBatchSize, now.Add(VisibilityTimeout).UnixMilli(),
+	).Int()
+	return n, err
+}
+
+// migrateLegacy 将旧版单一列表 task_queue 中残留的任务迁入按身份划分的队列
+func (q *RedisQueue) migrateLegacy(ctx context.Context) {
+	for {
+		raw, err := q.rdb.RPop(ctx, TaskQueueKeyPrefix).Result()
+		if err != nil {
+			if err != redis.Nil {
+				log.Printf("[Queue] migrate legacy queue: %v", err)
+			}
+			return
+		}
+		var env envelope
+		if err := json.Unmarshal([]byte(raw), &env); err != nil {
+			log.Printf("[Queue] drop malformed legacy task: %v", err)
+			continue
+		}
+		if err := q.push(ctx, newEnvelope(env.ID, env.Payload, env.Attempt)); err != nil {
+			// 放回原处，下次启动再迁移
+			q.rdb.RPush(ctx, TaskQueueKeyPrefix, raw)
+			log.Printf("[Queue] migrate legacy task %s: %v", env.ID, err)
+			return
+		}
+	}
+}
+
+// RunReaper 周期性回收过期租约，直到 ctx 结束
+func (q *RedisQueue) RunReaper(ctx context.Context, interval time.Duration) {
+	q.migrateLegacy(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := q.Reap(ctx)
+			if err != nil {
+				log.Printf("[Queue] reaper error: %v", err)
+			} else if n > 0 {
+				log.Printf("[Queue] reaper requeued %d task(s) with expired lease", n)
+			}
+		}
+	}
 }
 
 // UpdateStatus 更新任务状态
-func (q *Queue) UpdateStatus(ctx context.Context, taskID string, status TaskStatus, result interface{}, errStr string) error {
+func (q *RedisQueue) UpdateStatus(ctx context.Context, taskID string, status TaskStatus, result interface{}, errStr string) error {
 	state := TaskResult{
 		Status:    status,
 		Result:    result,
 		Error:     errStr,
 		UpdatedAt: time.Now(),
 	}
-	bytes, _ := json.Marshal(state)
-	return q.rdb.Set(ctx, fmt.Sprintf("%s:%s", TaskStatusKeyPrefix, taskID), bytes, TaskExpiration).Err()
+	return q.writeStatus(ctx, q.rdb, taskID, state)
 }
 
-// GetStatus 获取任务状态
-func (q *Queue) GetStatus(ctx context.Context, taskID string) (*TaskResult, error) {
-	val, err := q.rdb.Get(ctx, fmt.Sprintf("%s:%s", TaskStatusKeyPrefix, taskID)).Result()
+// GetStatus 获取任务状态及其提交者
+func (q *RedisQueue) GetStatus(ctx context.Context, taskID string) (*TaskResult, error) {
+	vals, err := q.rdb.MGet(ctx, fmt.Sprintf("%s:%s", TaskStatusKeyPrefix, taskID), ownerKey(taskID)).Result()
 	if err != nil {
 		return nil, err
 	}
+	val, ok := vals[0].(string)
+	if !ok {
+		return nil, ErrNotFound
+	}
 	var res TaskResult
 	json.Unmarshal([]byte(val), &res)
+	res.Owner, _ = vals[1].(string)
+	res.QueuePosition = nil
+	if res.Status == StatusPending {
+		pos, err := q.Position(ctx, taskID)
+		if err != nil {
+			log.Printf("[Queue] position of task %s: %v", taskID, err)
+		}
+		res.QueuePosition = pos
+	}
 	return &res, nil
 }
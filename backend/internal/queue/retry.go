@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// 等待重试的任务：member 为消息，score 为可以重新入队的时间（毫秒时间戳）
+	TaskDelayedKey = TaskQueueKeyPrefix + ":delayed"
+	// 重试耗尽的任务（死信），按任务 ID 存储
+	TaskDeadKey = TaskQueueKeyPrefix + ":dead"
+
+	promoteBatchSize = 100
+)
+
+// RetryPolicy 描述某类任务失败后的重试方式
+type RetryPolicy struct {
+	MaxAttempts int           // 包含首次执行在内的最大尝试次数
+	BaseDelay   time.Duration // 第一次重试前的等待时间，之后按指数增长
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy 用于未单独配置的任务类型
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: 2 * time.Second, MaxDelay: time.Minute}
+
+// Backoff 返回第 attempt 次失败后的等待时间（指数退避，±20% 抖动）
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/5*2+1)) - delay/5
+	return delay + jitter
+}
+
+// permanentError 标记无需重试的错误（参数错误、超出限额等）
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent 包装一个不可重试的错误，任务会直接进入死信
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// DeadLetter 是重试耗尽后保留下来的任务，供管理员排查与重放
+type DeadLetter struct {
+	ID       string      `json:"id"`
+	Payload  TaskPayload `json:"payload"`
+	Attempts int         `json:"attempts"`
+	Error    string      `json:"error"`
+	FailedAt time.Time   `json:"failed_at"`
+}
+
+// Fail 处理一次失败的执行：仍可重试时放入延迟队列并返回 true，
+// 否则写入死信并标记任务失败。先写入重试/死信与状态，再 Ack，中途崩溃也不会丢任务。
+func (q *RedisQueue) Fail(ctx context.Context, d *Delivery, policy RetryPolicy, cause error) (bool, error) {
+	attempt := d.Attempt + 1
+	now := time.Now()
+	pipe := q.rdb.TxPipeline()
+
+	retry := !IsPermanent(cause) && attempt < policy.MaxAttempts
+	if retry {
+		msg, err := json.Marshal(newEnvelope(d.ID, *d.Payload, attempt))
+		if err != nil {
+			return false, err
+		}
+		due := now.Add(policy.Backoff(attempt))
+		pipe.ZAdd(ctx, TaskDelayedKey, redis.Z{Score: float64(due.UnixMilli()), Member: msg})
+		// 前端继续轮询 pending 状态，error 字段说明正在重试的原因
+		q.setStatus(ctx, pipe, d.ID, TaskResult{Status: StatusPending, Error: cause.Error(), Attempt: attempt, UpdatedAt: now})
+	} else {
+		dl, err := json.Marshal(DeadLetter{ID: d.ID, Payload: *d.Payload, Attempts: attempt, Error: cause.Error(), FailedAt: now})
+		if err != nil {
+			return false, err
+		}
+		pipe.HSet(ctx, TaskDeadKey, d.ID, dl)
+		q.setStatus(ctx, pipe, d.ID, TaskResult{Status: StatusFailed, Error: cause.Error(), Attempt: attempt, UpdatedAt: now})
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return retry, err
+	}
+	return retry, q.Ack(ctx, d)
+}
+
+// promoteScript 将到期的延迟任务放回所属身份队列的最前面
+// KEYS: delayed；ARGV: prefix, now(ms), batch
+var promoteScript = redis.NewScript(luaLib + `
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[2], 'LIMIT', 0, tonumber(ARGV[3]))
+for _, msg in ipairs(due) do
+	redis.call('ZREM', KEYS[1], msg)
+	push_task(ARGV[1], msg, true)
+end
+return #due
+`)
+
+// PromoteDue 将到期的重试任务重新入队，返回数量。多个实例并发执行是安全的。
+func (q *RedisQueue) PromoteDue(ctx context.Context) (int, error) {
+	return promoteScript.Run(ctx, q.rdb,
+		[]string{TaskDelayedKey},
+		TaskQueueKeyPrefix, time.Now().UnixMilli(), promoteBatchSize,
+	).Int()
+}
+
+// RunScheduler 周期性地将到期的重试任务重新入队，直到 ctx 结束
+func (q *RedisQueue) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := q.PromoteDue(ctx); err != nil {
+				log.Printf("[Queue] promote delayed tasks error: %v", err)
+			}
+		}
+	}
+}
+
+// ListDeadLetters 按失败时间倒序返回死信
+func (q *RedisQueue) ListDeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	vals, err := q.rdb.HGetAll(ctx, TaskDeadKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]DeadLetter, 0, len(vals))
+	for id, v := range vals {
+		var dl DeadLetter
+		if err := json.Unmarshal([]byte(v), &dl); err != nil {
+			log.Printf("[Queue] skip malformed dead letter %s: %v", id, err)
+			continue
+		}
+		items = append(items, dl)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].FailedAt.After(items[j].FailedAt) })
+	return items, nil
+}
+
+// GetDeadLetter 返回单条死信，不存在时返回 ErrNotFound
+func (q *RedisQueue) GetDeadLetter(ctx context.Context, taskID string) (*DeadLetter, error) {
+	v, err := q.rdb.HGet(ctx, TaskDeadKey, taskID).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dl DeadLetter
+	if err := json.Unmarshal([]byte(v), &dl); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// ReplayDeadLetter 以全新的尝试次数将死信重新入队，任务 ID 保持不变，负载标记为 Replayed
+func (q *RedisQueue) ReplayDeadLetter(ctx context.Context, taskID string) error {
+	dl, err := q.GetDeadLetter(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	dl.Payload.Replayed = true
+	if err := q.Enqueue(ctx, dl.ID, dl.Payload); err != nil {
+		return err
+	}
+	return q.rdb.HDel(ctx, TaskDeadKey, taskID).Err()
+}
+
+// PurgeDeadLetters 删除指定的死信；不传 ID 时清空全部，返回删除数量
+func (q *RedisQueue) PurgeDeadLetters(ctx context.Context, taskIDs ...string) (int64, error) {
+	if len(taskIDs) == 0 {
+		n, err := q.rdb.HLen(ctx, TaskDeadKey).Result()
+		if err != nil {
+			return 0, err
+		}
+		return n, q.rdb.Del(ctx, TaskDeadKey).Err()
+	}
+	return q.rdb.HDel(ctx, TaskDeadKey, taskIDs...).Result()
+}
+
+func (q *RedisQueue) setStatus(ctx context.Context, pipe redis.Pipeliner, taskID string, state TaskResult) {
+	q.writeStatus(ctx, pipe, taskID, state)
+}
@@ -0,0 +1,232 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"fromheart/internal/db"
+	"fromheart/internal/services"
+
+	"gorm.io/gorm"
+)
+
+// Ledger statuses
+const (
+	StatusReserved  = "reserved"
+	StatusCommitted = "committed"
+	StatusRefunded  = "refunded"
+)
+
+// staleAfter is how long an unsettled reservation keeps counting. A task that
+// never reports back (a lost worker, an expired task) stops holding quota then.
+const staleAfter = time.Hour
+
+var (
+	// ErrExceeded is matched by the *LimitError Reserve returns when a limit is reached.
+	ErrExceeded = errors.New("quota exceeded")
+	// ErrInvalidPlan means a plan cannot be assigned to a user.
+	ErrInvalidPlan = errors.New("invalid plan")
+)
+
+// LimitError reports which daily limit was reached.
+type LimitError struct {
+	Plan    Plan
+	Feature Feature
+	Limit   int
+	Used    int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s quota of plan %s exceeded: %d/%d used today", e.Feature, e.Plan, e.Used, e.Limit)
+}
+
+func (e *LimitError) Is(target error) bool { return target == ErrExceeded }
+
+// Caller identifies who makes a request.
+type Caller struct {
+	UserID     *uint
+	DeviceHash string
+	IP         string
+	// Secret is the admin secret presented with the request, if any
+	Secret string
+}
+
+// Subject is a resolved caller: the key its uses are counted under and its plan.
+type Subject struct {
+	Key  string
+	Plan Plan
+}
+
+// Usage is a subject's use of one feature today.
+type Usage struct {
+	Feature   Feature `json:"feature"`
+	Limit     int     `json:"limit"` // Unlimited (-1) when not metered
+	Used      int     `json:"used"`
+	Remaining int     `json:"remaining"` // -1 when unlimited
+}
+
+// Engine checks and records feature use in the quota ledger. All limit checks
+// go through it.
+type Engine struct {
+	postgres    *gorm.DB
+	limits      PlanLimits
+	adminSecret string
+	now         func() time.Time
+}
+
+func NewEngine(postgres *gorm.DB, limits PlanLimits, adminSecret string) *Engine {
+	return &Engine{postgres: postgres, limits: limits, adminSecret: adminSecret, now: time.Now}
+}
+
+// Limits returns the configured plans.
+func (e *Engine) Limits() PlanLimits {
+	return e.limits
+}
+
+// IsAdmin reports whether secret is the configured admin secret.
+func (e *Engine) IsAdmin(secret string) bool {
+	return e.adminSecret != "" && secret == e.adminSecret
+}
+
+// Resolve determines the caller's plan and counting key. Logged-in users are
+// counted per account, guests per device, or per IP when the device is unknown.
+func (e *Engine) Resolve(ctx context.Context, c Caller) (Subject, error) {
+	var key string
+	switch {
+	case c.UserID != nil:
+		key = fmt.Sprintf("user:%d", *c.UserID)
+	case c.DeviceHash != "" && c.DeviceHash != services.AnonymousDevice:
+		key = "device:" + c.DeviceHash
+	default:
+		key = "ip:" + c.IP
+	}
+
+	switch {
+	case e.IsAdmin(c.Secret):
+		return Subject{Key: key, Plan: PlanAdmin}, nil
+	case c.UserID == nil:
+		return Subject{Key: key, Plan: PlanGuest}, nil
+	}
+	var user db.User
+	if err := e.postgres.WithContext(ctx).Select("id", "plan").First(&user, *c.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Subject{Key: key, Plan: PlanGuest}, nil
+		}
+		return Subject{}, err
+	}
+	plan := Plan(user.Plan)
+	if !ValidPlan(user.Plan) || plan == PlanGuest {
+		plan = PlanRegistered
+	}
+	return Subject{Key: key, Plan: plan}, nil
+}
+
+// Reserve records a use of feature by sub under ref (a task or request id),
+// failing with a *LimitError when the daily limit is reached. Reserving the
+// same ref again is a no-op, so a retried submission is not charged twice.
+// Follow with Commit when the work succeeds and Refund when it does not.
+func (e *Engine) Reserve(ctx context.Context, sub Subject, feature Feature, ref string) error {
+	limit := e.limits.Limit(sub.Plan, feature)
+	now := e.now()
+	day := today(now)
+	return e.postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Serialize reservations of one subject so concurrent requests cannot both take the last use
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "quota:"+sub.Key).Error; err != nil {
+			return err
+		}
+		var existing int64
+		if err := tx.Model(&db.QuotaLedger{}).Where("ref = ?", ref).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return nil
+		}
+		if limit != Unlimited {
+			used, err := countUsed(tx, sub.Key, feature, day, now)
+			if err != nil {
+				return err
+			}
+			if used >= limit {
+				return &LimitError{Plan: sub.Plan, Feature: feature, Limit: limit, Used: used}
+			}
+		}
+		return tx.Create(&db.QuotaLedger{
+			Ref:     ref,
+			Subject: sub.Key,
+			Feature: string(feature),
+			Day:     day,
+			Plan:    string(sub.Plan),
+			Status:  StatusReserved,
+		}).Error
+	})
+}
+
+// Commit charges the reservation made under ref. Refunded or unknown refs are left alone.
+func (e *Engine) Commit(ctx context.Context, ref string) error {
+	return e.settle(ctx, ref, StatusCommitted)
+}
+
+// Refund gives the reservation made under ref back. Committed or unknown refs are left alone.
+func (e *Engine) Refund(ctx context.Context, ref string) error {
+	return e.settle(ctx, ref, StatusRefunded)
+}
+
+func (e *Engine) settle(ctx context.Context, ref, status string) error {
+	return e.postgres.WithContext(ctx).Model(&db.QuotaLedger{}).
+		Where("ref = ? AND status = ?", ref, StatusReserved).
+		Update("status", status).Error
+}
+
+// Usage returns sub's use of every feature today.
+func (e *Engine) Usage(ctx context.Context, sub Subject) ([]Usage, error) {
+	now := e.now()
+	day := today(now)
+	items := make([]Usage, 0, len(Features))
+	for _, feature := range Features {
+		used, err := countUsed(e.postgres.WithContext(ctx), sub.Key, feature, day, now)
+		if err != nil {
+			return nil, err
+		}
+		u := Usage{Feature: feature, Limit: e.limits.Limit(sub.Plan, feature), Used: used, Remaining: Unlimited}
+		if u.Limit != Unlimited {
+			u.Remaining = max(u.Limit-used, 0)
+		}
+		items = append(items, u)
+	}
+	return items, nil
+}
+
+// SetPlan assigns plan to a user; an empty plan resets it to registered. Guest
+// and admin are not assignable: they follow from the request.
+func (e *Engine) SetPlan(ctx context.Context, userID uint, plan string) error {
+	plan = strings.TrimSpace(plan)
+	if plan != "" && (!ValidPlan(plan) || plan == string(PlanGuest) || plan == string(PlanAdmin)) {
+		return fmt.Errorf("%w %q", ErrInvalidPlan, plan)
+	}
+	res := e.postgres.WithContext(ctx).Model(&db.User{}).Where("id = ?", userID).Update("plan", plan)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// countUsed counts committed uses plus reservations still in flight.
+func countUsed(tx *gorm.DB, key string, feature Feature, day, now time.Time) (int, error) {
+	var n int64
+	err := tx.Model(&db.QuotaLedger{}).
+		Where("subject = ? AND feature = ? AND day = ?", key, string(feature), day).
+		Where("status = ? OR (status = ? AND created_at > ?)", StatusCommitted, StatusReserved, now.Add(-staleAfter)).
+		Count(&n).Error
+	return int(n), err
+}
+
+// today is the quota day: limits reset at midnight UTC, like question_date.
+func today(now time.Time) time.Time {
+	return now.Truncate(24 * time.Hour)
+}
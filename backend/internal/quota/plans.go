@@ -0,0 +1,117 @@
+// Package quota enforces the daily limits of metered features (questions,
+// follow-up chats, love probes) per plan.
+//
+// Every use is a row in the quota ledger: Reserve records it before the work
+// starts, Commit confirms it when the work succeeded and Refund gives it back
+// when the work failed or was cancelled, so only successful uses are charged.
+package quota
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Plan names the set of limits a caller gets.
+type Plan string
+
+const (
+	PlanGuest      Plan = "guest"      // not logged in
+	PlanRegistered Plan = "registered" // logged in, no plan assigned
+	PlanVIP        Plan = "vip"
+	PlanAdmin      Plan = "admin" // request carries the admin secret
+)
+
+// Plans lists the known plans from the most to the least restricted.
+var Plans = []Plan{PlanGuest, PlanRegistered, PlanVIP, PlanAdmin}
+
+// ValidPlan reports whether p names a known plan.
+func ValidPlan(p string) bool {
+	for _, plan := range Plans {
+		if string(plan) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Feature is a metered action.
+type Feature string
+
+const (
+	FeatureQuestion Feature = "question" // a divination
+	FeatureChat     Feature = "chat"     // a follow-up on a divination or love probe
+	FeatureLove     Feature = "love"     // a love probe
+)
+
+// Features lists the metered features.
+var Features = []Feature{FeatureQuestion, FeatureChat, FeatureLove}
+
+// Unlimited as a limit turns metering off for the feature.
+const Unlimited = -1
+
+// Limits maps each feature to its daily limit.
+type Limits map[Feature]int
+
+// PlanLimits maps each plan to its limits.
+type PlanLimits map[Plan]Limits
+
+// DefaultLimits returns the built-in plans. Guests and registered users keep
+// the historic 10 questions and 3 follow-ups a day.
+func DefaultLimits() PlanLimits {
+	return PlanLimits{
+		PlanGuest:      {FeatureQuestion: 10, FeatureChat: 3, FeatureLove: 3},
+		PlanRegistered: {FeatureQuestion: 10, FeatureChat: 3, FeatureLove: 5},
+		PlanVIP:        {FeatureQuestion: 30, FeatureChat: 20, FeatureLove: 20},
+		PlanAdmin:      {FeatureQuestion: Unlimited, FeatureChat: Unlimited, FeatureLove: Unlimited},
+	}
+}
+
+// Limit returns plan's daily limit for feature. Unknown plans get the guest
+// limits and features missing from a plan are not allowed.
+func (p PlanLimits) Limit(plan Plan, feature Feature) int {
+	limits, ok := p[plan]
+	if !ok {
+		limits = p[PlanGuest]
+	}
+	return limits[feature]
+}
+
+// ParseLimits applies overrides given as "plan.feature=n,..." on top of the
+// default plans, e.g. "guest.question=5,vip.chat=-1" (-1 means unlimited).
+func ParseLimits(s string) (PlanLimits, error) {
+	plans := DefaultLimits()
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		planName, featureName, ok2 := strings.Cut(strings.TrimSpace(key), ".")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("quota limit %q: want plan.feature=n", item)
+		}
+		if !ValidPlan(planName) {
+			return nil, fmt.Errorf("quota limit %q: unknown plan %q", item, planName)
+		}
+		feature := Feature(featureName)
+		if !validFeature(feature) {
+			return nil, fmt.Errorf("quota limit %q: unknown feature %q", item, featureName)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < Unlimited {
+			return nil, fmt.Errorf("quota limit %q: invalid limit", item)
+		}
+		plans[Plan(planName)][feature] = n
+	}
+	return plans, nil
+}
+
+func validFeature(f Feature) bool {
+	for _, feature := range Features {
+		if feature == f {
+			return true
+		}
+	}
+	return false
+}
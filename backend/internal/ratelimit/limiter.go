@@ -2,38 +2,402 @@ package ratelimit
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
-// GlobalLimiter 简单的全局限流器，基于 Ticker
+const (
+	// KeyPrefix 限流状态在 Redis 中的键前缀；服务商名作为 hash tag，保证同一服务商的键落在同一个槽
+	KeyPrefix = "ratelimit:llm"
+
+	// redisTimeout 单次 Redis 调用的超时，超时后本次改用本地限流
+	redisTimeout = 500 * time.Millisecond
+	// fallbackPeriod Redis 出错后使用本地限流的时长，之后再尝试 Redis
+	fallbackPeriod = 10 * time.Second
+)
+
+// Limit 是一个限流维度：平均每秒 Rate 次请求，最多连续突发 Burst 次；
+// TPM 为每分钟 token 数（输入加输出），0 表示不限
+type Limit struct {
+	Rate  float64
+	Burst int
+	TPM   int
+}
+
+func (l Limit) String() string {
+	if l.TPM > 0 {
+		return fmt.Sprintf("%g/s burst %d, %d tokens/min", l.Rate, l.Burst, l.TPM)
+	}
+	return fmt.Sprintf("%g/s burst %d", l.Rate, l.Burst)
+}
+
+// Options 配置全局限流器
+type Options struct {
+	Provider string // 服务商，如 "qianfan"
+	Model    string // 默认模型，Reserve 使用
+	// Limit 服务商的总限额，所有模型共享
+	Limit Limit
+	// Models 单个模型的额外限额，未列出的模型只受服务商限额约束
+	Models map[string]Limit
+	// LocalShare Redis 不可用时本实例可使用的限额比例（0~1]，多副本部署时设为 1/副本数，默认 1
+	LocalShare float64
+}
+
+// GlobalLimiter 是所有副本共享的 LLM 限流器：在 Redis 中以 GCRA 算法同时检查服务商与模型两级的
+// 请求数与 token 数限额，时间以 Redis 服务器时钟为准。Redis 不可用时退回进程内的同一算法，限额按 LocalShare 缩减。
+//
+// 调用方先按估算的 token 数预占（Reserve），调用结束后按实际用量校正（Reservation.Commit），
+// 长 prompt 因此按其真实成本排队，而不是与短请求一样只占一次请求配额。
 type GlobalLimiter struct {
-	ticker *time.Ticker
+	rdb  *redis.Client // 为空时只使用本地限流（单进程开发模式）
+	opts Options
+
+	local         *localGCRA
+	fallbackUntil atomic.Int64 // Unix 纳秒，此前使用本地限流
+}
+
+// NewGlobalLimiter 创建限流器，rdb 为空时只在本进程内限流
+func NewGlobalLimiter(rdb *redis.Client, opts Options) *GlobalLimiter {
+	if opts.LocalShare <= 0 || opts.LocalShare > 1 {
+		opts.LocalShare = 1
+	}
+	opts.Limit = normalize(opts.Limit)
+	for model, l := range opts.Models {
+		opts.Models[model] = normalize(l)
+	}
+	log.Printf("[RateLimit] %s limit %v, model limits %v (shared via Redis: %v)", opts.Provider, opts.Limit, opts.Models, rdb != nil)
+	return &GlobalLimiter{rdb: rdb, opts: opts, local: newLocalGCRA()}
+}
+
+func normalize(l Limit) Limit {
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	return l
+}
+
+// Rate 返回默认模型的放行速率上限（次/秒）
+func (l *GlobalLimiter) Rate() float64 {
+	rate := l.opts.Limit.Rate
+	if m, ok := l.opts.Models[l.opts.Model]; ok && m.Rate < rate {
+		rate = m.Rate
+	}
+	return rate
+}
+
+// Reserve 阻塞直到默认模型获得一次调用及 tokens 个 token 的配额；ctx 取消时返回 ctx 的错误
+func (l *GlobalLimiter) Reserve(ctx context.Context, tokens int) (*Reservation, error) {
+	return l.ReserveModel(ctx, l.opts.Model, tokens)
+}
+
+// ReserveModel 阻塞直到 model 获得一次调用及 tokens 个 token 的配额；ctx 取消时返回 ctx 的错误。
+// 超过一分钟限额的 token 数按一分钟限额排队，超出部分在 Commit 时计入。
+func (l *GlobalLimiter) ReserveModel(ctx context.Context, model string, tokens int) (*Reservation, error) {
+	dims := l.dimensions(model, tokens)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		wait, local := l.reserve(ctx, dims)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if wait <= 0 {
+			return &Reservation{l: l, dims: dims, local: local}, nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// dim 是一次调用需要满足的一个限额：每单位间隔 interval，最多突发 burst 个单位，本次占用 qty 个单位
+type dim struct {
+	key      string
+	interval time.Duration
+	burst    int
+	qty      int
+	tokens   bool
+}
+
+// dimensions 返回一次调用需要同时满足的限额：服务商与模型（如有配置）各自的请求数与 token 数
+func (l *GlobalLimiter) dimensions(model string, tokens int) []dim {
+	provider := KeyPrefix + ":{" + l.opts.Provider + "}"
+	dims := limitDims(nil, provider, l.opts.Limit, tokens)
+	if m, ok := l.opts.Models[model]; ok {
+		dims = limitDims(dims, provider+":"+model, m, tokens)
+	}
+	return dims
+}
+
+func limitDims(dims []dim, key string, lim Limit, tokens int) []dim {
+	dims = append(dims, dim{key: key, interval: interval(lim), burst: lim.Burst, qty: 1})
+	if lim.TPM > 0 && tokens > 0 {
+		dims = append(dims, dim{key: key + ":tokens", interval: time.Minute / time.Duration(lim.TPM), burst: lim.TPM, qty: min(tokens, lim.TPM), tokens: true})
+	}
+	return dims
+}
+
+// reserve 尝试取得配额，成功时返回 0，否则返回需要等待的时间；local 表示使用了本地限流
+func (l *GlobalLimiter) reserve(ctx context.Context, dims []dim) (wait time.Duration, local bool) {
+	if l.useRedis() {
+		rctx, cancel := context.WithTimeout(ctx, redisTimeout)
+		wait, err := reserveRedis(rctx, l.rdb, dims)
+		cancel()
+		if err == nil {
+			l.redisOK()
+			return wait, false
+		}
+		if ctx.Err() != nil {
+			return 0, false // 由调用方返回 ctx 的错误
+		}
+		l.redisFailed(err)
+	}
+	return l.local.reserve(time.Now(), l.localDims(dims)), true
 }
 
-// NewGlobalLimiter 创建一个新的限流器
-// qps: 每秒允许的请求数
-func NewGlobalLimiter(qps int) *GlobalLimiter {
-	// 计算间隔。为了安全，稍微增加一点间隔 (e.g. 3QPS -> 340ms)
-	interval := time.Duration(1000/qps + 10) * time.Millisecond
-	if qps <= 0 {
-		interval = time.Hour // Block forever if qps is 0
+func (l *GlobalLimiter) useRedis() bool {
+	return l.rdb != nil && time.Now().UnixNano() >= l.fallbackUntil.Load()
+}
+
+func (l *GlobalLimiter) redisOK() {
+	if l.fallbackUntil.Swap(0) != 0 {
+		log.Printf("[RateLimit] Redis reachable again, back to the shared limit")
+	}
+}
+
+func (l *GlobalLimiter) redisFailed(err error) {
+	if l.fallbackUntil.Swap(time.Now().Add(fallbackPeriod).UnixNano()) == 0 {
+		log.Printf("[RateLimit] Redis unavailable (%v), falling back to the local limit at %.0f%% share", err, l.opts.LocalShare*100)
+	}
+}
+
+// localDims 按 LocalShare 缩减限额；只在本进程限流（没有 Redis）时不缩减
+func (l *GlobalLimiter) localDims(dims []dim) []dim {
+	if l.rdb == nil || l.opts.LocalShare == 1 {
+		return dims
+	}
+	scaled := make([]dim, len(dims))
+	for i, d := range dims {
+		d.interval = time.Duration(float64(d.interval) / l.opts.LocalShare)
+		d.burst = max(1, int(float64(d.burst)*l.opts.LocalShare))
+		d.qty = min(d.qty, d.burst)
+		scaled[i] = d
+	}
+	return scaled
+}
+
+// Reservation 是一次调用预占的配额
+type Reservation struct {
+	l     *GlobalLimiter
+	dims  []dim
+	local bool
+	once  sync.Once
+}
+
+// Commit 按实际 token 用量校正预占：用量少于预占时退还差额，多于预占时补记，之后的调用相应等待。
+// 重复调用无效。
+func (r *Reservation) Commit(actualTokens int) {
+	r.once.Do(func() {
+		var adjust []dim
+		for _, d := range r.dims {
+			if d.tokens && actualTokens != d.qty {
+				d.qty = actualTokens - d.qty
+				adjust = append(adjust, d)
+			}
+		}
+		if len(adjust) == 0 {
+			return
+		}
+		if r.local {
+			r.l.local.adjust(time.Now(), r.l.localDims(adjust))
+			return
+		}
+		// 预占记在 Redis 中，校正失败时放弃：只影响之后一分钟内的估算精度
+		ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+		defer cancel()
+		if err := adjustRedis(ctx, r.l.rdb, adjust); err != nil {
+			r.l.redisFailed(err)
+		}
+	})
+}
+
+// gcraScript 在所有键都允许时一并记账，否则返回最长的等待时间（毫秒）
+// KEYS: 限流键；ARGV: 每个键的 emission interval(ms)、burst 与本次占用的数量
+var gcraScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+local wait = 0
+local tats = {}
+for i, key in ipairs(KEYS) do
+	local interval = tonumber(ARGV[3 * i - 2])
+	local burst = tonumber(ARGV[3 * i - 1])
+	local qty = tonumber(ARGV[3 * i])
+	local tat = tonumber(redis.call('GET', key) or now)
+	if tat < now then
+		tat = now
+	end
+	local allow_at = tat + (qty - burst) * interval
+	if allow_at > now then
+		wait = math.max(wait, allow_at - now)
+	end
+	tats[i] = tat + qty * interval
+end
+if wait > 0 then
+	return math.ceil(wait)
+end
+for i, key in ipairs(KEYS) do
+	redis.call('SET', key, tostring(tats[i]), 'PX', math.ceil(tats[i] - now) + 1000)
+end
+return 0
+`)
+
+// adjustScript 将已记账的数量增加（或退还）delta 个单位
+// KEYS: 限流键；ARGV: 每个键的 emission interval(ms) 与 delta
+var adjustScript = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + tonumber(t[2]) / 1000
+for i, key in ipairs(KEYS) do
+	local interval = tonumber(ARGV[2 * i - 1])
+	local delta = tonumber(ARGV[2 * i])
+	local tat = tonumber(redis.call('GET', key) or now)
+	if tat < now then
+		tat = now
+	end
+	tat = tat + delta * interval
+	if tat <= now then
+		redis.call('DEL', key)
+	else
+		redis.call('SET', key, tostring(tat), 'PX', math.ceil(tat - now) + 1000)
+	end
+end
+return 0
+`)
+
+func reserveRedis(ctx context.Context, rdb *redis.Client, dims []dim) (time.Duration, error) {
+	keys := make([]string, len(dims))
+	args := make([]interface{}, 0, 3*len(dims))
+	for i, d := range dims {
+		keys[i] = d.key
+		args = append(args, millis(d.interval), d.burst, d.qty)
+	}
+	ms, err := gcraScript.Run(ctx, rdb, keys, args...).Int64()
+	if err != nil {
+		return 0, err
 	}
-	
-	log.Printf("[RateLimit] Global limit set to %d QPS (interval: %v)", qps, interval)
-	
-	return &GlobalLimiter{
-		ticker: time.NewTicker(interval),
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func adjustRedis(ctx context.Context, rdb *redis.Client, dims []dim) error {
+	keys := make([]string, len(dims))
+	args := make([]interface{}, 0, 2*len(dims))
+	for i, d := range dims {
+		keys[i] = d.key
+		args = append(args, millis(d.interval), d.qty)
+	}
+	return adjustScript.Run(ctx, rdb, keys, args...).Err()
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 6, 64)
+}
+
+// interval 是 GCRA 的 emission interval；速率为 0 时每小时只放行一次
+func interval(l Limit) time.Duration {
+	if l.Rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// localGCRA 是进程内的 GCRA，与 gcraScript 算法相同
+type localGCRA struct {
+	mu   sync.Mutex
+	tats map[string]time.Time
+}
+
+func newLocalGCRA() *localGCRA {
+	return &localGCRA{tats: make(map[string]time.Time)}
+}
+
+func (g *localGCRA) reserve(now time.Time, dims []dim) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var wait time.Duration
+	tats := make([]time.Time, len(dims))
+	for i, d := range dims {
+		tat := g.tats[d.key]
+		if tat.Before(now) {
+			tat = now
+		}
+		if allowAt := tat.Add(time.Duration(d.qty-d.burst) * d.interval); allowAt.After(now) {
+			wait = max(wait, allowAt.Sub(now))
+		}
+		tats[i] = tat.Add(time.Duration(d.qty) * d.interval)
+	}
+	if wait > 0 {
+		return wait
+	}
+	for i, d := range dims {
+		g.tats[d.key] = tats[i]
+	}
+	return 0
+}
+
+func (g *localGCRA) adjust(now time.Time, dims []dim) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, d := range dims {
+		tat := g.tats[d.key]
+		if tat.Before(now) {
+			tat = now
+		}
+		g.tats[d.key] = tat.Add(time.Duration(d.qty) * d.interval)
 	}
 }
 
-// Wait 阻塞直到获取到令牌
-// 如果 context 取消，则返回 error
-func (l *GlobalLimiter) Wait(ctx context.Context) error {
-	select {
-	case <-l.ticker.C:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
+// ParseModelLimits 解析 "model=rate[:burst][/tpm],..." 形式的模型限额，
+// 如 "ernie-speed=3:2/120000,ernie-4.0=1" 表示 ernie-speed 每秒 3 次、突发 2 次、每分钟 12 万 token
+func ParseModelLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		model, spec, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("model limit %q: expected model=rate[:burst][/tpm]", item)
+		}
+		spec, tpmStr, hasTPM := strings.Cut(spec, "/")
+		rateStr, burstStr, hasBurst := strings.Cut(spec, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("model limit %q: invalid rate", item)
+		}
+		l := Limit{Rate: rate, Burst: 1}
+		if hasBurst {
+			if l.Burst, err = strconv.Atoi(burstStr); err != nil || l.Burst < 1 {
+				return nil, fmt.Errorf("model limit %q: invalid burst", item)
+			}
+		}
+		if hasTPM {
+			if l.TPM, err = strconv.Atoi(tpmStr); err != nil || l.TPM < 0 {
+				return nil, fmt.Errorf("model limit %q: invalid tokens per minute", item)
+			}
+		}
+		limits[strings.TrimSpace(model)] = l
 	}
+	return limits, nil
 }
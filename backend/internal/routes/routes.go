@@ -3,25 +3,27 @@ package routes
 import (
 	"net/http"
 
+	"fromheart/internal/cache"
 	"fromheart/internal/config"
 	"fromheart/internal/handlers"
 	"fromheart/internal/middleware"
+	"fromheart/internal/quota"
 
 	"github.com/gin-gonic/gin"
-	"github.com/redis/go-redis/v9"
 )
 
-func NewRouter(handler *handlers.QuestionHandler, authHandler *handlers.AuthHandler, wishHandler *handlers.WishHandler, loveHandler *handlers.LoveHandler, taskHandler *handlers.TaskHandler, cfg config.Config, rdb *redis.Client) *gin.Engine {
+func NewRouter(handler *handlers.QuestionHandler, authHandler *handlers.AuthHandler, wishHandler *handlers.WishHandler, loveHandler *handlers.LoveHandler, taskHandler *handlers.TaskHandler, ruleHandler *handlers.RuleHandler, jobHandler *handlers.JobHandler, webhookHandler *handlers.WebhookHandler, quotaHandler *handlers.QuotaHandler, quotas *quota.Engine, cfg config.Config, counters cache.Counters) *gin.Engine {
 	r := gin.Default()
-	r.Use(middleware.RateLimit(rdb))
+	r.Use(middleware.Locale())
+	r.Use(middleware.RateLimit(counters))
 	r.Use(func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 		if origin != "" {
 			c.Header("Access-Control-Allow-Origin", origin) // Echo the origin to support credentials
 		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS, DELETE")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token") // Added X-CSRF-Token
-		c.Header("Access-Control-Allow-Credentials", "true")                                  // Essential for Cookies
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-CSRF-Token, Accept-Language, Idempotency-Key") // Added X-CSRF-Token
+		c.Header("Access-Control-Allow-Credentials", "true")                                                                    // Essential for Cookies
 		c.Header("Access-Control-Max-Age", "86400")
 		if c.Request.Method == http.MethodOptions {
 			c.Status(204)
@@ -38,7 +40,7 @@ func NewRouter(handler *handlers.QuestionHandler, authHandler *handlers.AuthHand
 
 	// 【新增】应用全局并发限制，保护服务器不崩溃
 	// 限制同时处理的 AI 请求为 60 个（根据机器配置调整）
-	api.Use(middleware.GlobalConcurrencyLimit(rdb, 60))
+	api.Use(middleware.GlobalConcurrencyLimit(counters, 60))
 
 	{
 		api.POST("/register", authHandler.Register)
@@ -51,16 +53,19 @@ func NewRouter(handler *handlers.QuestionHandler, authHandler *handlers.AuthHand
 
 		// Async Task Status
 		api.GET("/task/:id", taskHandler.GetStatus)
+		api.GET("/task/:id/events", taskHandler.Events)
+		api.DELETE("/task/:id", taskHandler.Cancel)
 
 		api.POST("/question", handler.Ask)
 		api.GET("/divination/:id", handler.GetDivination)
-		// 追问接口添加每日限制
-		api.POST("/divination/:id/chat", middleware.DailyChatLimit(rdb), handler.Chat)
-		api.POST("/divination/:id/chat/stream", middleware.DailyChatLimit(rdb), handler.ChatStream)
+		// 追问按套餐计量每日次数
+		api.POST("/divination/:id/chat", middleware.Quota(quotas, quota.FeatureChat), handler.Chat)
+		api.POST("/divination/:id/chat/stream", middleware.Quota(quotas, quota.FeatureChat), handler.ChatStream)
 		api.GET("/history", handler.History)
 		api.GET("/poem", handler.GetPoem)
 		api.GET("/usage", handler.GetUsage)
 		api.GET("/blessing", handler.GetBlessing)
+		api.GET("/personas", handler.ListPersonas)
 
 		// Wishing Tree
 		api.GET("/wishes", wishHandler.ListWishes)
@@ -73,14 +78,34 @@ func NewRouter(handler *handlers.QuestionHandler, authHandler *handlers.AuthHand
 			love.POST("", loveHandler.Submit)
 			love.GET("/history", loveHandler.GetHistory)
 			love.GET("/:id", loveHandler.GetDetail)
-			// 桃花追问接口添加每日限制
-			love.POST("/:id/chat", middleware.DailyChatLimit(rdb), loveHandler.Chat)
-			love.POST("/:id/chat/stream", middleware.DailyChatLimit(rdb), loveHandler.ChatStream)
+			// 桃花追问与普通追问共用追问额度
+			love.POST("/:id/chat", middleware.Quota(quotas, quota.FeatureChat), loveHandler.Chat)
+			love.POST("/:id/chat/stream", middleware.Quota(quotas, quota.FeatureChat), loveHandler.ChatStream)
 		}
 
 		// Admin
 		api.GET("/admin/questions", handler.AdminAllHistory)
 		api.GET("/admin/love", loveHandler.AdminList)
+		api.GET("/admin/rules", ruleHandler.List)
+		api.POST("/admin/rules", ruleHandler.Create)
+		api.PUT("/admin/rules/:id", ruleHandler.Update)
+		api.DELETE("/admin/rules/:id", ruleHandler.Delete)
+		api.GET("/admin/dead-letters", taskHandler.ListDeadLetters)
+		api.DELETE("/admin/dead-letters", taskHandler.PurgeDeadLetters)
+		api.GET("/admin/dead-letters/:id", taskHandler.GetDeadLetter)
+		api.POST("/admin/dead-letters/:id/replay", taskHandler.ReplayDeadLetter)
+		api.DELETE("/admin/dead-letters/:id", taskHandler.PurgeDeadLetters)
+		api.GET("/admin/jobs", jobHandler.List)
+		api.POST("/admin/jobs/:name/run", jobHandler.Run)
+		api.GET("/admin/webhooks", webhookHandler.List)
+		api.POST("/admin/webhooks", webhookHandler.Create)
+		api.PUT("/admin/webhooks/:id", webhookHandler.Update)
+		api.DELETE("/admin/webhooks/:id", webhookHandler.Delete)
+		api.POST("/admin/webhooks/:id/ping", webhookHandler.Ping)
+		api.GET("/admin/webhook-deliveries", webhookHandler.Deliveries)
+		api.POST("/admin/webhook-deliveries/:id/redeliver", webhookHandler.Redeliver)
+		api.GET("/admin/quota/plans", quotaHandler.Plans)
+		api.PUT("/admin/users/:id/plan", quotaHandler.SetUserPlan)
 		api.GET("/health", func(c *gin.Context) {
 			c.JSON(200, gin.H{"status": "ok"})
 		})
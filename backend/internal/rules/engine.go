@@ -0,0 +1,411 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"fromheart/internal/adapters/llm"
+	"fromheart/internal/db"
+	"fromheart/internal/i18n"
+	"fromheart/internal/postprocess"
+	"fromheart/internal/ratelimit"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StagePre  = "pre"
+	StagePost = "post"
+
+	ScopeQuestion = "question"
+	ScopeChat     = "chat"
+	ScopeAll      = "all"
+
+	MatchKeyword  = "keyword"
+	MatchRegex    = "regex"
+	MatchSemantic = "semantic"
+
+	// reloadInterval bounds how stale another replica's rule set can be after an admin change.
+	reloadInterval = 30 * time.Second
+	// defaultSemanticThreshold is used when a semantic rule does not set one.
+	defaultSemanticThreshold = 0.85
+)
+
+// Fields a post rule may rewrite on a question answer; "content" rewrites a chat reply.
+var rewritableFields = map[string]bool{
+	"direct_answer":          true,
+	"summary":                true,
+	"colloquial_explanation": true,
+	"content":                true,
+}
+
+// compiled is a rule ready for matching.
+type compiled struct {
+	db.AnswerRule
+	keywords []string
+	re       *regexp.Regexp
+	vec      []float32 // embedding of Pattern for semantic rules, computed lazily
+}
+
+// Engine evaluates deterministic answer rules before and after the LLM.
+// Rules live in Postgres and are cached in memory.
+type Engine struct {
+	postgres *gorm.DB
+	llm      llm.Client
+	limiter  *ratelimit.GlobalLimiter
+
+	mu       sync.RWMutex
+	rules    []*compiled
+	loadedAt time.Time
+}
+
+func NewEngine(postgres *gorm.DB, llmClient llm.Client, limiter *ratelimit.GlobalLimiter) *Engine {
+	return &Engine{postgres: postgres, llm: llmClient, limiter: limiter}
+}
+
+// Match is a rule that fired.
+type Match struct {
+	Rule db.AnswerRule
+}
+
+// Translation is a rule's value and summary in one locale.
+type Translation struct {
+	Value   string `json:"value"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// parseTranslations decodes AnswerRule.Translations, keyed by supported locale.
+func parseTranslations(raw db.JSONB) (map[i18n.Locale]Translation, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var byTag map[string]Translation
+	if err := json.Unmarshal(raw, &byTag); err != nil {
+		return nil, err
+	}
+	out := make(map[i18n.Locale]Translation, len(byTag))
+	for tag, t := range byTag {
+		l, ok := i18n.Parse(tag)
+		if !ok || string(l) != tag {
+			return nil, fmt.Errorf("unsupported locale %q", tag)
+		}
+		out[l] = t
+	}
+	return out, nil
+}
+
+// Text returns the rule's value and summary in locale, falling back to the
+// untranslated text for what the rule does not translate.
+func (m Match) Text(locale i18n.Locale) (value, summary string) {
+	value, summary = m.Rule.Value, m.Rule.Summary
+	translations, _ := parseTranslations(m.Rule.Translations)
+	if t, ok := translations[locale]; ok {
+		if t.Value != "" {
+			value = t.Value
+		}
+		if t.Summary != "" {
+			summary = t.Summary
+		}
+	}
+	return value, summary
+}
+
+// Validate checks a rule before it is stored.
+func Validate(r *db.AnswerRule) error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name required")
+	}
+	if strings.TrimSpace(r.Pattern) == "" {
+		return errors.New("pattern required")
+	}
+	if _, err := parseTranslations(r.Translations); err != nil {
+		return fmt.Errorf("invalid translations: %w", err)
+	}
+	switch r.Scope {
+	case ScopeQuestion, ScopeChat, ScopeAll:
+	case "":
+		r.Scope = ScopeAll
+	default:
+		return fmt.Errorf("invalid scope %q", r.Scope)
+	}
+	switch r.Stage {
+	case StagePre:
+	case StagePost:
+		if !rewritableFields[r.Field] {
+			return fmt.Errorf("invalid field %q", r.Field)
+		}
+	default:
+		return fmt.Errorf("invalid stage %q", r.Stage)
+	}
+	switch r.MatchType {
+	case MatchKeyword:
+	case MatchRegex:
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+	case MatchSemantic:
+		if r.Threshold <= 0 || r.Threshold > 1 {
+			r.Threshold = defaultSemanticThreshold
+		}
+	default:
+		return fmt.Errorf("invalid match_type %q", r.MatchType)
+	}
+	return nil
+}
+
+// Invalidate forces the next evaluation to reload rules from the database.
+func (e *Engine) Invalidate() {
+	e.mu.Lock()
+	e.loadedAt = time.Time{}
+	e.mu.Unlock()
+}
+
+func (e *Engine) load(ctx context.Context) []*compiled {
+	e.mu.RLock()
+	if time.Since(e.loadedAt) < reloadInterval {
+		rules := e.rules
+		e.mu.RUnlock()
+		return rules
+	}
+	e.mu.RUnlock()
+
+	var rows []db.AnswerRule
+	if err := e.postgres.WithContext(ctx).Where("enabled = ?", true).Find(&rows).Error; err != nil {
+		log.Printf("[Rules] load failed, keeping previous set: %v", err)
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		return e.rules
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Priority > rows[j].Priority })
+	rules := make([]*compiled, 0, len(rows))
+	for _, r := range rows {
+		c := &compiled{AnswerRule: r}
+		switch r.MatchType {
+		case MatchKeyword:
+			for _, k := range strings.Split(r.Pattern, "|") {
+				if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+					c.keywords = append(c.keywords, k)
+				}
+			}
+		case MatchRegex:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				log.Printf("[Rules] skip rule %s: %v", r.Name, err)
+				continue
+			}
+			c.re = re
+		}
+		rules = append(rules, c)
+	}
+
+	e.mu.Lock()
+	// Carry over pattern embeddings so semantic rules do not re-embed on every reload.
+	for _, old := range e.rules {
+		for _, c := range rules {
+			if c.ID == old.ID && c.Pattern == old.Pattern {
+				c.vec = old.vec
+			}
+		}
+	}
+	e.rules = rules
+	e.loadedAt = time.Now()
+	e.mu.Unlock()
+	return rules
+}
+
+// matches evaluates one rule. vec is the text's embedding if the caller already has it.
+func (e *Engine) matches(ctx context.Context, c *compiled, text string, vec *[]float32) bool {
+	switch c.MatchType {
+	case MatchKeyword:
+		lower := strings.ToLower(text)
+		for _, k := range c.keywords {
+			if strings.Contains(lower, k) {
+				return true
+			}
+		}
+	case MatchRegex:
+		return c.re.MatchString(text)
+	case MatchSemantic:
+		if len(*vec) == 0 {
+			v, err := e.embed(ctx, text)
+			if err != nil {
+				return false
+			}
+			*vec = v
+		}
+		e.mu.RLock()
+		patternVec := c.vec
+		e.mu.RUnlock()
+		if len(patternVec) == 0 {
+			v, err := e.embed(ctx, c.Pattern)
+			if err != nil {
+				return false
+			}
+			e.mu.Lock()
+			c.vec = v
+			e.mu.Unlock()
+			patternVec = v
+		}
+		return cosine(*vec, patternVec) >= c.Threshold
+	}
+	return false
+}
+
+// embed computes an embedding within the shared LLM rate limit; embeddings
+// are billed on input only.
+func (e *Engine) embed(ctx context.Context, text string) ([]float32, error) {
+	tokens := llm.EstimateTokens(text)
+	res, err := e.limiter.ReserveModel(ctx, llm.EmbeddingModel, tokens)
+	if err != nil {
+		return nil, err
+	}
+	v, err := e.llm.Embed(ctx, text)
+	res.Commit(tokens)
+	return v, err
+}
+
+func (e *Engine) find(ctx context.Context, stage, scope, text string, vec []float32) []Match {
+	var out []Match
+	for _, c := range e.load(ctx) {
+		if c.Stage != stage || (c.Scope != ScopeAll && c.Scope != scope) {
+			continue
+		}
+		if e.matches(ctx, c, text, &vec) {
+			out = append(out, Match{Rule: c.AnswerRule})
+		}
+	}
+	return out
+}
+
+// Pre returns the highest-priority short-circuit rule matching text, if any.
+// vec may carry the text's embedding to avoid a second embedding call.
+func (e *Engine) Pre(ctx context.Context, scope, text string, vec []float32) (Match, bool) {
+	matches := e.find(ctx, StagePre, scope, text, vec)
+	if len(matches) == 0 {
+		return Match{}, false
+	}
+	return matches[0], true
+}
+
+// Answer builds the fixed question answer for a short-circuit rule in locale.
+func (m Match) Answer(ben, bian, lines string, locale i18n.Locale) postprocess.Output {
+	value, summary := m.Text(locale)
+	if summary == "" {
+		summary = value
+	}
+	return postprocess.Output{
+		DirectAnswer:  value,
+		Summary:       summary,
+		Advice:        []string{},
+		Warnings:      []string{},
+		Keywords:      []string{},
+		Raw:           "rule:" + m.Rule.Name,
+		BenGua:        ben,
+		BianGua:       bian,
+		ChangingLines: lines,
+		BenGuaInfo:    postprocess.HexagramInfo(ben),
+		BianGuaInfo:   postprocess.HexagramInfo(bian),
+	}
+}
+
+// ApplyPost rewrites fields of a question answer in locale according to matching
+// post rules and returns the names of the rules applied.
+func (e *Engine) ApplyPost(ctx context.Context, text string, vec []float32, out *postprocess.Output, locale i18n.Locale) []string {
+	var applied []string
+	for _, m := range e.find(ctx, StagePost, ScopeQuestion, text, vec) {
+		value, _ := m.Text(locale)
+		switch m.Rule.Field {
+		case "direct_answer":
+			out.DirectAnswer = value
+		case "summary":
+			out.Summary = value
+		case "colloquial_explanation":
+			out.Colloquial = value
+		default:
+			continue
+		}
+		applied = append(applied, m.Rule.Name)
+	}
+	return applied
+}
+
+// RewriteChat applies post rules with field "content" to a chat reply in locale.
+func (e *Engine) RewriteChat(ctx context.Context, text, reply string, locale i18n.Locale) string {
+	if content, ok := e.ChatContent(ctx, text, locale); ok {
+		return content
+	}
+	return reply
+}
+
+// ChatContent returns the reply a post "content" rule forces for the chat message
+// text. The rewrite depends on the message alone, so streaming chats check it
+// before calling the LLM and send the fixed reply instead.
+func (e *Engine) ChatContent(ctx context.Context, text string, locale i18n.Locale) (string, bool) {
+	for _, m := range e.find(ctx, StagePost, ScopeChat, text, nil) {
+		if m.Rule.Field == "content" {
+			value, _ := m.Text(locale)
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// SeedDefaults installs the built-in easter eggs and identity guarantees once.
+func (e *Engine) SeedDefaults(ctx context.Context) error {
+	var count int64
+	if err := e.postgres.WithContext(ctx).Model(&db.AnswerRule{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	defaults := []db.AnswerRule{
+		{
+			// Whole words only: "riverside" or "driver" are ordinary questions
+			Name: "easter_egg_tianhe", Enabled: true, Priority: 100, Scope: ScopeAll, Stage: StagePre,
+			MatchType: MatchRegex, Pattern: `(?i)田河|\briver\b`,
+			Value:        "天机深藏，勿探虚实，且去，且去。",
+			Translations: db.JSONB(`{"zh-TW":{"value":"天機深藏，勿探虛實，且去，且去。"},"en":{"value":"Heaven keeps this secret deep. Seek no further; go, and go in peace."}}`),
+		},
+		{
+			Name: "identity_author", Enabled: true, Priority: 90, Scope: ScopeAll, Stage: StagePre,
+			// Only questions about who made this app, not "who did it" in general
+			MatchType: MatchRegex, Pattern: `(这个|本)(应用|网站|程序|软件).*谁(开发|做)的|作者是谁`,
+			Value: "River",
+		},
+		{
+			Name: "identity_self", Enabled: true, Priority: 80, Scope: ScopeAll, Stage: StagePre,
+			MatchType: MatchRegex, Pattern: `你是谁|你是什么|你是(AI|人工智能|机器人|模型)`,
+			Value:        "山中一老叟，观象玩辞，为有缘人解惑而已。",
+			Summary:      "吾乃玄学大师，精研梅花易数数十载，不问来处，只解心中之惑。",
+			Translations: db.JSONB(`{"zh-TW":{"value":"山中一老叟，觀象玩辭，為有緣人解惑而已。","summary":"吾乃玄學大師，精研梅花易數數十載，不問來處，只解心中之惑。"},"en":{"value":"Just an old man of the mountains who reads the hexagrams to ease the doubts of those fate sends his way.","summary":"I am a master of the mysteries who has studied Plum Blossom numerology for decades. Ask not where I come from; I only answer what troubles your heart."}}`),
+		},
+	}
+	return e.postgres.WithContext(ctx).Create(&defaults).Error
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
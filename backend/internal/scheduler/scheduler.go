@@ -0,0 +1,266 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"fromheart/internal/cache"
+	"fromheart/internal/queue"
+)
+
+const (
+	// checkInterval 检查是否有任务到期的周期
+	checkInterval = 15 * time.Second
+	// lockTTL 单次触发的锁的有效期，只需覆盖各实例之间的时钟偏差与检查周期；
+	// 锁键包含触发时间，过期后也不会与之后的触发冲突
+	lockTTL = 5 * time.Minute
+	// lastRunKeyPrefix 最近一次触发记录，永不过期
+	lastRunKeyPrefix = "scheduler:last_run:"
+	// Identity 是定时任务在队列中的提交者（设备标识）
+	Identity = "scheduler"
+)
+
+// ErrUnknownJob 表示没有该名称的定时任务
+var ErrUnknownJob = errors.New("unknown job")
+
+// Job 按 cron 表达式周期性地将一个任务放入队列，由 Worker 执行
+type Job struct {
+	Name string
+	Spec string         // cron 表达式，按服务器时区解释，见 ParseCron
+	Task queue.TaskType // 需已在 Worker 中注册
+	Data interface{}    // 任务参数，序列化为 TaskPayload.Data
+}
+
+// Run 是一次触发记录
+type Run struct {
+	ScheduledAt time.Time        `json:"scheduled_at"`
+	Manual      bool             `json:"manual,omitempty"`
+	TaskID      string           `json:"task_id,omitempty"`
+	Status      queue.TaskStatus `json:"status,omitempty"` // 任务当前状态，任务状态过期后为空
+	Error       string           `json:"error,omitempty"`  // 入队或执行失败的原因
+}
+
+// JobInfo 是供管理后台展示的定时任务
+type JobInfo struct {
+	Name    string         `json:"name"`
+	Spec    string         `json:"spec"`
+	Task    queue.TaskType `json:"task"`
+	NextRun time.Time      `json:"next_run"`
+	LastRun *Run           `json:"last_run,omitempty"`
+}
+
+type job struct {
+	Job
+	schedule Schedule
+	data     json.RawMessage
+}
+
+// Scheduler 在每个实例上运行，同一次触发以队列的提交指纹（Redis 中为 SET NX）加锁，
+// 因此多个副本中只有一个会将任务入队。停机期间错过的触发不会补跑。
+type Scheduler struct {
+	q     queue.Queue
+	store cache.Cache
+
+	mu   sync.Mutex
+	jobs []*job
+	next map[string]time.Time
+}
+
+func New(q queue.Queue, store cache.Cache) *Scheduler {
+	return &Scheduler{q: q, store: store, next: make(map[string]time.Time)}
+}
+
+// Add 登记定时任务，需在 Start 之前调用
+func (s *Scheduler) Add(j Job) error {
+	schedule, err := ParseCron(j.Spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", j.Name, err)
+	}
+	next := schedule.Next(time.Now())
+	if next.IsZero() {
+		return fmt.Errorf("job %s: %q never fires", j.Name, j.Spec)
+	}
+	data, err := json.Marshal(j.Data)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", j.Name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.jobs {
+		if existing.Name == j.Name {
+			return fmt.Errorf("job %s: already registered", j.Name)
+		}
+	}
+	s.jobs = append(s.jobs, &job{Job: j, schedule: schedule, data: data})
+	s.next[j.Name] = next
+	return nil
+}
+
+// Start 按 cron 表达式触发定时任务，直到 ctx 结束
+func (s *Scheduler) Start(ctx context.Context) {
+	now := time.Now()
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		s.next[j.Name] = j.schedule.Next(now)
+	}
+	s.mu.Unlock()
+	log.Printf("[Scheduler] Started %d jobs", len(s.jobs))
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.tick(ctx, time.Now())
+		}
+	}
+}
+
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	type due struct {
+		j  *job
+		at time.Time
+	}
+	var fire []due
+	s.mu.Lock()
+	for _, j := range s.jobs {
+		at := s.next[j.Name]
+		if at.IsZero() || now.Before(at) {
+			continue
+		}
+		fire = append(fire, due{j, at})
+		s.next[j.Name] = j.schedule.Next(now)
+	}
+	s.mu.Unlock()
+
+	for _, d := range fire {
+		if _, err := s.fire(ctx, d.j, d.at, false); err != nil {
+			log.Printf("[Scheduler] Job %s (%s): %v", d.j.Name, d.at.Format(time.RFC3339), err)
+			// 入队失败时锁已释放，下个检查周期重试本次触发
+			s.mu.Lock()
+			s.next[d.j.Name] = d.at
+			s.mu.Unlock()
+		}
+	}
+}
+
+// fire 将任务入队并记录本次触发。定时触发时，若该次触发已被其他实例抢先执行则返回 nil。
+func (s *Scheduler) fire(ctx context.Context, j *job, at time.Time, manual bool) (*Run, error) {
+	taskID := uuid.New().String()
+	var keys []queue.IdempotencyKey
+	if !manual {
+		keys = append(keys, queue.NewIdempotencyKey(lockTTL, "scheduler", j.Name, at.UTC().Format(time.RFC3339)))
+		owner, err := s.q.Claim(ctx, taskID, keys...)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" {
+			return nil, nil
+		}
+	}
+
+	run := &Run{ScheduledAt: at, Manual: manual, TaskID: taskID}
+	err := s.q.Enqueue(ctx, taskID, queue.TaskPayload{
+		Type:       j.Task,
+		Data:       j.data,
+		DeviceHash: Identity,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		run.TaskID = ""
+		run.Error = err.Error()
+		// 释放本次触发的锁，以便本实例或其他实例在下个检查周期重试
+		if relErr := s.q.ReleaseClaim(context.WithoutCancel(ctx), taskID, keys...); relErr != nil {
+			log.Printf("[Scheduler] Failed to release claim of %s: %v", j.Name, relErr)
+		}
+	} else {
+		log.Printf("[Scheduler] Job %s enqueued as task %s", j.Name, taskID)
+	}
+	if saveErr := s.saveRun(ctx, j.Name, run); saveErr != nil {
+		log.Printf("[Scheduler] Failed to record run of %s: %v", j.Name, saveErr)
+	}
+	return run, err
+}
+
+// Trigger 立即触发一次定时任务，不影响正常的调度
+func (s *Scheduler) Trigger(ctx context.Context, name string) (*Run, error) {
+	j := s.find(name)
+	if j == nil {
+		return nil, ErrUnknownJob
+	}
+	return s.fire(ctx, j, time.Now(), true)
+}
+
+// Jobs 返回所有定时任务及其最近一次触发的结果
+func (s *Scheduler) Jobs(ctx context.Context) ([]JobInfo, error) {
+	s.mu.Lock()
+	jobs := make([]JobInfo, len(s.jobs))
+	for i, j := range s.jobs {
+		jobs[i] = JobInfo{Name: j.Name, Spec: j.Spec, Task: j.Task, NextRun: s.next[j.Name]}
+	}
+	s.mu.Unlock()
+
+	for i := range jobs {
+		run, err := s.lastRun(ctx, jobs[i].Name)
+		if err != nil {
+			return nil, err
+		}
+		jobs[i].LastRun = run
+	}
+	return jobs, nil
+}
+
+func (s *Scheduler) find(name string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) saveRun(ctx context.Context, name string, run *Run) error {
+	b, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ctx, lastRunKeyPrefix+name, string(b), 0)
+}
+
+// lastRun 读取最近一次触发记录，并附上任务的当前状态
+func (s *Scheduler) lastRun(ctx context.Context, name string) (*Run, error) {
+	val, ok, err := s.store.Get(ctx, lastRunKeyPrefix+name)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var run Run
+	if err := json.Unmarshal([]byte(val), &run); err != nil {
+		return nil, err
+	}
+	if run.TaskID == "" {
+		return &run, nil
+	}
+	status, err := s.q.GetStatus(ctx, run.TaskID)
+	if errors.Is(err, queue.ErrNotFound) {
+		return &run, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	run.Status = status.Status
+	if status.Error != "" {
+		run.Error = status.Error
+	}
+	return &run, nil
+}
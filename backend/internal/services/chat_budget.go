@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"fromheart/internal/adapters/llm"
+)
+
+const (
+	// chatSummaryTTL keeps summaries of older turns long enough to cover a chat session.
+	chatSummaryTTL = 24 * time.Hour
+	// maxPriorAnalysisTokens caps the previous reading embedded in the system prompt.
+	maxPriorAnalysisTokens = 1500
+	// minSummaryTokens keeps the summary useful even when the budget is nearly exhausted.
+	minSummaryTokens = 200
+	// perSummaryPromptTokens is the summarization instruction around the transcript.
+	perSummaryPromptTokens = 100
+	// perMessageTokens matches the per-message overhead of llm.EstimateMessages.
+	perMessageTokens = 4
+	// truncationMarkTokens covers the marker llm.TruncateToTokens appends.
+	truncationMarkTokens = 8
+)
+
+// fitChatBudget builds the message list for a follow-up chat and keeps it within
+// the model's context budget. When the history is too long, older turns are
+// replaced by an LLM-written summary that is cached server-side per conversation.
+func (s *QuestionService) fitChatBudget(ctx context.Context, subject chatSubject, systemPrompt string, history []ChatMessage, message string) []llm.Message {
+	messages := buildChatMessages(systemPrompt, history, message)
+	budget := llm.ContextBudget(s.llm.Model())
+	if llm.EstimateMessages(messages) <= budget {
+		return messages
+	}
+
+	// Keep the most recent turns within half of what remains after the fixed parts;
+	// the rest of the budget goes to the summary and tool results.
+	fixed := llm.EstimateMessages(buildChatMessages(systemPrompt, nil, message))
+	if fixed > budget {
+		// Even without any history the request is too long
+		return fitFixed(systemPrompt, message, budget)
+	}
+	recentBudget := (budget - fixed) / 2
+	keepFrom := len(history)
+	used := 0
+	for i := len(history) - 1; i >= 0; i-- {
+		cost := llm.EstimateTokens(history[i].Content) + perMessageTokens
+		if used+cost > recentBudget {
+			break
+		}
+		used += cost
+		keepFrom = i
+	}
+	older, recent := history[:keepFrom], history[keepFrom:]
+
+	summary, err := s.summarizeTurns(ctx, subject, older, (budget-fixed)/4)
+	if err != nil {
+		// Summarization is best effort: dropping the oldest turns still keeps the chat alive.
+		log.Printf("[Chat] summarize %s:%d failed: %v", subject.Type, subject.ID, err)
+		return buildChatMessages(systemPrompt, recent, message)
+	}
+
+	messages = []llm.Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "system", Content: "【前情提要】以下是此前对话的摘要：\n" + summary},
+	}
+	for _, msg := range recent {
+		messages = append(messages, llm.Message{Role: msg.Role, Content: msg.Content})
+	}
+	return append(messages, llm.Message{Role: "user", Content: message})
+}
+
+// fitFixed trims the system prompt and the current message so they fit in budget
+// on their own. The message keeps what the prompt leaves but at least half of the
+// budget; the prompt, whose head holds the persona and the reading, is cut at its end.
+func fitFixed(systemPrompt, message string, budget int) []llm.Message {
+	// Two message overheads plus the truncation markers
+	avail := budget - 2*perMessageTokens - 2*truncationMarkTokens
+	if msgMax := max(avail-llm.EstimateTokens(systemPrompt), avail/2); llm.EstimateTokens(message) > msgMax {
+		message = llm.TruncateToTokens(message, msgMax)
+	}
+	if sysMax := avail - llm.EstimateTokens(message); llm.EstimateTokens(systemPrompt) > sysMax {
+		systemPrompt = llm.TruncateToTokens(systemPrompt, sysMax)
+	}
+	return buildChatMessages(systemPrompt, nil, message)
+}
+
+// chatSummary is the cached summary of a conversation's oldest turns.
+type chatSummary struct {
+	Covered int    `json:"covered"` // number of leading turns folded into Summary
+	Hash    string `json:"hash"`    // hash of those turns, so an edited or different history is not mistaken for this one
+	Summary string `json:"summary"`
+}
+
+// summarizeTurns condenses turns with the LLM. The summary is cached per
+// conversation together with how many turns it covers, so each later call only
+// folds the turns that have aged out since into it.
+func (s *QuestionService) summarizeTurns(ctx context.Context, subject chatSubject, turns []ChatMessage, maxTokens int) (string, error) {
+	if len(turns) == 0 {
+		return "", fmt.Errorf("nothing to summarize")
+	}
+	if maxTokens < minSummaryTokens {
+		maxTokens = minSummaryTokens
+	}
+
+	// A conversation is told apart from others on the same subject by its first turn
+	key := fmt.Sprintf("chat_summary:%s:%d:%s", subject.Type, subject.ID, hashTurns(turns[:1]))
+	var prev chatSummary
+	if cached, ok, _ := s.cache.Get(ctx, key); ok {
+		if json.Unmarshal([]byte(cached), &prev) != nil || prev.Covered > len(turns) || prev.Hash != hashTurns(turns[:prev.Covered]) {
+			prev = chatSummary{}
+		}
+	}
+	if prev.Covered == len(turns) && prev.Summary != "" {
+		return prev.Summary, nil
+	}
+
+	// Only the newly aged-out turns are sent; when even those do not fit, the oldest are dropped.
+	inputBudget := max(llm.ContextBudget(s.llm.Model())-500-llm.EstimateTokens(prev.Summary), minSummaryTokens)
+	input := transcriptTail(turns[prev.Covered:], inputBudget)
+	instruction := fmt.Sprintf("请将以下占卜追问对话浓缩为一段摘要，保留求测者关心的问题、大师给出的关键结论与建议，不超过%d字。只输出摘要本身。", maxTokens)
+	if prev.Summary != "" {
+		instruction = fmt.Sprintf("以下是一段占卜追问对话的已有摘要和其后新增的对话。请把新增内容并入摘要，保留求测者关心的问题、大师给出的关键结论与建议，不超过%d字。只输出摘要本身。", maxTokens)
+		input = "【已有摘要】\n" + prev.Summary + "\n【新增对话】\n" + input
+	}
+
+	llmCtx, budget, err := reserveLLM(ctx, s.limiter, llm.EstimateTokens(input)+perSummaryPromptTokens, maxTokens)
+	if err != nil {
+		return "", err
+	}
+	summary, err := s.llm.Chat(llmCtx, []map[string]string{
+		{"role": "system", "content": instruction},
+		{"role": "user", "content": input},
+	})
+	budget.settle(summary)
+	if err != nil {
+		return "", err
+	}
+	summary = llm.TruncateToTokens(strings.TrimSpace(summary), maxTokens)
+
+	if data, err := json.Marshal(chatSummary{Covered: len(turns), Hash: hashTurns(turns), Summary: summary}); err == nil {
+		s.cache.Set(ctx, key, string(data), chatSummaryTTL)
+	}
+	return summary, nil
+}
+
+// formatTurn renders one turn of the transcript sent for summarizing.
+func formatTurn(t ChatMessage) string {
+	role := "求测者"
+	if t.Role == "assistant" {
+		role = "大师"
+	}
+	return fmt.Sprintf("%s：%s\n", role, t.Content)
+}
+
+// transcriptTail renders the newest turns that fit in maxTokens, dropping the oldest.
+// A single turn larger than the budget is cut.
+func transcriptTail(turns []ChatMessage, maxTokens int) string {
+	var lines []string
+	used := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		line := formatTurn(turns[i])
+		cost := llm.EstimateTokens(line)
+		if used+cost > maxTokens {
+			if len(lines) == 0 {
+				lines = append(lines, llm.TruncateToTokens(line, maxTokens))
+			}
+			break
+		}
+		used += cost
+		lines = append(lines, line)
+	}
+	slices.Reverse(lines)
+	return strings.Join(lines, "")
+}
+
+func hashTurns(turns []ChatMessage) string {
+	h := sha1.New()
+	for _, t := range turns {
+		h.Write([]byte(formatTurn(t)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"fromheart/internal/adapters/llm"
+	"fromheart/internal/db"
+	"fromheart/internal/divination"
+)
+
+// maxToolRounds bounds how many times the model may call tools in one chat turn.
+// After that the model is asked once more without tools so it must answer.
+const maxToolRounds = 3
+
+// chatSubject identifies what a follow-up chat is about and who owns it,
+// so tools only ever see the owner's own data.
+type chatSubject struct {
+	Type       string // "divination" or "love"
+	ID         uint
+	UserID     *uint
+	DeviceHash string
+}
+
+type chatTool struct {
+	def llm.Tool
+	run func(ctx context.Context, s *QuestionService, subject chatSubject, args json.RawMessage) (interface{}, error)
+}
+
+func newTool(name, description string, properties map[string]interface{}, required ...string) llm.Tool {
+	params := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		params["required"] = required
+	}
+	return llm.Tool{
+		Type:     "function",
+		Function: llm.ToolFunction{Name: name, Description: description, Parameters: params},
+	}
+}
+
+var chatTools = map[string]chatTool{
+	"lookup_hexagram": {
+		def: newTool("lookup_hexagram",
+			"查询某一卦的卦序、繁简卦名、拼音、英文名与卦辞；传入 line 时同时返回该爻的爻题（如九五）。",
+			map[string]interface{}{
+				"name": map[string]interface{}{"type": "string", "description": "卦名，如“既济”"},
+				"line": map[string]interface{}{"type": "integer", "description": "爻位 1-6，自下而上，可选"},
+			}, "name"),
+		run: toolLookupHexagram,
+	},
+	"get_divination_history": {
+		def: newTool("get_divination_history",
+			"查询该求测者以往关于同一主题的占卜记录（问题、卦象、结论）。",
+			map[string]interface{}{
+				"topic": map[string]interface{}{"type": "string", "description": "主题关键词，如“工作”“感情”"},
+				"limit": map[string]interface{}{"type": "integer", "description": "最多返回条数，默认 5，最大 10"},
+			}, "topic"),
+		run: toolDivinationHistory,
+	},
+	"get_day_pillar": {
+		def: newTool("get_day_pillar",
+			"计算某日的日柱干支，默认今天。",
+			map[string]interface{}{
+				"date": map[string]interface{}{"type": "string", "description": "日期 YYYY-MM-DD，可选"},
+			}),
+		run: toolDayPillar,
+	},
+}
+
+func chatToolDefs() []llm.Tool {
+	names := []string{"lookup_hexagram", "get_divination_history", "get_day_pillar"}
+	defs := make([]llm.Tool, 0, len(names))
+	for _, n := range names {
+		defs = append(defs, chatTools[n].def)
+	}
+	return defs
+}
+
+func toolLookupHexagram(ctx context.Context, s *QuestionService, subject chatSubject, args json.RawMessage) (interface{}, error) {
+	var in struct {
+		Name string `json:"name"`
+		Line int    `json:"line"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return nil, err
+	}
+	h, ok := divination.LookupHexagram(strings.TrimSuffix(strings.TrimSpace(in.Name), "卦"))
+	if !ok {
+		return nil, fmt.Errorf("unknown hexagram %q", in.Name)
+	}
+	out := map[string]interface{}{
+		"number":      h.Number,
+		"name":        h.Name,
+		"traditional": h.Traditional,
+		"pinyin":      h.Pinyin,
+		"english":     h.English,
+		"judgment":    divination.Judgment(h),
+	}
+	if in.Line != 0 {
+		label, ok := divination.LineLabel(h.Name, in.Line)
+		if !ok {
+			return nil, fmt.Errorf("invalid line %d", in.Line)
+		}
+		out["line"] = in.Line
+		out["line_label"] = label
+	}
+	return out, nil
+}
+
+func toolDivinationHistory(ctx context.Context, s *QuestionService, subject chatSubject, args json.RawMessage) (interface{}, error) {
+	var in struct {
+		Topic string `json:"topic"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &in); err != nil {
+		return nil, err
+	}
+	if in.Limit <= 0 || in.Limit > 10 {
+		in.Limit = 5
+	}
+
+	query := s.postgres.WithContext(ctx).Preload("Divination")
+	if subject.UserID != nil {
+		query = query.Where("user_id = ?", *subject.UserID)
+	} else {
+		// A missing or shared device hash would mix other guests' questions into the answer
+		if subject.DeviceHash == "" || subject.DeviceHash == AnonymousDevice {
+			return map[string]interface{}{"items": []map[string]interface{}{}}, nil
+		}
+		query = query.Where("device_hash = ? AND user_id IS NULL", subject.DeviceHash)
+	}
+	if topic := strings.TrimSpace(in.Topic); topic != "" {
+		query = query.Where("question_text ILIKE ?", "%"+topic+"%")
+	}
+
+	var dqs []db.DailyQuestion
+	if err := query.Order("created_at desc").Limit(in.Limit).Find(&dqs).Error; err != nil {
+		return nil, err
+	}
+
+	items := make([]map[string]interface{}, 0, len(dqs))
+	for _, q := range dqs {
+		if q.Divination.ID == 0 || (subject.Type == "divination" && q.Divination.ID == subject.ID) {
+			continue
+		}
+		items = append(items, map[string]interface{}{
+			"date":     q.CreatedAt.Format("2006-01-02"),
+			"question": q.QuestionText,
+			"ben_gua":  q.Divination.BenGua,
+			"bian_gua": q.Divination.BianGua,
+			"summary":  q.Divination.FinalOutput,
+		})
+	}
+	return map[string]interface{}{"items": items}, nil
+}
+
+func toolDayPillar(ctx context.Context, s *QuestionService, subject chatSubject, args json.RawMessage) (interface{}, error) {
+	var in struct {
+		Date string `json:"date"`
+	}
+	if len(args) > 0 {
+		if err := json.Unmarshal(args, &in); err != nil {
+			return nil, err
+		}
+	}
+	day := time.Now()
+	if in.Date != "" {
+		t, err := time.Parse("2006-01-02", in.Date)
+		if err != nil {
+			return nil, err
+		}
+		day = t
+	}
+	return map[string]interface{}{
+		"date":       day.Format("2006-01-02"),
+		"day_pillar": divination.DayPillar(day),
+	}, nil
+}
+
+// runToolChat drives a bounded tool-execution loop: call the model, execute any
+// requested tools, feed the results back, and stop once the model answers.
+// Every tool call is logged against the chat subject.
+func (s *QuestionService) runToolChat(ctx context.Context, subject chatSubject, messages []llm.Message, onToken func(string)) (string, error) {
+	tools := chatToolDefs()
+	for round := 0; ; round++ {
+		if round == maxToolRounds {
+			tools = nil // force a final answer
+		}
+
+		// Rate Limit (each round is a separate LLM call, billed for the whole conversation so far)
+		callCtx, budget, err := reserveLLM(ctx, s.limiter, llm.EstimateMessages(messages), llm.ChatOutputTokens)
+		if err != nil {
+			return "", err
+		}
+
+		res, err := s.llm.ChatWithTools(callCtx, messages, tools, onToken)
+		budget.settle(res.Content)
+		if err != nil {
+			return "", err
+		}
+		if len(res.ToolCalls) == 0 || tools == nil {
+			return res.Content, nil
+		}
+
+		messages = append(messages, llm.Message{Role: "assistant", Content: res.Content, ToolCalls: res.ToolCalls})
+		for _, call := range res.ToolCalls {
+			result := s.execTool(ctx, subject, call)
+			messages = append(messages, llm.Message{
+				Role:       "tool",
+				ToolCallID: call.ID,
+				Name:       call.Function.Name,
+				Content:    result,
+			})
+		}
+	}
+}
+
+// execTool runs one tool call and returns its JSON result (or an error object) for the model.
+func (s *QuestionService) execTool(ctx context.Context, subject chatSubject, call llm.ToolCall) string {
+	record := db.ChatToolCall{
+		SubjectType: subject.Type,
+		SubjectID:   subject.ID,
+		ToolName:    call.Function.Name,
+		Arguments:   call.Function.Arguments,
+		CreatedAt:   time.Now(),
+	}
+
+	var out interface{}
+	tool, ok := chatTools[call.Function.Name]
+	if !ok {
+		record.Error = "unknown tool"
+	} else if result, err := tool.run(ctx, s, subject, json.RawMessage(call.Function.Arguments)); err != nil {
+		record.Error = err.Error()
+	} else {
+		out = result
+	}
+	if record.Error != "" {
+		out = map[string]string{"error": record.Error}
+	}
+
+	b, _ := json.Marshal(out)
+	record.Result = string(b)
+	if err := s.postgres.WithContext(ctx).Create(&record).Error; err != nil {
+		log.Printf("[Chat] failed to log tool call %s: %v", call.Function.Name, err)
+	}
+	return record.Result
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"fromheart/internal/db"
+	"fromheart/internal/i18n"
+	"fromheart/internal/postprocess"
+)
+
+// BackfillInterpretationsRequest is the argument of the scheduled interpretation backfill.
+type BackfillInterpretationsRequest struct {
+	Limit int `json:"limit"` // divinations upgraded per run at most
+}
+
+// BackfillInterpretations stores the structured interpretation of up to limit
+// divinations saved before it was persisted or under an older
+// postprocess.SchemaVersion, re-parsing RawOutput. Upgraded rows no longer
+// match, so successive runs work through the backlog; it returns how many
+// rows were upgraded.
+func (s *QuestionService) BackfillInterpretations(ctx context.Context, limit int) (int, error) {
+	if limit <= 0 || limit > maintenanceBatchSize {
+		limit = maintenanceBatchSize
+	}
+	var rows []db.Divination
+	if err := s.postgres.WithContext(ctx).
+		Preload("DailyQuestion").
+		Where("interpretation IS NULL OR interpretation_version < ?", postprocess.SchemaVersion).
+		Order("id DESC").Limit(limit).
+		Find(&rows).Error; err != nil {
+		return 0, err
+	}
+
+	upgraded := 0
+	for _, d := range rows {
+		doc, err := s.reinterpret(ctx, d).Persisted()
+		if err != nil {
+			return upgraded, err
+		}
+		// The version guard keeps a concurrent newer write from being overwritten
+		if err := s.postgres.WithContext(ctx).Model(&db.Divination{}).
+			Where("id = ? AND (interpretation IS NULL OR interpretation_version < ?)", d.ID, postprocess.SchemaVersion).
+			Updates(map[string]interface{}{
+				"interpretation":         db.JSONB(doc),
+				"interpretation_version": postprocess.SchemaVersion,
+			}).Error; err != nil {
+			return upgraded, err
+		}
+		upgraded++
+	}
+	return upgraded, nil
+}
+
+// reinterpret rebuilds the Output of a stored divination in the language it was
+// written in; rows saved before the locale was recorded are Simplified Chinese.
+func (s *QuestionService) reinterpret(ctx context.Context, d db.Divination) postprocess.Output {
+	locale := i18n.Resolve(d.Locale, i18n.Default)
+	if !strings.HasPrefix(d.RawOutput, "rule:") {
+		out := postprocess.Normalize(d.RawOutput, d.BenGua, d.BianGua, d.ChangingLines, locale)
+		if d.DailyQuestion != nil {
+			// Embeddings are not reloaded, so only keyword and regex post rules apply again
+			s.rules.ApplyPost(ctx, d.DailyQuestion.QuestionText, nil, &out, locale)
+		}
+		return out
+	}
+
+	// Answered by a short-circuit rule: FinalOutput only kept the summary, so the
+	// direct answer is known only from an earlier interpretation, if any.
+	var prev postprocess.Output
+	if len(d.Interpretation) > 0 {
+		_ = json.Unmarshal(d.Interpretation, &prev)
+	}
+	return postprocess.Output{
+		DirectAnswer:  prev.DirectAnswer,
+		Summary:       d.FinalOutput,
+		Advice:        []string{},
+		Warnings:      []string{},
+		Keywords:      []string{},
+		BenGua:        d.BenGua,
+		BianGua:       d.BianGua,
+		ChangingLines: d.ChangingLines,
+		BenGuaInfo:    postprocess.HexagramInfo(d.BenGua),
+		BianGuaInfo:   postprocess.HexagramInfo(d.BianGua),
+	}
+}
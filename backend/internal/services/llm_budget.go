@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+
+	"fromheart/internal/adapters/llm"
+	"fromheart/internal/ratelimit"
+)
+
+// llmBudget 是一次 LLM 调用预占的限流配额，调用结束后用 settle 按实际用量校正
+type llmBudget struct {
+	res    *ratelimit.Reservation
+	usage  *llm.UsageRecorder
+	prompt int
+}
+
+// reserveLLM 等待限流并预占 promptTokens+maxOutputTokens 个 token。
+// 返回的 ctx 会记录服务商报告的实际用量，LLM 调用必须使用它。
+func reserveLLM(ctx context.Context, limiter *ratelimit.GlobalLimiter, promptTokens, maxOutputTokens int) (context.Context, *llmBudget, error) {
+	res, err := limiter.Reserve(ctx, promptTokens+maxOutputTokens)
+	if err != nil {
+		return ctx, nil, err
+	}
+	ctx, usage := llm.WithUsageRecorder(ctx)
+	return ctx, &llmBudget{res: res, usage: usage, prompt: promptTokens}, nil
+}
+
+// embedLLM 经限流调用 embedding 模型，embedding 只按输入计 token
+func embedLLM(ctx context.Context, limiter *ratelimit.GlobalLimiter, client llm.Client, text string) ([]float32, error) {
+	tokens := llm.EstimateTokens(text)
+	res, err := limiter.ReserveModel(ctx, llm.EmbeddingModel, tokens)
+	if err != nil {
+		return nil, err
+	}
+	vec, err := client.Embed(ctx, text)
+	res.Commit(tokens)
+	return vec, err
+}
+
+// settle 按实际用量校正预占：优先使用服务商报告的用量，没有时按输入估算加输出文本估算
+func (b *llmBudget) settle(output string) {
+	if u, ok := b.usage.Total(); ok {
+		b.res.Commit(u.TotalTokens)
+		return
+	}
+	b.res.Commit(b.prompt + llm.EstimateTokens(output))
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"fromheart/internal/adapters/llm"
+	"fromheart/internal/db"
+	"fromheart/internal/divination"
+	"fromheart/internal/i18n"
+	"fromheart/internal/postprocess"
+	"fromheart/internal/ratelimit"
+
+	"gorm.io/gorm"
+)
+
+// LoveService 负责桃花测算的推演与记录，由异步 Worker 与 HTTP Handler 共用
+type LoveService struct {
+	postgres *gorm.DB
+	llm      llm.Client
+	limiter  *ratelimit.GlobalLimiter
+	qs       *QuestionService // 解析人设与语言
+}
+
+func NewLoveService(postgres *gorm.DB, llmClient llm.Client, limiter *ratelimit.GlobalLimiter, qs *QuestionService) *LoveService {
+	return &LoveService{postgres: postgres, llm: llmClient, limiter: limiter, qs: qs}
+}
+
+// LoveRequest 是一次桃花测算的输入，JSON 字段与提交接口一致
+type LoveRequest struct {
+	NameA      string `json:"name_a"`
+	GenderA    string `json:"gender_a"`
+	BirthDateA string `json:"birth_date_a"`
+	NameB      string `json:"name_b"`
+	GenderB    string `json:"gender_b"`
+	BirthDateB string `json:"birth_date_b"`
+	Story      string `json:"story"`
+	Persona    string `json:"persona"`
+
+	DeviceHash string      `json:"-"`
+	UserID     *uint       `json:"-"`
+	Locale     i18n.Locale `json:"-"`
+}
+
+// LoveResponse 是推演完成后返回给前端的结果
+type LoveResponse struct {
+	ID           uint                     `json:"id"`
+	Analysis     postprocess.LoveAnalysis `json:"analysis"`
+	Hexagram     string                   `json:"hexagram"`
+	HexagramInfo *divination.Hexagram     `json:"hexagram_info"`
+}
+
+// Analyze 起卦、调用 LLM 分析并保存记录
+func (s *LoveService) Analyze(ctx context.Context, req LoveRequest) (LoveResponse, error) {
+	// 1. Generate Hexagram
+	divResult := divination.Generate(req.Story)
+	opts := s.qs.ResolveAnswerOptions(ctx, req.Persona, req.Locale, req.UserID)
+
+	// Rate Limit: reserve the estimated tokens
+	prompt := llm.PromptOverheadTokens + llm.EstimateTokens(req.Story+req.NameA+req.NameB)
+	llmCtx, budget, err := reserveLLM(ctx, s.limiter, prompt, llm.LoveOutputTokens)
+	if err != nil {
+		return LoveResponse{}, err
+	}
+
+	// 2. Call LLM
+	llmReq := llm.LoveRequest{
+		NameA: req.NameA, GenderA: req.GenderA, BirthA: req.BirthDateA,
+		NameB: req.NameB, GenderB: req.GenderB, BirthB: req.BirthDateB,
+		Story:         req.Story,
+		BenGua:        divResult.BenGua,
+		BianGua:       divResult.BianGua,
+		ChangingLines: divResult.ChangingLines,
+		Persona:       opts.Persona,
+		Locale:        opts.Locale,
+	}
+
+	rawAnalysis, err := s.llm.AnalyzeLove(llmCtx, llmReq)
+	budget.settle(rawAnalysis)
+	if err != nil {
+		return LoveResponse{}, err
+	}
+
+	// 3. Parse & validate (tolerant JSON, score clamped, missing sections repaired)
+	analysis := postprocess.NormalizeLove(rawAnalysis, opts.Locale)
+	if diag := analysis.Diagnostics; diag != nil && (len(diag.Repairs) > 0 || diag.ParseError != "") {
+		log.Printf("[Love] analysis repairs=%v parse_error=%q", diag.Repairs, diag.ParseError)
+	}
+	cleanBytes, err := json.Marshal(analysis)
+	if err != nil {
+		return LoveResponse{}, err
+	}
+
+	// 4. Save to DB
+	probe := db.LoveProbe{
+		DeviceHash:    req.DeviceHash,
+		NameA:         req.NameA,
+		GenderA:       req.GenderA,
+		BirthDateA:    req.BirthDateA,
+		NameB:         req.NameB,
+		GenderB:       req.GenderB,
+		BirthDateB:    req.BirthDateB,
+		Story:         req.Story,
+		BenGua:        divResult.BenGua,
+		BianGua:       divResult.BianGua,
+		ChangingLines: divResult.ChangingLines,
+		RawOutput:     rawAnalysis,
+		FinalResponse: string(cleanBytes),
+		Locale:        string(opts.Locale),
+		CreatedAt:     time.Now(),
+	}
+	if err := s.postgres.Create(&probe).Error; err != nil {
+		return LoveResponse{}, err
+	}
+
+	return LoveResponse{
+		ID:           probe.ID,
+		Analysis:     analysis,
+		Hexagram:     divResult.BenGua,
+		HexagramInfo: postprocess.HexagramInfo(divResult.BenGua),
+	}, nil
+}
+
+// History 返回设备最近的桃花测算记录
+func (s *LoveService) History(ctx context.Context, deviceHash string, limit int) ([]db.LoveProbe, error) {
+	var probes []db.LoveProbe
+	err := s.postgres.WithContext(ctx).Where("device_hash = ?", deviceHash).Order("created_at desc").Limit(limit).Find(&probes).Error
+	return probes, err
+}
+
+// List 返回全部设备最近的桃花测算记录（管理后台）
+func (s *LoveService) List(ctx context.Context, limit int) ([]db.LoveProbe, error) {
+	var probes []db.LoveProbe
+	err := s.postgres.WithContext(ctx).Order("created_at desc").Limit(limit).Find(&probes).Error
+	return probes, err
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"fromheart/internal/db"
+
+	"github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// maintenanceBatchSize 每批处理的记录数，避免长事务与过多的 LLM 调用
+const maintenanceBatchSize = 500
+
+// PurgeGuestDataRequest 是定时清理游客数据任务的参数
+type PurgeGuestDataRequest struct {
+	RetentionDays int `json:"retention_days"`
+}
+
+// BackfillEmbeddingsRequest 是定时补全向量任务的参数
+type BackfillEmbeddingsRequest struct {
+	Limit int `json:"limit"` // 单次最多处理的问题数
+}
+
+// PurgeGuestData 删除超过保留期的游客（未登录）占卜记录，包括问题、卦象与追问的工具调用记录，返回删除的问题数。
+// 桃花记录没有归属用户，无法区分游客，因此不在清理范围内。
+func (s *QuestionService) PurgeGuestData(ctx context.Context, retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, errors.New("retention must be positive")
+	}
+	cutoff := time.Now().Add(-retention)
+
+	var total int64
+	for {
+		var questionIDs []uint
+		if err := s.postgres.WithContext(ctx).Model(&db.DailyQuestion{}).
+			Where("user_id IS NULL AND created_at < ?", cutoff).
+			Order("id").Limit(maintenanceBatchSize).
+			Pluck("id", &questionIDs).Error; err != nil {
+			return total, err
+		}
+		if len(questionIDs) == 0 {
+			return total, nil
+		}
+
+		err := s.postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var divinationIDs []uint
+			if err := tx.Model(&db.Divination{}).Where("daily_question_id IN ?", questionIDs).Pluck("id", &divinationIDs).Error; err != nil {
+				return err
+			}
+			if len(divinationIDs) > 0 {
+				if err := tx.Where("subject_type = ? AND subject_id IN ?", "divination", divinationIDs).Delete(&db.ChatToolCall{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Where("id IN ?", divinationIDs).Delete(&db.Divination{}).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Where("id IN ?", questionIDs).Delete(&db.DailyQuestion{}).Error
+		})
+		if err != nil {
+			return total, err
+		}
+		total += int64(len(questionIDs))
+	}
+}
+
+// BackfillEmbeddings 为缺少向量的问题（写入时 Embed 失败）补全向量，最多处理 limit 条，返回成功的条数。
+// 单条失败只记录日志，下次运行时重试。
+func (s *QuestionService) BackfillEmbeddings(ctx context.Context, limit int) (int, error) {
+	if limit <= 0 || limit > maintenanceBatchSize {
+		limit = maintenanceBatchSize
+	}
+	var questions []db.DailyQuestion
+	if err := s.postgres.WithContext(ctx).
+		Select("id", "question_text").
+		Where("embedding IS NULL").
+		Order("id DESC").Limit(limit).
+		Find(&questions).Error; err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, q := range questions {
+		// 与在线请求共享 LLM 限流
+		vec, err := embedLLM(ctx, s.limiter, s.llm, q.QuestionText)
+		if err != nil {
+			if ctx.Err() != nil {
+				return done, ctx.Err()
+			}
+			log.Printf("[Maintenance] Embed question %d failed: %v", q.ID, err)
+			continue
+		}
+		if err := s.postgres.WithContext(ctx).Model(&db.DailyQuestion{}).
+			Where("id = ?", q.ID).
+			Update("embedding", pgvector.NewVector(vec)).Error; err != nil {
+			return done, err
+		}
+		done++
+	}
+	if len(questions) > 0 && done == 0 {
+		return 0, errors.New("all embeddings failed")
+	}
+	return done, nil
+}
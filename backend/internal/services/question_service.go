@@ -4,40 +4,49 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"fromheart/internal/adapters/llm"
+	"fromheart/internal/cache"
 	"fromheart/internal/db"
 	"fromheart/internal/divination"
+	"fromheart/internal/i18n"
+	"fromheart/internal/persona"
 	"fromheart/internal/postprocess"
 	"fromheart/internal/ratelimit"
+	"fromheart/internal/rules"
 
 	"github.com/pgvector/pgvector-go"
-	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
-var ErrDailyLimitReached = errors.New("daily_limit_reached")
+// AnonymousDevice is the device hash of guests that sent none. It is shared by
+// unrelated people, so it never identifies an owner.
+const AnonymousDevice = "anonymous"
 
 type QuestionService struct {
 	postgres    *gorm.DB
-	redis       *redis.Client
+	cache       cache.Cache // 每日诗签与追问摘要
 	llm         llm.Client
 	adminSecret string
 	limiter     *ratelimit.GlobalLimiter // Added
+	rules       *rules.Engine
 }
 
-func NewQuestionService(postgres *gorm.DB, redis *redis.Client, llmClient llm.Client, adminSecret string, limiter *ratelimit.GlobalLimiter) *QuestionService {
-	return &QuestionService{postgres: postgres, redis: redis, llm: llmClient, adminSecret: adminSecret, limiter: limiter}
+func NewQuestionService(postgres *gorm.DB, cache cache.Cache, llmClient llm.Client, adminSecret string, limiter *ratelimit.GlobalLimiter, ruleEngine *rules.Engine) *QuestionService {
+	return &QuestionService{postgres: postgres, cache: cache, llm: llmClient, adminSecret: adminSecret, limiter: limiter, rules: ruleEngine}
 }
 
 type AskRequest struct {
 	Question   string
 	DeviceHash string
-	Secret     string
 	UserID     *uint
+	Persona    string      // Optional persona key; falls back to the user's default
+	Locale     i18n.Locale // Request locale (Accept-Language); the user's profile locale wins
 }
 
 type AskResponse struct {
@@ -48,25 +57,13 @@ type AskResponse struct {
 func (s *QuestionService) Ask(ctx context.Context, req AskRequest) (AskResponse, error) {
 	today := time.Now().Truncate(24 * time.Hour)
 
-	// Rate limit check: max 3 per day (bypass if secret is correct)
-	if req.Secret != "loveriver" {
-		count, err := s.GetTodayQuestionCount(ctx, req.DeviceHash, req.UserID)
-		if err != nil {
-			return AskResponse{}, err
-		}
-		if count >= 10 {
-			return AskResponse{}, ErrDailyLimitReached
-		}
-	}
-
 	result := divination.Generate(req.Question)
 
 	// Vector Memory: Embed & Search
 	var vec []float32
 	var contextStr string
-	if v, err := s.llm.Embed(ctx, req.Question); err == nil {
+	if v, err := embedLLM(ctx, s.limiter, s.llm, req.Question); err == nil {
 		vec = v
-		fmt.Printf("[Vector] Embed success. Dims: %d\n", len(v))
 
 		// Search similar
 		var similar []db.DailyQuestion
@@ -88,9 +85,6 @@ func (s *QuestionService) Ask(ctx context.Context, req AskRequest) (AskResponse,
 			Order(gorm.Expr("embedding <-> ?", pgvector.NewVector(vec))).
 			Limit(2).
 			Find(&similar).Error; err == nil {
-
-			fmt.Printf("[Vector] Found %d similar items\n", len(similar))
-
 			var contexts []string
 			for _, q := range similar {
 				// Only use if it has a real divination and is not the exact same question string (though unlikely with float comparison, duplicate inputs possible)
@@ -105,7 +99,7 @@ func (s *QuestionService) Ask(ctx context.Context, req AskRequest) (AskResponse,
 		}
 	} else {
 		// Log embedding error but proceed
-		fmt.Printf("Embedding error: %v\n", err)
+		log.Printf("[Vector] Embed failed: %v", err)
 	}
 
 	question := db.DailyQuestion{
@@ -126,8 +120,47 @@ func (s *QuestionService) Ask(ctx context.Context, req AskRequest) (AskResponse,
 		return AskResponse{}, err
 	}
 
+	// Deterministic rules first: a matching short-circuit rule answers without the LLM
+	raw, final, locale, err := s.answer(ctx, req, result, vec, contextStr)
+	if err != nil {
+		// The task may be retried and would create the question again
+		s.postgres.Delete(&question)
+		return AskResponse{}, err
+	}
+
+	doc, err := final.Persisted()
+	if err != nil {
+		s.postgres.Delete(&question)
+		return AskResponse{}, err
+	}
+
+	div := db.Divination{
+		DailyQuestionID:       question.ID,
+		BenGua:                result.BenGua,
+		BianGua:               result.BianGua,
+		ChangingLines:         result.ChangingLines,
+		HexagramSeed:          result.Seed,
+		RawOutput:             raw,
+		FinalOutput:           final.Summary,
+		Interpretation:        db.JSONB(doc),
+		InterpretationVersion: postprocess.SchemaVersion,
+		Locale:                string(locale),
+		CreatedAt:             time.Now(),
+	}
+	if err := s.postgres.Create(&div).Error; err != nil {
+		s.postgres.Delete(&question)
+		return AskResponse{}, err
+	}
+
+	return AskResponse{DivinationID: div.ID, Output: final}, nil
+}
+
+// answer produces the interpretation for a question, either from a short-circuit
+// rule or from the LLM followed by post rules, and the locale it is written in.
+func (s *QuestionService) answer(ctx context.Context, req AskRequest, result divination.Result, vec []float32, contextStr string) (string, postprocess.Output, i18n.Locale, error) {
 	// Fetch user profile if logged in
 	var userProfile llm.UserProfile
+	var defaultPersona, profileLocale string
 	if req.UserID != nil {
 		var u db.User
 		if err := s.postgres.First(&u, *req.UserID).Error; err == nil {
@@ -138,43 +171,42 @@ func (s *QuestionService) Ask(ctx context.Context, req AskRequest) (AskResponse,
 				MBTI:         u.MBTI,
 				Zodiac:       u.Zodiac,
 			}
+			defaultPersona = u.Persona
+			profileLocale = u.Locale
 		}
 	}
+	locale := i18n.Resolve(profileLocale, req.Locale)
 
-	// Rate Limit Wait before calling LLM
-	if err := s.limiter.Wait(ctx); err != nil {
-		return AskResponse{}, err
+	if m, ok := s.rules.Pre(ctx, rules.ScopeQuestion, req.Question, vec); ok {
+		final := m.Answer(result.BenGua, result.BianGua, result.ChangingLines, locale)
+		return final.Raw, final, locale, nil
 	}
 
-	raw, err := s.llm.GenerateAnswer(ctx, llm.GenerateRequest{
+	// Rate Limit: reserve the estimated tokens before calling LLM
+	prompt := llm.PromptOverheadTokens + llm.EstimateTokens(req.Question) + llm.EstimateTokens(contextStr)
+	llmCtx, budget, err := reserveLLM(ctx, s.limiter, prompt, llm.AnswerOutputTokens)
+	if err != nil {
+		return "", postprocess.Output{}, "", err
+	}
+
+	raw, err := s.llm.GenerateAnswer(llmCtx, llm.GenerateRequest{
 		Question:      req.Question,
 		BenGua:        result.BenGua,
 		BianGua:       result.BianGua,
 		ChangingLines: result.ChangingLines,
 		Context:       contextStr, // Inject memory
 		UserProfile:   userProfile,
+		Persona:       persona.Resolve(req.Persona, defaultPersona),
+		Locale:        locale,
 	})
+	budget.settle(raw)
 	if err != nil {
-		return AskResponse{}, err
+		return "", postprocess.Output{}, "", err
 	}
 
-	final := postprocess.Normalize(raw, result.BenGua, result.BianGua, result.ChangingLines)
-
-	div := db.Divination{
-		DailyQuestionID: question.ID,
-		BenGua:          result.BenGua,
-		BianGua:         result.BianGua,
-		ChangingLines:   result.ChangingLines,
-		HexagramSeed:    result.Seed,
-		RawOutput:       raw,
-		FinalOutput:     final.Summary,
-		CreatedAt:       time.Now(),
-	}
-	if err := s.postgres.Create(&div).Error; err != nil {
-		return AskResponse{}, err
-	}
-
-	return AskResponse{DivinationID: div.ID, Output: final}, nil
+	final := postprocess.Normalize(raw, result.BenGua, result.BianGua, result.ChangingLines, locale)
+	s.rules.ApplyPost(ctx, req.Question, vec, &final, locale)
+	return raw, final, locale, nil
 }
 
 func (s *QuestionService) GetDivination(ctx context.Context, id uint) (db.Divination, error) {
@@ -207,45 +239,98 @@ func (s *QuestionService) History(ctx context.Context, deviceHash string, userID
 
 func (s *QuestionService) GetDailyPoem(ctx context.Context) (string, error) {
 	todayKey := "daily_poem:" + time.Now().Format("2006-01-02")
-	cached, err := s.redis.Get(ctx, todayKey).Result()
-	if err == nil {
+	cached, ok, err := s.cache.Get(ctx, todayKey)
+	if ok {
 		return cached, nil
-	} else if err != redis.Nil {
+	} else if err != nil {
 		// If real error, maybe still try to generate, but for now return err if needed
 		// But let's proceed to generate as fallback if it's just a connection glitch?
 		// No, usually better to return error if infrastructure is down.
 		// However, for redis nil, we proceed.
 	}
 
-	poem, err := s.llm.GeneratePoem(ctx)
+	llmCtx, budget, err := reserveLLM(ctx, s.limiter, llm.ShortPromptTokens, llm.ShortOutputTokens)
+	if err != nil {
+		return "", err
+	}
+	poem, err := s.llm.GeneratePoem(llmCtx)
+	budget.settle(poem)
 	if err != nil {
 		return "", err
 	}
 
 	// Calculate TTL until end of day? Or just 24h. 24h is simpler.
-	s.redis.Set(ctx, todayKey, poem, 24*time.Hour)
+	s.cache.Set(ctx, todayKey, poem, 24*time.Hour)
 	return poem, nil
 }
 
-func (s *QuestionService) GetTodayQuestionCount(ctx context.Context, deviceHash string, userID *uint) (int64, error) {
-	today := time.Now().Truncate(24 * time.Hour)
-	var count int64
-	query := s.postgres.Model(&db.DailyQuestion{}).Where("question_date = ?", today)
+// NormalizeQuestion reduces a question to its matter for deduplication:
+// case, whitespace and punctuation are ignored.
+func NormalizeQuestion(q string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(q) {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
 
+// FindSameMatter returns the divination already cast for the same matter by the same
+// identity since castHour (一事不二占), or nil if there is none. Guests without a real
+// device hash share "anonymous" and are never matched, so nobody sees another's reading.
+func (s *QuestionService) FindSameMatter(ctx context.Context, deviceHash string, userID *uint, normalized string, castHour time.Time) (*db.Divination, error) {
+	var questions []db.DailyQuestion
+	query := s.postgres.WithContext(ctx).Preload("Divination").Where("created_at >= ?", castHour)
 	if userID != nil {
 		query = query.Where("user_id = ?", *userID)
 	} else {
-		query = query.Where("device_hash = ?", deviceHash)
+		if deviceHash == "" || deviceHash == AnonymousDevice {
+			return nil, nil
+		}
+		query = query.Where("device_hash = ? AND user_id IS NULL", deviceHash)
 	}
-
-	if err := query.Count(&count).Error; err != nil {
-		return 0, err
+	if err := query.Order("created_at desc").Find(&questions).Error; err != nil {
+		return nil, err
 	}
-	return count, nil
+	for _, q := range questions {
+		if q.Divination.ID != 0 && NormalizeQuestion(q.QuestionText) == normalized {
+			div := q.Divination
+			return &div, nil
+		}
+	}
+	return nil, nil
 }
 
 func (s *QuestionService) GetBlessing(ctx context.Context) (string, error) {
-	return s.llm.GenerateBlessing(ctx)
+	llmCtx, budget, err := reserveLLM(ctx, s.limiter, llm.ShortPromptTokens, llm.ShortOutputTokens)
+	if err != nil {
+		return "", err
+	}
+	blessing, err := s.llm.GenerateBlessing(llmCtx)
+	budget.settle(blessing)
+	return blessing, err
+}
+
+// AnswerOptions controls how the master speaks: persona and output language.
+type AnswerOptions struct {
+	Persona persona.Persona
+	Locale  i18n.Locale
+}
+
+// ResolveAnswerOptions picks persona and locale for a request.
+// Persona: explicitly requested key > user's profile default > system default.
+// Locale: user's profile locale > request locale (Accept-Language) > zh-CN.
+func (s *QuestionService) ResolveAnswerOptions(ctx context.Context, requestedPersona string, requestedLocale i18n.Locale, userID *uint) AnswerOptions {
+	var u db.User
+	if userID != nil {
+		s.postgres.WithContext(ctx).Select("persona", "locale").First(&u, *userID)
+	}
+	return AnswerOptions{
+		Persona: persona.Resolve(requestedPersona, u.Persona),
+		Locale:  i18n.Resolve(u.Locale, requestedLocale),
+	}
 }
 
 type AdminQuestion struct {
@@ -260,7 +345,7 @@ type AdminQuestion struct {
 
 func (s *QuestionService) GetAllQuestions(ctx context.Context, secret string) ([]AdminQuestion, error) {
 	// Constant time comparison roughly, but strictly simple equals is fine for this context
-	if secret != s.adminSecret {
+	if s.adminSecret == "" || secret != s.adminSecret {
 		return nil, errors.New("unauthorized")
 	}
 
@@ -321,70 +406,101 @@ type ChatMessage struct {
 	Content string `json:"content"`
 }
 
-func (s *QuestionService) Chat(ctx context.Context, divinationID uint, message string, history []ChatMessage) (string, error) {
+func (s *QuestionService) Chat(ctx context.Context, divinationID uint, message string, history []ChatMessage, opts AnswerOptions) (string, error) {
 	// 1. Get original divination context
 	div, err := s.GetDivination(ctx, divinationID)
 	if err != nil {
 		return "", err
 	}
 
-	// 2. Build system prompt / context
-	var messages []map[string]string
-
-	// 2.1 System Role
-	messages = append(messages, map[string]string{
-		"role": "system",
-		"content": fmt.Sprintf(`你是一位精通梅花易数的玄学大师。
-当前正在针对一个特定的卦象为信众解惑。
-
-【原卦象信息】
-问题：%s
-本卦：%s
-变卦：%s
-动爻：%s
-卦辞总结：%s
-
-请针对用户的后续提问进行解答。回答要继续保持大师风范，语气平和、玄妙但又充满关怀。不要重复之前的卦辞，而是针对新问题进行延伸解读。`,
-			div.DailyQuestion.QuestionText, div.BenGua, div.BianGua, div.ChangingLines, div.FinalOutput),
-	})
-
-	// 2.2 Append conversation history
-	for _, msg := range history {
-		messages = append(messages, map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
-		})
+	// Short-circuit rules answer without calling the LLM
+	if reply, ok := s.ruleReply(ctx, message, opts.Locale); ok {
+		return reply, nil
 	}
 
-	// 2.3 Append current user message
-	messages = append(messages, map[string]string{
-		"role":    "user",
-		"content": message,
-	})
+	// 2. Build system prompt + history + current message (within the context budget)
+	messages := s.fitChatBudget(ctx, divinationSubject(div), divinationChatPrompt(div, opts), history, message)
 
-	// Rate Limit Wait
-	if err := s.limiter.Wait(ctx); err != nil {
+	// 3. Call LLM (with server-side tools), sanitize, then apply post rules
+	subject := divinationSubject(div)
+	reply, err := s.runToolChat(ctx, subject, messages, nil)
+	if err != nil {
 		return "", err
 	}
-
-	// 3. Call LLM
-	return s.llm.Chat(ctx, messages)
+	return s.rules.RewriteChat(ctx, message, sanitizeReply(subject, reply, opts.Locale), opts.Locale), nil
 }
 
-func (s *QuestionService) ChatStream(ctx context.Context, divinationID uint, message string, history []ChatMessage, onToken func(string)) error {
+func (s *QuestionService) ChatStream(ctx context.Context, divinationID uint, message string, history []ChatMessage, opts AnswerOptions, onToken func(string)) error {
 	// 1. Get original divination context
 	div, err := s.GetDivination(ctx, divinationID)
 	if err != nil {
 		return err
 	}
 
-	// 2. Build system prompt / context
-	var messages []map[string]string
+	// Short-circuit rules answer without calling the LLM; so do post "content" rules,
+	// which replace the whole reply and could not be applied to tokens already sent
+	if reply, ok := s.ruleReply(ctx, message, opts.Locale); ok {
+		onToken(reply)
+		return nil
+	}
+	if reply, ok := s.rules.ChatContent(ctx, message, opts.Locale); ok {
+		onToken(reply)
+		return nil
+	}
+
+	// 2. Build system prompt + history + current message (within the context budget)
+	messages := s.fitChatBudget(ctx, divinationSubject(div), divinationChatPrompt(div, opts), history, message)
+
+	// 3. Call LLM (stream, with server-side tools); tokens are sanitized sentence by sentence
+	return s.streamSanitized(ctx, divinationSubject(div), messages, opts, onToken)
+}
 
-	// 2.1 System Role
-	messages = append(messages, map[string]string{
-		"role": "system",
-		"content": fmt.Sprintf(`你是一位精通梅花易数的玄学大师。
+// ruleReply returns a fixed chat reply in locale when a short-circuit rule matches the message.
+func (s *QuestionService) ruleReply(ctx context.Context, message string, locale i18n.Locale) (string, bool) {
+	m, ok := s.rules.Pre(ctx, rules.ScopeChat, message, nil)
+	if !ok {
+		return "", false
+	}
+	value, _ := m.Text(locale)
+	return value, true
+}
+
+// sanitizeReply cleans a complete chat reply and logs what had to be removed.
+func sanitizeReply(subject chatSubject, reply string, locale i18n.Locale) string {
+	clean, applied := postprocess.Sanitize(reply, locale)
+	if len(applied) > 0 {
+		log.Printf("[Chat] sanitized %s:%d reply: %v", subject.Type, subject.ID, applied)
+	}
+	return clean
+}
+
+// streamSanitized runs a streaming tool chat and forwards sanitized tokens to onToken.
+func (s *QuestionService) streamSanitized(ctx context.Context, subject chatSubject, messages []llm.Message, opts AnswerOptions, onToken func(string)) error {
+	sanitizer := postprocess.NewStreamSanitizer(opts.Locale, onToken)
+	_, err := s.runToolChat(ctx, subject, messages, sanitizer.Write)
+	sanitizer.Flush()
+	if applied := sanitizer.Applied(); len(applied) > 0 {
+		log.Printf("[Chat] sanitized %s:%d stream: %v", subject.Type, subject.ID, applied)
+	}
+	return err
+}
+
+func divinationSubject(div db.Divination) chatSubject {
+	subject := chatSubject{Type: "divination", ID: div.ID}
+	if div.DailyQuestion != nil {
+		subject.UserID = div.DailyQuestion.UserID
+		subject.DeviceHash = div.DailyQuestion.DeviceHash
+	}
+	return subject
+}
+
+// divinationChatPrompt builds the system prompt for follow-up questions on a divination.
+func divinationChatPrompt(div db.Divination, opts AnswerOptions) string {
+	question := ""
+	if div.DailyQuestion != nil {
+		question = div.DailyQuestion.QuestionText
+	}
+	return fmt.Sprintf(`%s
 当前正在针对一个特定的卦象为信众解惑。
 
 【原卦象信息】
@@ -394,31 +510,19 @@ func (s *QuestionService) ChatStream(ctx context.Context, divinationID uint, mes
 动爻：%s
 卦辞总结：%s
 
-请针对用户的后续提问进行解答。回答要继续保持大师风范，语气平和、玄妙但又充满关怀。不要重复之前的卦辞，而是针对新问题进行延伸解读。`,
-			div.DailyQuestion.QuestionText, div.BenGua, div.BianGua, div.ChangingLines, div.FinalOutput),
-	})
+请针对用户的后续提问进行解答。回答要继续保持人设风格：%s不要重复之前的卦辞，而是针对新问题进行延伸解读。%s`,
+		opts.Persona.Instructions(), question, div.BenGua, div.BianGua, div.ChangingLines,
+		llm.TruncateToTokens(div.FinalOutput, maxPriorAnalysisTokens), opts.Persona.AnswerStyle,
+		i18n.PromptInstruction(opts.Locale))
+}
 
-	// 2.2 Append conversation history
+// buildChatMessages assembles the LLM message list: system prompt, prior turns, then the new user message.
+func buildChatMessages(systemPrompt string, history []ChatMessage, message string) []llm.Message {
+	messages := []llm.Message{{Role: "system", Content: systemPrompt}}
 	for _, msg := range history {
-		messages = append(messages, map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
-		})
+		messages = append(messages, llm.Message{Role: msg.Role, Content: msg.Content})
 	}
-
-	// 2.3 Append current user message
-	messages = append(messages, map[string]string{
-		"role":    "user",
-		"content": message,
-	})
-
-	// Rate Limit Wait
-	if err := s.limiter.Wait(ctx); err != nil {
-		return err
-	}
-
-	// 3. Call LLM
-	return s.llm.ChatStream(ctx, messages, onToken)
+	return append(messages, llm.Message{Role: "user", Content: message})
 }
 
 type UnifiedHistoryItem struct {
@@ -430,7 +534,8 @@ type UnifiedHistoryItem struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-func (s *QuestionService) GetUnifiedHistory(ctx context.Context, deviceHash string, userID *uint, limit int) ([]UnifiedHistoryItem, error) {
+// GetUnifiedHistory lists divinations and love probes with summaries in locale.
+func (s *QuestionService) GetUnifiedHistory(ctx context.Context, deviceHash string, userID *uint, limit int, locale i18n.Locale) ([]UnifiedHistoryItem, error) {
 	var items []UnifiedHistoryItem
 
 	// 1. Fetch Daily Questions (Limit)
@@ -446,7 +551,7 @@ func (s *QuestionService) GetUnifiedHistory(ctx context.Context, deviceHash stri
 	}
 
 	for _, q := range dqs {
-		summary := "暂无卦象"
+		summary := i18n.T(locale, i18n.MsgHistoryNoHexagram)
 		if q.Divination.ID != 0 {
 			summary = fmt.Sprintf("%s → %s", q.Divination.BenGua, q.Divination.BianGua)
 		}
@@ -474,8 +579,13 @@ func (s *QuestionService) GetUnifiedHistory(ctx context.Context, deviceHash stri
 	}
 
 	for _, p := range probes {
-		// Parse score from FinalResponse JSON if possible, else "姻缘推演"
-		// Simple string manipulation to be fast, or unmarshal.
+		// Summary shows the validated compatibility score when there is one
+		summary := fmt.Sprintf(i18n.T(locale, i18n.MsgHistoryHexagram), p.BenGua)
+		if p.FinalResponse != "" {
+			// The analysis is cleaned in the language it was written in
+			analysis := postprocess.NormalizeLove(p.FinalResponse, i18n.Resolve(p.Locale, locale))
+			summary = analysis.SummaryLine(p.BenGua, locale)
+		}
 
 		title := fmt.Sprintf("%s & %s", p.NameA, p.NameB)
 		items = append(items, UnifiedHistoryItem{
@@ -483,7 +593,7 @@ func (s *QuestionService) GetUnifiedHistory(ctx context.Context, deviceHash stri
 			Type:      "love",
 			Title:     title,
 			Date:      p.CreatedAt,
-			Summary:   fmt.Sprintf("卦象: %s", p.BenGua),
+			Summary:   summary,
 			CreatedAt: p.CreatedAt,
 		})
 	}
@@ -513,76 +623,62 @@ func (s *QuestionService) GetLoveProbe(ctx context.Context, id uint) (*db.LovePr
 	return &probe, nil
 }
 
-func (s *QuestionService) ChatLove(ctx context.Context, id uint, message string, history []ChatMessage) (string, error) {
+func (s *QuestionService) ChatLove(ctx context.Context, id uint, message string, history []ChatMessage, opts AnswerOptions) (string, error) {
 	// 1. Get Love Probe Context
 	probe, err := s.GetLoveProbe(ctx, id)
 	if err != nil {
 		return "", err
 	}
 
-	// 3. Construct System Prompt & Messages
-	contextPrompt := fmt.Sprintf(`
-你正在与用户谈论他们的姻缘。
-背景信息：
-甲方：%s (%s, %s)
-乙方：%s (%s, %s)
-故事：%s
-
-卦象：%s -> %s
-变爻：%s
-
-之前的分析结果：%s
-
-用户现在有新的追问。请基于以上八字和卦象背景进行解答。
-`,
-		probe.NameA, probe.GenderA, probe.BirthDateA,
-		probe.NameB, probe.GenderB, probe.BirthDateB,
-		probe.Story,
-		probe.BenGua, probe.BianGua, probe.ChangingLines,
-		probe.FinalResponse,
-	)
-
-	// Build the messages list for the LLM
-	var llmMessages []map[string]string
-
-	// System instruction as first message
-	llmMessages = append(llmMessages, map[string]string{
-		"role":    "system",
-		"content": contextPrompt,
-	})
-
-	// Add chat history
-	for _, msg := range history {
-		llmMessages = append(llmMessages, map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
-		})
+	// Short-circuit rules answer without calling the LLM
+	if reply, ok := s.ruleReply(ctx, message, opts.Locale); ok {
+		return reply, nil
 	}
 
-	// Add current user message
-	llmMessages = append(llmMessages, map[string]string{
-		"role":    "user",
-		"content": message,
-	})
+	// 2. Construct System Prompt & Messages
+	llmMessages := s.fitChatBudget(ctx, loveSubject(probe), loveChatPrompt(probe, opts), history, message)
 
-	// Rate Limit Wait
-	if err := s.limiter.Wait(ctx); err != nil {
+	// 3. Call LLM (Chat, with server-side tools), sanitize, then apply post rules
+	subject := loveSubject(probe)
+	reply, err := s.runToolChat(ctx, subject, llmMessages, nil)
+	if err != nil {
 		return "", err
 	}
-
-	// 4. Call LLM (Chat)
-	return s.llm.Chat(ctx, llmMessages)
+	return s.rules.RewriteChat(ctx, message, sanitizeReply(subject, reply, opts.Locale), opts.Locale), nil
 }
 
-func (s *QuestionService) ChatLoveStream(ctx context.Context, id uint, message string, history []ChatMessage, onToken func(string)) error {
+func (s *QuestionService) ChatLoveStream(ctx context.Context, id uint, message string, history []ChatMessage, opts AnswerOptions, onToken func(string)) error {
 	// 1. Get Love Probe Context
 	probe, err := s.GetLoveProbe(ctx, id)
 	if err != nil {
 		return err
 	}
 
+	// Short-circuit rules answer without calling the LLM; so do post "content" rules,
+	// which replace the whole reply and could not be applied to tokens already sent
+	if reply, ok := s.ruleReply(ctx, message, opts.Locale); ok {
+		onToken(reply)
+		return nil
+	}
+	if reply, ok := s.rules.ChatContent(ctx, message, opts.Locale); ok {
+		onToken(reply)
+		return nil
+	}
+
 	// 2. Construct System Prompt & Messages
-	contextPrompt := fmt.Sprintf(`
+	llmMessages := s.fitChatBudget(ctx, loveSubject(probe), loveChatPrompt(probe, opts), history, message)
+
+	// 3. Call LLM (Stream, with server-side tools); tokens are sanitized sentence by sentence
+	return s.streamSanitized(ctx, loveSubject(probe), llmMessages, opts, onToken)
+}
+
+func loveSubject(probe *db.LoveProbe) chatSubject {
+	return chatSubject{Type: "love", ID: probe.ID, DeviceHash: probe.DeviceHash}
+}
+
+// loveChatPrompt builds the system prompt for follow-up questions on a love probe.
+func loveChatPrompt(probe *db.LoveProbe, opts AnswerOptions) string {
+	return fmt.Sprintf(`%s
 你正在与用户谈论他们的姻缘。
 背景信息：
 甲方：%s (%s, %s)
@@ -594,36 +690,15 @@ func (s *QuestionService) ChatLoveStream(ctx context.Context, id uint, message s
 
 之前的分析结果：%s
 
-用户现在有新的追问。请基于以上八字和卦象背景进行解答。
-`,
+用户现在有新的追问。请基于以上八字和卦象背景进行解答，并保持人设风格：%s
+%s`,
+		opts.Persona.Instructions(),
 		probe.NameA, probe.GenderA, probe.BirthDateA,
 		probe.NameB, probe.GenderB, probe.BirthDateB,
 		probe.Story,
 		probe.BenGua, probe.BianGua, probe.ChangingLines,
-		probe.FinalResponse,
+		llm.TruncateToTokens(probe.FinalResponse, maxPriorAnalysisTokens),
+		opts.Persona.AnswerStyle,
+		i18n.PromptInstruction(opts.Locale),
 	)
-
-	var llmMessages []map[string]string
-	llmMessages = append(llmMessages, map[string]string{
-		"role":    "system",
-		"content": contextPrompt,
-	})
-	for _, msg := range history {
-		llmMessages = append(llmMessages, map[string]string{
-			"role":    msg.Role,
-			"content": msg.Content,
-		})
-	}
-	llmMessages = append(llmMessages, map[string]string{
-		"role":    "user",
-		"content": message,
-	})
-
-	// Rate Limit Wait
-	if err := s.limiter.Wait(ctx); err != nil {
-		return err
-	}
-
-	// 3. Call LLM (Stream)
-	return s.llm.ChatStream(ctx, llmMessages, onToken)
 }
@@ -0,0 +1,283 @@
+// Package webhook notifies partner endpoints when asynchronous tasks finish.
+//
+// Each matching subscription gets a delivery row (the delivery log); the worker
+// sends it as a queued task so failed attempts are retried with the queue's
+// exponential backoff. Bodies are signed with the subscription's secret, see Sign.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"fromheart/internal/db"
+
+	"gorm.io/gorm"
+)
+
+// Events. Task events are "<subject>.<outcome>".
+const (
+	EventDivinationCompleted = "divination.completed"
+	EventDivinationFailed    = "divination.failed"
+	EventLoveProbeCompleted  = "love_probe.completed"
+	EventLoveProbeFailed     = "love_probe.failed"
+	// EventPing is only sent by Ping, regardless of the subscription's filter
+	EventPing = "ping"
+)
+
+var knownEvents = []string{EventDivinationCompleted, EventDivinationFailed, EventLoveProbeCompleted, EventLoveProbeFailed}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrGaveUp means a delivery failed for good and should not be retried.
+	ErrGaveUp = errors.New("webhook delivery failed permanently")
+	// ErrNotFound means the subscription or delivery does not exist.
+	ErrNotFound = errors.New("not found")
+)
+
+// Validate checks a subscription before it is stored and normalizes its event filter.
+func Validate(sub *db.WebhookSubscription) error {
+	u, err := url.Parse(strings.TrimSpace(sub.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	sub.URL = u.String()
+
+	var filters []string
+	for _, f := range strings.Split(sub.Events, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !validFilter(f) {
+			return fmt.Errorf("unknown event %q", f)
+		}
+		filters = append(filters, f)
+	}
+	if len(filters) == 0 {
+		filters = []string{"*"}
+	}
+	sub.Events = strings.Join(filters, ",")
+	return nil
+}
+
+func validFilter(f string) bool {
+	for _, e := range knownEvents {
+		if match(f, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether filter ("*", "divination.*" or an exact event) selects event.
+func match(filter, event string) bool {
+	if filter == "*" || filter == event {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(filter, ".*")
+	return ok && strings.HasPrefix(event, prefix+".")
+}
+
+// Wants reports whether sub should receive event for a task submitted by userID.
+func Wants(sub db.WebhookSubscription, event string, userID *uint) bool {
+	if !sub.Enabled {
+		return false
+	}
+	if sub.UserID != nil && (userID == nil || *userID != *sub.UserID) {
+		return false
+	}
+	for _, f := range strings.Split(sub.Events, ",") {
+		if match(f, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dispatcher records and sends deliveries.
+type Dispatcher struct {
+	postgres *gorm.DB
+	sender   *Sender
+}
+
+func NewDispatcher(postgres *gorm.DB, sender *Sender) *Dispatcher {
+	return &Dispatcher{postgres: postgres, sender: sender}
+}
+
+// Record stores a pending delivery of event for every subscription that wants it
+// and returns their ids; the caller queues them for Deliver.
+func (d *Dispatcher) Record(ctx context.Context, event, taskID string, userID *uint, data interface{}) ([]uint, error) {
+	var subs []db.WebhookSubscription
+	if err := d.postgres.WithContext(ctx).Where("enabled = ?", true).Find(&subs).Error; err != nil {
+		return nil, err
+	}
+	var deliveries []db.WebhookDelivery
+	for _, sub := range subs {
+		if Wants(sub, event, userID) {
+			deliveries = append(deliveries, db.WebhookDelivery{SubscriptionID: sub.ID, Event: event, TaskID: taskID, Status: StatusPending})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range deliveries {
+		deliveries[i].Data = string(body)
+	}
+	if err := d.postgres.WithContext(ctx).Create(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, len(deliveries))
+	for i, dl := range deliveries {
+		ids[i] = dl.ID
+	}
+	return ids, nil
+}
+
+// Deliver makes one attempt to send a pending delivery. It returns nil when the
+// delivery succeeded or no longer needs sending, an error wrapping ErrGaveUp when
+// it failed for good (a final response or maxAttempts reached), and any other
+// error when it should be retried later.
+func (d *Dispatcher) Deliver(ctx context.Context, id uint, maxAttempts int) error {
+	var dl db.WebhookDelivery
+	if err := d.postgres.WithContext(ctx).First(&dl, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // the subscription was deleted along with its deliveries
+		}
+		return err
+	}
+	if dl.Status != StatusPending {
+		return nil
+	}
+	var sub db.WebhookSubscription
+	if err := d.postgres.WithContext(ctx).First(&sub, dl.SubscriptionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if !sub.Enabled {
+		return d.finish(ctx, &dl, StatusFailed, Attempt{Err: errors.New("subscription disabled")}, false)
+	}
+
+	a := d.sender.Send(ctx, sub.URL, sub.Secret, Envelope{ID: dl.ID, Event: dl.Event, CreatedAt: dl.CreatedAt, Data: json.RawMessage(dl.Data)})
+	switch {
+	case a.OK():
+		return d.finish(ctx, &dl, StatusSucceeded, a, true)
+	case !a.Retryable() || dl.Attempts+1 >= maxAttempts:
+		if err := d.finish(ctx, &dl, StatusFailed, a, true); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %v", ErrGaveUp, a.Err)
+	default:
+		if err := d.finish(ctx, &dl, StatusPending, a, true); err != nil {
+			return err
+		}
+		return a.Err
+	}
+}
+
+// finish records an attempt on the delivery log.
+func (d *Dispatcher) finish(ctx context.Context, dl *db.WebhookDelivery, status string, a Attempt, attempted bool) error {
+	dl.Status = status
+	dl.ResponseCode = a.StatusCode
+	dl.Error = ""
+	if a.Err != nil {
+		dl.Error = a.Err.Error()
+	}
+	if attempted {
+		dl.Attempts++
+	}
+	if status == StatusSucceeded {
+		now := time.Now()
+		dl.DeliveredAt = &now
+	}
+	// The attempt already happened: record it even if the task is being cancelled
+	return d.postgres.WithContext(context.WithoutCancel(ctx)).
+		Model(dl).
+		Select("status", "response_code", "error", "attempts", "delivered_at").
+		Updates(dl).Error
+}
+
+// Ping sends a ping event to one subscription right away, without retries,
+// and returns the logged delivery. Use it to check an endpoint and its signature handling.
+func (d *Dispatcher) Ping(ctx context.Context, subscriptionID uint) (*db.WebhookDelivery, error) {
+	var sub db.WebhookSubscription
+	if err := d.postgres.WithContext(ctx).First(&sub, subscriptionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	data, _ := json.Marshal(map[string]interface{}{"subscription_id": sub.ID})
+	dl := db.WebhookDelivery{SubscriptionID: sub.ID, Event: EventPing, Data: string(data), Status: StatusPending}
+	if err := d.postgres.WithContext(ctx).Create(&dl).Error; err != nil {
+		return nil, err
+	}
+
+	a := d.sender.Send(ctx, sub.URL, sub.Secret, Envelope{ID: dl.ID, Event: dl.Event, CreatedAt: dl.CreatedAt, Data: data})
+	status := StatusFailed
+	if a.OK() {
+		status = StatusSucceeded
+	}
+	if err := d.finish(ctx, &dl, status, a, true); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+// Redeliver resets a delivery to pending with a fresh attempt budget; the caller
+// queues it for Deliver. A delivery that is still queued may then be sent twice,
+// which receivers handle by deduplicating on the delivery id.
+func (d *Dispatcher) Redeliver(ctx context.Context, id uint) error {
+	res := d.postgres.WithContext(ctx).Model(&db.WebhookDelivery{}).
+		Where("id = ? AND event <> ?", id, EventPing).
+		Updates(map[string]interface{}{"status": StatusPending, "attempts": 0})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Deliveries returns the delivery log, newest first. subscriptionID 0 and
+// an empty status mean any.
+func (d *Dispatcher) Deliveries(ctx context.Context, subscriptionID uint, status string, limit int) ([]db.WebhookDelivery, error) {
+	q := d.postgres.WithContext(ctx).Order("id desc").Limit(limit)
+	if subscriptionID != 0 {
+		q = q.Where("subscription_id = ?", subscriptionID)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var items []db.WebhookDelivery
+	err := q.Find(&items).Error
+	return items, err
+}
+
+// DeleteSubscription removes a subscription and its delivery log; queued
+// deliveries for it are dropped when they run.
+func (d *Dispatcher) DeleteSubscription(ctx context.Context, id uint) error {
+	return d.postgres.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("subscription_id = ?", id).Delete(&db.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&db.WebhookSubscription{}, id).Error
+	})
+}
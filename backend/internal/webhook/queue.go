@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+
+	"fromheart/internal/queue"
+)
+
+// Identity is the device hash deliveries are queued under, so they share
+// workers fairly with user tasks instead of competing per subscriber.
+const Identity = "webhook"
+
+// MaxAttempts is how many times a delivery is tried before it is marked failed.
+const MaxAttempts = 6
+
+// Retry spaces attempts out over roughly half an hour.
+var Retry = queue.RetryPolicy{MaxAttempts: MaxAttempts, BaseDelay: 30 * time.Second, MaxDelay: 10 * time.Minute}
+
+// DeliverRequest is the payload of a queue.TypeWebhook task.
+type DeliverRequest struct {
+	DeliveryID uint `json:"delivery_id"`
+}
+
+// TaskEvent is the data of divination and love-probe events.
+type TaskEvent struct {
+	TaskID string           `json:"task_id"`
+	Status queue.TaskStatus `json:"status"`
+	UserID *uint            `json:"user_id,omitempty"`
+	Result interface{}      `json:"result,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// Enqueue queues a pending delivery for the worker.
+func Enqueue(ctx context.Context, q queue.Queue, deliveryID uint) error {
+	data, err := json.Marshal(DeliverRequest{DeliveryID: deliveryID})
+	if err != nil {
+		return err
+	}
+	// One task id per attempt series; the delivery id keeps logs greppable
+	taskID := "webhook-" + strconv.FormatUint(uint64(deliveryID), 10) + "-" + uuid.New().String()[:8]
+	return q.Enqueue(ctx, taskID, queue.TaskPayload{
+		Type:       queue.TypeWebhook,
+		Data:       data,
+		DeviceHash: Identity,
+		CreatedAt:  time.Now(),
+	})
+}
@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// sendTimeout bounds one attempt, including reading the response
+	sendTimeout = 10 * time.Second
+	// maxErrorBody is how much of a failed response is kept in the delivery log
+	maxErrorBody = 512
+)
+
+// Envelope is the JSON body of every delivery.
+type Envelope struct {
+	ID        uint            `json:"id"` // delivery id, stable across retries; use it to deduplicate
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Attempt is the outcome of one HTTP request.
+type Attempt struct {
+	StatusCode int // 0 when no response was received
+	Err        error
+}
+
+// OK reports whether the receiver accepted the delivery (any 2xx).
+func (a Attempt) OK() bool {
+	return a.Err == nil && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// Retryable reports whether a failed attempt may succeed later: network errors,
+// timeouts, rate limiting and server errors. Other 4xx responses are final.
+func (a Attempt) Retryable() bool {
+	if a.Err != nil && a.StatusCode == 0 {
+		return true
+	}
+	switch {
+	case a.StatusCode == http.StatusRequestTimeout, a.StatusCode == http.StatusTooEarly, a.StatusCode == http.StatusTooManyRequests:
+		return true
+	case a.StatusCode >= 500:
+		return true
+	}
+	return false
+}
+
+// Sender posts signed envelopes. Client and Now can be replaced to point
+// deliveries at a local stand-in such as httptest.Server or cmd/webhook_echo.
+type Sender struct {
+	Client *http.Client
+	Now    func() time.Time
+}
+
+func NewSender() *Sender {
+	return &Sender{
+		Client: &http.Client{
+			Timeout: sendTimeout,
+			// A redirect is reported as a failed delivery rather than followed with the signed body
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		Now: time.Now,
+	}
+}
+
+// Send posts env to url, signed with secret.
+func (s *Sender) Send(ctx context.Context, url, secret string, env Envelope) Attempt {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return Attempt{Err: err}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Attempt{Err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fromheart-webhook/1")
+	req.Header.Set(EventHeader, env.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(env.ID), 10))
+	req.Header.Set(SignatureHeader, Sign(secret, s.Now(), body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Attempt{Err: err}
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // let the connection be reused
+
+	a := Attempt{StatusCode: resp.StatusCode}
+	if !a.OK() {
+		a.Err = fmt.Errorf("receiver responded %s: %s", resp.Status, strings.TrimSpace(string(snippet)))
+	}
+	return a
+}
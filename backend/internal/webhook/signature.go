@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Request headers set on every delivery.
+const (
+	// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+	SignatureHeader = "X-Fromheart-Signature"
+	EventHeader     = "X-Fromheart-Event"
+	DeliveryHeader  = "X-Fromheart-Delivery"
+)
+
+// DefaultTolerance is how old a signature Verify accepts, limiting replays.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrBadSignature = errors.New("webhook signature mismatch")
+	ErrStale        = errors.New("webhook signature timestamp outside tolerance")
+)
+
+// Sign returns the SignatureHeader value for body sent at ts.
+func Sign(secret string, ts time.Time, body []byte) string {
+	t := strconv.FormatInt(ts.Unix(), 10)
+	return "t=" + t + ",v1=" + mac(secret, t, body)
+}
+
+// Verify checks a SignatureHeader value against body, as a receiver would.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var t, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			t = v
+		case "v1":
+			sig = v
+		}
+	}
+	ts, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || sig == "" {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, t, body))) {
+		return ErrBadSignature
+	}
+	if age := now.Sub(time.Unix(ts, 0)); tolerance > 0 && (age > tolerance || age < -tolerance) {
+		return ErrStale
+	}
+	return nil
+}
+
+func mac(secret, t string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(t))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "whsec_" + hex.EncodeToString(b)
+}
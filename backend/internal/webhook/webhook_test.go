@@ -0,0 +1,162 @@
+package webhook
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testSecret = "whsec_test"
+
+// receiver is a local stand-in endpoint that verifies signatures and answers with status.
+func receiver(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		if err := Verify(testSecret, r.Header.Get(SignatureHeader), body, DefaultTolerance, time.Now()); err != nil {
+			t.Errorf("signature does not verify against the body: %v", err)
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSenderSignsBody(t *testing.T) {
+	srv := receiver(t, http.StatusNoContent)
+	a := NewSender().Send(context.Background(), srv.URL, testSecret, Envelope{ID: 7, Event: EventPing, CreatedAt: time.Now(), Data: []byte(`{"ok":true}`)})
+	if !a.OK() {
+		t.Fatalf("Send = %+v, want OK", a)
+	}
+}
+
+func TestSenderClassifiesFailures(t *testing.T) {
+	for _, tc := range []struct {
+		status    int
+		retryable bool
+	}{
+		{http.StatusServiceUnavailable, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusBadRequest, false},
+		{http.StatusGone, false},
+	} {
+		srv := receiver(t, tc.status)
+		a := NewSender().Send(context.Background(), srv.URL, testSecret, Envelope{ID: 7, Event: EventPing, Data: []byte(`{}`)})
+		if a.OK() || a.StatusCode != tc.status || a.Retryable() != tc.retryable {
+			t.Errorf("status %d: Send = %+v, retryable %v, want retryable %v", tc.status, a, a.Retryable(), tc.retryable)
+		}
+	}
+}
+
+// statusArg records the status column written by the delivery update.
+type statusArg struct{ got *string }
+
+func (s statusArg) Match(v driver.Value) bool {
+	switch v {
+	case StatusPending, StatusSucceeded, StatusFailed:
+		*s.got = v.(string)
+	}
+	return true
+}
+
+// mockDispatcher returns a Dispatcher whose database serves one delivery with
+// the given attempts to a subscription pointing at url, and records the status
+// the attempt writes back.
+func mockDispatcher(t *testing.T, url string, attempts int) (*Dispatcher, *string) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqlDB.Close()
+	})
+	gdb, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT \* FROM "webhook_deliveries"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "subscription_id", "event", "task_id", "data", "status", "attempts", "created_at"}).
+			AddRow(1, 2, EventDivinationCompleted, "task-1", `{"task_id":"task-1"}`, StatusPending, attempts, now))
+	mock.ExpectQuery(`SELECT \* FROM "webhook_subscriptions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "events", "secret", "enabled"}).
+			AddRow(2, url, "*", testSecret, true))
+	var status string
+	args := make([]driver.Value, 7) // status, attempts, response_code, error, delivered_at, updated_at, id
+	for i := range args {
+		args[i] = statusArg{&status}
+	}
+	mock.ExpectExec(`UPDATE "webhook_deliveries" SET`).WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	return NewDispatcher(gdb, NewSender()), &status
+}
+
+func TestDeliverServerErrorStaysPending(t *testing.T) {
+	srv := receiver(t, http.StatusServiceUnavailable)
+	d, status := mockDispatcher(t, srv.URL, 0)
+
+	err := d.Deliver(context.Background(), 1, MaxAttempts)
+	if err == nil || errors.Is(err, ErrGaveUp) {
+		t.Fatalf("Deliver = %v, want a retryable error", err)
+	}
+	if *status != StatusPending {
+		t.Errorf("status = %q, want %q", *status, StatusPending)
+	}
+}
+
+func TestDeliverClientErrorGivesUp(t *testing.T) {
+	srv := receiver(t, http.StatusBadRequest)
+	d, status := mockDispatcher(t, srv.URL, 0)
+
+	if err := d.Deliver(context.Background(), 1, MaxAttempts); !errors.Is(err, ErrGaveUp) {
+		t.Fatalf("Deliver = %v, want ErrGaveUp", err)
+	}
+	if *status != StatusFailed {
+		t.Errorf("status = %q, want %q", *status, StatusFailed)
+	}
+}
+
+func TestDeliverLastAttemptGivesUp(t *testing.T) {
+	srv := receiver(t, http.StatusServiceUnavailable)
+	d, status := mockDispatcher(t, srv.URL, MaxAttempts-1)
+
+	if err := d.Deliver(context.Background(), 1, MaxAttempts); !errors.Is(err, ErrGaveUp) {
+		t.Fatalf("Deliver = %v, want ErrGaveUp", err)
+	}
+	if *status != StatusFailed {
+		t.Errorf("status = %q, want %q", *status, StatusFailed)
+	}
+}
+
+func TestDeliverSucceeds(t *testing.T) {
+	srv := receiver(t, http.StatusOK)
+	d, status := mockDispatcher(t, srv.URL, 2)
+
+	if err := d.Deliver(context.Background(), 1, MaxAttempts); err != nil {
+		t.Fatalf("Deliver = %v, want nil", err)
+	}
+	if *status != StatusSucceeded {
+		t.Errorf("status = %q, want %q", *status, StatusSucceeded)
+	}
+}
@@ -0,0 +1,30 @@
+package worker
+
+import (
+	"context"
+	"log"
+
+	"fromheart/internal/queue"
+)
+
+// meteredTasks 提交时预占了额度的任务类型，预占记录以任务 ID 为凭据
+var meteredTasks = map[queue.TaskType]bool{
+	queue.TypeQuestion: true,
+	queue.TypeLove:     true,
+}
+
+// settleQuota 在任务结束时结算额度：完成则确认扣除，失败或被取消则退还。
+// 结算失败不影响任务本身，未结算的预占会在一段时间后自动失效。
+// 重放的死信不结算：其预占已在进入死信时退还，管理员重放不占用用户额度。
+func (w *Worker) settleQuota(ctx context.Context, taskID string, payload *queue.TaskPayload, completed bool) {
+	if w.quota == nil || !meteredTasks[payload.Type] || payload.Replayed {
+		return
+	}
+	settle, action := w.quota.Refund, "refund"
+	if completed {
+		settle, action = w.quota.Commit, "commit"
+	}
+	if err := settle(ctx, taskID); err != nil {
+		log.Printf("[Worker] Quota %s for task %s failed: %v", action, taskID, err)
+	}
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"fromheart/internal/queue"
+)
+
+// TaskSpec 描述一种任务类型的处理方式，T 为解码后的请求类型
+type TaskSpec[T any] struct {
+	// Decode 从任务中解出请求，为空时将 payload.Data 按 JSON 解码为 T。
+	// 解码失败的任务不会重试。
+	Decode func(payload *queue.TaskPayload) (T, error)
+	// Handle 执行任务，返回值写入任务状态的 result；返回 queue.Permanent 包装的错误时不再重试
+	Handle func(ctx context.Context, payload *queue.TaskPayload, req T) (interface{}, error)
+	// Retry 失败后的重试策略，零值时使用 queue.DefaultRetryPolicy
+	Retry queue.RetryPolicy
+	// Timeout 单次执行的超时，0 表示不限；超时按普通失败重试
+	Timeout time.Duration
+}
+
+// taskEntry 是注册表中擦除了请求类型的 TaskSpec
+type taskEntry struct {
+	run     func(ctx context.Context, payload *queue.TaskPayload) (interface{}, error)
+	retry   queue.RetryPolicy
+	timeout time.Duration
+}
+
+// Register 为任务类型 t 注册处理方式，须在 Start 之前调用；重复注册会覆盖之前的配置
+func Register[T any](w *Worker, t queue.TaskType, spec TaskSpec[T]) {
+	decode := spec.Decode
+	if decode == nil {
+		decode = func(payload *queue.TaskPayload) (T, error) {
+			var req T
+			err := json.Unmarshal(payload.Data, &req)
+			return req, err
+		}
+	}
+	retry := spec.Retry
+	if retry.MaxAttempts == 0 {
+		retry = queue.DefaultRetryPolicy
+	}
+	w.tasks[t] = taskEntry{
+		run: func(ctx context.Context, payload *queue.TaskPayload) (interface{}, error) {
+			req, err := decode(payload)
+			if err != nil {
+				return nil, queue.Permanent(fmt.Errorf("decode %s task: %w", t, err))
+			}
+			return spec.Handle(ctx, payload, req)
+		},
+		retry:   retry,
+		timeout: spec.Timeout,
+	}
+}
+
+// unknownTask 处理没有注册的任务类型：直接进入死信，等待部署了对应处理的版本后重放
+var unknownTask = taskEntry{
+	run: func(ctx context.Context, payload *queue.TaskPayload) (interface{}, error) {
+		return nil, queue.Permanent(fmt.Errorf("unknown task type %q", payload.Type))
+	},
+	retry: queue.DefaultRetryPolicy,
+}
+
+func (w *Worker) entry(t queue.TaskType) taskEntry {
+	if e, ok := w.tasks[t]; ok {
+		return e
+	}
+	return unknownTask
+}
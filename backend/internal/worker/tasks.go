@@ -0,0 +1,125 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"fromheart/internal/i18n"
+	"fromheart/internal/queue"
+	"fromheart/internal/services"
+	"fromheart/internal/webhook"
+)
+
+// registerBuiltins 注册内置的任务类型。LLM 限流(429)等临时错误通常在几秒到几十秒内恢复；
+// 超时需覆盖在全局限流器上排队的时间与单次 LLM 调用（HTTP 超时 120s）。
+func (w *Worker) registerBuiltins() {
+	Register(w, queue.TypeQuestion, TaskSpec[services.AskRequest]{
+		Handle:  w.processQuestion,
+		Retry:   queue.RetryPolicy{MaxAttempts: 4, BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second},
+		Timeout: 3 * time.Minute,
+	})
+	Register(w, queue.TypeLove, TaskSpec[services.LoveRequest]{
+		Handle:  w.processLove,
+		Retry:   queue.RetryPolicy{MaxAttempts: 3, BaseDelay: 5 * time.Second, MaxDelay: time.Minute},
+		Timeout: 3 * time.Minute,
+	})
+
+	// 定时任务没有用户在等待，失败后慢慢重试
+	maintenanceRetry := queue.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+	Register(w, queue.TypeDailyPoem, TaskSpec[struct{}]{
+		Handle:  w.processDailyPoem,
+		Retry:   maintenanceRetry,
+		Timeout: 3 * time.Minute,
+	})
+	Register(w, queue.TypePurgeGuestData, TaskSpec[services.PurgeGuestDataRequest]{
+		Handle:  w.processPurgeGuestData,
+		Retry:   maintenanceRetry,
+		Timeout: 10 * time.Minute,
+	})
+	Register(w, queue.TypeBackfillEmbeddings, TaskSpec[services.BackfillEmbeddingsRequest]{
+		Handle:  w.processBackfillEmbeddings,
+		Retry:   maintenanceRetry,
+		Timeout: 10 * time.Minute,
+	})
+	Register(w, queue.TypeBackfillInterpretations, TaskSpec[services.BackfillInterpretationsRequest]{
+		Handle:  w.processBackfillInterpretations,
+		Retry:   maintenanceRetry,
+		Timeout: 10 * time.Minute,
+	})
+
+	if w.hooks != nil {
+		Register(w, queue.TypeWebhook, TaskSpec[webhook.DeliverRequest]{
+			Handle:  w.processWebhook,
+			Retry:   webhook.Retry,
+			Timeout: time.Minute,
+		})
+	}
+}
+
+// processQuestion 处理普通占卜
+func (w *Worker) processQuestion(ctx context.Context, payload *queue.TaskPayload, req services.AskRequest) (interface{}, error) {
+	// 补充上下文信息
+	req.DeviceHash = payload.DeviceHash
+	req.UserID = payload.UserID
+	req.Locale = i18n.Locale(payload.Locale)
+
+	// 额度已在提交时预占，任务结束后由 settleQuota 确认或退还
+	resp, err := w.qs.Ask(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Worker 只返回核心数据，Usage count 前端可以单独查或者忽略
+	return map[string]interface{}{
+		"divination_id": resp.DivinationID,
+		"result":        resp.Output,
+	}, nil
+}
+
+// processLove 处理桃花占卜
+func (w *Worker) processLove(ctx context.Context, payload *queue.TaskPayload, req services.LoveRequest) (interface{}, error) {
+	req.DeviceHash = payload.DeviceHash
+	req.UserID = payload.UserID
+	req.Locale = i18n.Locale(payload.Locale)
+	return w.ls.Analyze(ctx, req)
+}
+
+// processDailyPoem 预先生成当天的诗签，避免第一个访问者等待 LLM
+func (w *Worker) processDailyPoem(ctx context.Context, payload *queue.TaskPayload, _ struct{}) (interface{}, error) {
+	poem, err := w.qs.GetDailyPoem(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"poem": poem}, nil
+}
+
+// processPurgeGuestData 清理超过保留期的游客数据
+func (w *Worker) processPurgeGuestData(ctx context.Context, payload *queue.TaskPayload, req services.PurgeGuestDataRequest) (interface{}, error) {
+	if req.RetentionDays <= 0 {
+		return nil, queue.Permanent(fmt.Errorf("invalid retention_days %d", req.RetentionDays))
+	}
+	n, err := w.qs.PurgeGuestData(ctx, time.Duration(req.RetentionDays)*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"deleted_questions": n}, nil
+}
+
+// processBackfillEmbeddings 为缺少向量的问题补全向量
+func (w *Worker) processBackfillEmbeddings(ctx context.Context, payload *queue.TaskPayload, req services.BackfillEmbeddingsRequest) (interface{}, error) {
+	n, err := w.qs.BackfillEmbeddings(ctx, req.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"embedded": n}, nil
+}
+
+// processBackfillInterpretations 重新解析旧版本的占卜解读
+func (w *Worker) processBackfillInterpretations(ctx context.Context, payload *queue.TaskPayload, req services.BackfillInterpretationsRequest) (interface{}, error) {
+	n, err := w.qs.BackfillInterpretations(ctx, req.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"upgraded": n}, nil
+}
@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"fromheart/internal/queue"
+	"fromheart/internal/webhook"
+)
+
+// taskEvents 任务类型对应的 Webhook 事件主题，事件名为 "<主题>.<最终状态>"
+var taskEvents = map[queue.TaskType]string{
+	queue.TypeQuestion: "divination",
+	queue.TypeLove:     "love_probe",
+}
+
+// processWebhook 发送一次 Webhook 投递，失败时按 webhook.Retry 退避重试
+func (w *Worker) processWebhook(ctx context.Context, payload *queue.TaskPayload, req webhook.DeliverRequest) (interface{}, error) {
+	err := w.hooks.Deliver(ctx, req.DeliveryID, webhook.MaxAttempts)
+	if errors.Is(err, webhook.ErrGaveUp) {
+		return nil, queue.Permanent(err)
+	}
+	return nil, err
+}
+
+// notify 为任务的最终结果（完成或重试耗尽）创建 Webhook 投递并放入队列。
+// 通知失败不影响任务本身。
+func (w *Worker) notify(ctx context.Context, taskID string, payload *queue.TaskPayload, status queue.TaskStatus, result interface{}, errStr string) {
+	subject, ok := taskEvents[payload.Type]
+	if !ok || w.hooks == nil {
+		return
+	}
+	event := subject + "." + string(status)
+	ids, err := w.hooks.Record(ctx, event, taskID, payload.UserID, webhook.TaskEvent{
+		TaskID: taskID,
+		Status: status,
+		UserID: payload.UserID,
+		Result: result,
+		Error:  errStr,
+	})
+	if err != nil {
+		log.Printf("[Worker] Record %s webhooks for task %s failed: %v", event, taskID, err)
+		return
+	}
+	for _, id := range ids {
+		if err := webhook.Enqueue(ctx, w.q, id); err != nil {
+			// 记录仍为 pending，可在管理后台重新投递
+			log.Printf("[Worker] Enqueue webhook delivery %d failed: %v", id, err)
+		}
+	}
+}
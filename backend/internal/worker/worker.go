@@ -2,219 +2,269 @@ package worker
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
-	"fromheart/internal/adapters/llm"
-	"fromheart/internal/db"
-	"fromheart/internal/divination"
-	"fromheart/internal/handlers"
 	"fromheart/internal/queue"
-	"fromheart/internal/ratelimit"
+	"fromheart/internal/quota"
 	"fromheart/internal/services"
-
-	"gorm.io/gorm"
+	"fromheart/internal/webhook"
 )
 
 type Worker struct {
-	q           *queue.Queue
-	qs          *services.QuestionService
-	loveHandler *handlers.LoveHandler
-	db          *gorm.DB
-	llm         llm.Client
-	limiter     *ratelimit.GlobalLimiter
+	q  queue.Queue
+	qs *services.QuestionService
+	ls *services.LoveService
+	// hooks 任务结束时通知 Webhook 订阅，为空时不通知
+	hooks *webhook.Dispatcher
+	// quota 任务结束时结算提交时预占的额度，为空时不结算
+	quota *quota.Engine
+
+	// tasks 按任务类型注册的处理方式，见 Register
+	tasks map[queue.TaskType]taskEntry
+
+	// pollCtx 停止后 Worker 不再出队；taskCtx 停止后正在执行的任务被中断并放回队列
+	pollCtx    context.Context
+	stopPoll   context.CancelFunc
+	taskCtx    context.Context
+	abortTasks context.CancelFunc
+	wg         sync.WaitGroup
+
+	// running 记录本实例正在执行的任务，用于响应用户取消：task id -> context.CancelCauseFunc
+	running sync.Map
+
+	// maxActive 单个用户/设备同时占用的 Worker 上限，见 queue.MaxActive
+	maxActive int
 }
 
-func NewWorker(q *queue.Queue, qs *services.QuestionService, db *gorm.DB, llm llm.Client, limiter *ratelimit.GlobalLimiter) *Worker {
-	return &Worker{
-		q:       q,
-		qs:      qs,
-		db:      db,
-		llm:     llm,
-		limiter: limiter,
+func NewWorker(q queue.Queue, qs *services.QuestionService, ls *services.LoveService, hooks *webhook.Dispatcher, quotas *quota.Engine) *Worker {
+	w := &Worker{
+		q:     q,
+		qs:    qs,
+		ls:    ls,
+		hooks: hooks,
+		quota: quotas,
+		tasks: make(map[queue.TaskType]taskEntry),
 	}
+	w.pollCtx, w.stopPoll = context.WithCancel(context.Background())
+	w.taskCtx, w.abortTasks = context.WithCancel(context.Background())
+	w.registerBuiltins()
+	return w
 }
 
-func (w *Worker) Start(concurrency int) {
-	log.Printf("[Worker] Started %d workers", concurrency)
+const (
+	// reapInterval 回收过期租约的检查周期
+	reapInterval = 15 * time.Second
+	// promoteInterval 检查到期重试任务的周期，决定了退避时间的精度
+	promoteInterval = time.Second
+	// requeueGrace 停机时中断任务后，等待其放回队列的时间
+	requeueGrace = 5 * time.Second
+)
+
+// Start 启动 concurrency 个 Worker；maxShare 为单个用户/设备最多占用的比例
+func (w *Worker) Start(concurrency int, maxShare float64) {
+	w.maxActive = queue.MaxActive(concurrency, maxShare)
+	log.Printf("[Worker] Started %d workers (max %d per identity)", concurrency, w.maxActive)
+	go w.q.RunReaper(w.pollCtx, reapInterval)
+	go w.q.RunScheduler(w.pollCtx, promoteInterval)
+	// 停止出队后仍需响应取消，直到正在执行的任务结束
+	go w.watchCancels(w.taskCtx)
+	w.wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
 		go w.loop(i)
 	}
 }
 
-func (w *Worker) loop(id int) {
-	for {
-		// No internal wait here. 
-		// The rate limiting happens inside w.qs.Ask() or w.processLove()
+// Stop 停止出队并等待正在执行的任务完成；ctx 到期时中断剩余任务并将其放回队列，
+// 由其他实例（或重启后的本实例）继续处理。
+func (w *Worker) Stop(ctx context.Context) error {
+	w.stopPoll()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
 
-		ctx := context.Background()
-		// 2. Dequeue
-		taskID, payload, err := w.q.Dequeue(ctx)
+	log.Printf("[Worker] drain deadline reached, requeueing in-flight tasks")
+	w.abortTasks()
+	// 被中断的任务只需完成放回队列的 Redis 操作
+	select {
+	case <-done:
+		return nil
+	case <-time.After(requeueGrace):
+		return fmt.Errorf("workers still busy after requeue grace period")
+	}
+}
+
+func (w *Worker) loop(id int) {
+	defer w.wg.Done()
+	for w.pollCtx.Err() == nil {
+		// 不在此处限流：LLM 调用由各任务处理函数内部经全局限流器排队
+		// 取出的任务移入 processing 列表并持有租约，直到 Ack
+		d, err := w.q.Dequeue(w.pollCtx, w.maxActive)
+		if err == queue.ErrNoTask || w.pollCtx.Err() != nil {
+			continue
+		}
 		if err != nil {
 			log.Printf("[Worker %d] Redis error: %v", id, err)
 			time.Sleep(time.Second) // 出错后等待，防止死循环刷日志
 			continue
 		}
 
-		log.Printf("[Worker %d] Processing task %s type %s", id, taskID, payload.Type)
-		w.q.UpdateStatus(ctx, taskID, queue.StatusProcessing, nil, "")
-
-		var result interface{}
-		var processErr error
-
-		switch payload.Type {
-		case queue.TypeQuestion:
-			result, processErr = w.processQuestion(ctx, payload)
-		case queue.TypeLove:
-			result, processErr = w.processLove(ctx, payload)
-		default:
-			processErr = fmt.Errorf("unknown task type")
-		}
-
-		if processErr != nil {
-			log.Printf("[Worker %d] Task %s failed: %v", id, taskID, processErr)
-			w.q.UpdateStatus(ctx, taskID, queue.StatusFailed, nil, processErr.Error())
-		} else {
-			log.Printf("[Worker %d] Task %s completed", id, taskID)
-			w.q.UpdateStatus(ctx, taskID, queue.StatusCompleted, result, "")
-		}
+		w.handle(w.taskCtx, id, d)
 	}
 }
 
-// processQuestion 处理普通占卜
-func (w *Worker) processQuestion(ctx context.Context, payload *queue.TaskPayload) (interface{}, error) {
-	var req services.AskRequest
-	if err := json.Unmarshal(payload.Data, &req); err != nil {
-		return nil, err
-	}
-	// 补充上下文信息
-	req.DeviceHash = payload.DeviceHash
-	req.UserID = payload.UserID
-
-	// 调用 Service
-	// 注意：Ask 内部目前包含了“检查限额”的逻辑。
-	// 在异步模式下，限额应该在入队前检查（Handler层），Worker 层默认是合法的。
-	// 但再次检查也无妨。
-	resp, err := w.qs.Ask(ctx, req)
-	if err != nil {
-		return nil, err
-	}
-
-	// 返回给前端的数据结构
-	// 之前 Handler 返回的是: 
-	// "divination_id": resp.DivinationID,
-	// "result":        resp.Output,
-	// "usage_count":   count
-	
-	// Worker 只返回核心数据，Usage count 前端可以单独查或者忽略
-	return map[string]interface{}{
-		"divination_id": resp.DivinationID,
-		"result":        resp.Output,
-	}, nil
-}
+// handle 处理单个任务：写入最终状态后才 Ack，进程在此之前崩溃的任务会被 reaper 重新投递。
+// ctx 仅用于任务执行本身；队列状态的写入使用不会被中断的 qctx。
+func (w *Worker) handle(ctx context.Context, id int, d *queue.Delivery) {
+	taskID, payload := d.ID, d.Payload
+	qctx := context.WithoutCancel(ctx)
 
-// processLove 处理桃花占卜
-// 由于 Love 的逻辑之前写在 Handler 里，这里需要搬运过来
-func (w *Worker) processLove(ctx context.Context, payload *queue.TaskPayload) (interface{}, error) {
-	var req struct {
-		NameA      string `json:"name_a"`
-		GenderA    string `json:"gender_a"`
-		BirthDateA string `json:"birth_date_a"`
-		NameB      string `json:"name_b"`
-		GenderB    string `json:"gender_b"`
-		BirthDateB string `json:"birth_date_b"`
-		Story      string `json:"story"`
+	// 重新投递的任务可能在崩溃前已经写入了最终状态，此时只需确认
+	if st, err := w.q.GetStatus(qctx, taskID); err == nil && st.Status.Terminal() {
+		log.Printf("[Worker %d] Task %s already %s, acking redelivery", id, taskID, st.Status)
+		w.ack(qctx, id, d)
+		w.settleQuota(qctx, taskID, payload, st.Status == queue.StatusCompleted)
+		return
 	}
-	if err := json.Unmarshal(payload.Data, &req); err != nil {
-		return nil, err
+
+	shutdown := ctx
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	w.running.Store(taskID, cancel)
+	defer w.running.Delete(taskID)
+	// 登记之前发出的取消广播会被错过，以取消标记为准
+	if w.q.CancelRequested(qctx, taskID) {
+		cancel(queue.ErrCancelled)
 	}
 
-	// 1. Generate Hexagram
-	divResult := divination.Generate(req.Story)
+	log.Printf("[Worker %d] Processing task %s type %s", id, taskID, payload.Type)
+	w.q.UpdateStatus(qctx, taskID, queue.StatusProcessing, nil, "")
+	stopLease := w.keepLease(qctx, d)
 
-	// Rate Limit Wait
-	if err := w.limiter.Wait(ctx); err != nil {
-		return nil, err
+	task := w.entry(payload.Type)
+	runCtx := ctx
+	if task.timeout > 0 {
+		var cancelRun context.CancelFunc
+		runCtx, cancelRun = context.WithTimeout(ctx, task.timeout)
+		defer cancelRun()
 	}
+	result, processErr := task.run(runCtx, payload)
+	stopLease()
 
-	// 2. Call LLM
-	llmReq := llm.LoveRequest{
-		NameA: req.NameA, GenderA: req.GenderA, BirthA: req.BirthDateA,
-		NameB: req.NameB, GenderB: req.GenderB, BirthB: req.BirthDateB,
-		Story:         req.Story,
-		BenGua:        divResult.BenGua,
-		BianGua:       divResult.BianGua,
-		ChangingLines: divResult.ChangingLines,
+	// 用户取消：未产出结果的任务标记为 cancelled，退还预占的额度
+	if processErr != nil && errors.Is(context.Cause(ctx), queue.ErrCancelled) {
+		log.Printf("[Worker %d] Task %s cancelled by user", id, taskID)
+		w.q.UpdateStatus(qctx, taskID, queue.StatusCancelled, nil, "")
+		w.ack(qctx, id, d)
+		w.settleQuota(qctx, taskID, payload, false)
+		return
 	}
 
-	rawAnalysis, err := w.llm.AnalyzeLove(ctx, llmReq)
-	if err != nil {
-		return nil, err
+	// 停机中断：不计入失败次数，原样放回队列。已经执行成功的任务照常完成，避免重启后重复执行
+	if processErr != nil && shutdown.Err() != nil {
+		if err := w.q.Requeue(qctx, d); err != nil {
+			log.Printf("[Worker %d] Requeue task %s failed, the reaper will recover it: %v", id, taskID, err)
+		} else {
+			log.Printf("[Worker %d] Task %s interrupted by shutdown, requeued", id, taskID)
+		}
+		return
 	}
 
-	// 3. Parse JSON
-	cleanJSON := extractJSON(rawAnalysis)
-	var finalObj map[string]interface{}
-	if err := json.Unmarshal([]byte(cleanJSON), &finalObj); err != nil {
-		// Fallback logic
-		finalObj = map[string]interface{}{
-			"score":                0,
-			"keyword":              "天机难测",
-			"bazi_analysis":        "服务器解析异常，请重试",
-			"hexagram_analysis":    rawAnalysis,
-			"story_interpretation": "...",
-			"advice":               []string{},
-			"poem":                 "道可道非常道",
+	if processErr != nil {
+		// 失败的任务按策略进入延迟重试或死信，状态与出队确认由 Fail 原子完成
+		retried, err := w.q.Fail(qctx, d, task.retry, processErr)
+		switch {
+		case err != nil:
+			log.Printf("[Worker %d] Task %s failed (%v), and recording the failure failed: %v", id, taskID, processErr, err)
+		case retried:
+			log.Printf("[Worker %d] Task %s attempt %d failed, will retry: %v", id, taskID, d.Attempt+1, processErr)
+		default:
+			log.Printf("[Worker %d] Task %s failed after %d attempt(s), dead-lettered: %v", id, taskID, d.Attempt+1, processErr)
+			w.settleQuota(qctx, taskID, payload, false)
+			w.notify(qctx, taskID, payload, queue.StatusFailed, nil, processErr.Error())
 		}
-		fallbackBytes, _ := json.Marshal(finalObj)
-		cleanJSON = string(fallbackBytes)
+		return
 	}
 
-	// 4. Save to DB
-	probe := db.LoveProbe{
-		DeviceHash:    payload.DeviceHash,
-		NameA:         req.NameA,
-		GenderA:       req.GenderA,
-		BirthDateA:    req.BirthDateA,
-		NameB:         req.NameB,
-		GenderB:       req.GenderB,
-		BirthDateB:    req.BirthDateB,
-		Story:         req.Story,
-		BenGua:        divResult.BenGua,
-		BianGua:       divResult.BianGua,
-		ChangingLines: divResult.ChangingLines,
-		RawOutput:     rawAnalysis,
-		FinalResponse: cleanJSON,
-		CreatedAt:     time.Now(),
-	}
+	log.Printf("[Worker %d] Task %s completed", id, taskID)
+	w.q.UpdateStatus(qctx, taskID, queue.StatusCompleted, result, "")
+	w.ack(qctx, id, d)
+	w.settleQuota(qctx, taskID, payload, true)
+	w.notify(qctx, taskID, payload, queue.StatusCompleted, result, "")
+}
 
-	if err := w.db.Create(&probe).Error; err != nil {
-		return nil, err
+func (w *Worker) ack(ctx context.Context, id int, d *queue.Delivery) {
+	if err := w.q.Ack(ctx, d); err != nil {
+		log.Printf("[Worker %d] Ack task %s failed: %v", id, d.ID, err)
 	}
-
-	return map[string]interface{}{
-		"id":       probe.ID,
-		"analysis": finalObj,
-		"hexagram": divResult.BenGua,
-	}, nil
 }
 
-// 辅助函数
-func extractJSON(s string) string {
-	start := -1
-	end := -1
-	for i, c := range s {
-		if c == '{' && start == -1 {
-			start = i
+// watchCancels 接收取消广播，中断本实例上对应任务的执行
+func (w *Worker) watchCancels(ctx context.Context) {
+	for ctx.Err() == nil {
+		ids, stop, err := w.q.SubscribeCancels(ctx)
+		if err != nil {
+			// 错过的取消仍会通过取消标记在任务开始执行时生效
+			log.Printf("[Worker] subscribe to cancellations failed: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second * 5):
+			}
+			continue
 		}
-		if c == '}' {
-			end = i
+		w.applyCancels(ctx, ids)
+		stop()
+	}
+}
+
+func (w *Worker) applyCancels(ctx context.Context, ids <-chan string) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case taskID, ok := <-ids:
+			if !ok {
+				return
+			}
+			if cancel, ok := w.running.Load(taskID); ok {
+				cancel.(context.CancelCauseFunc)(queue.ErrCancelled)
+			}
 		}
 	}
-	if start != -1 && end != -1 && end > start {
-		return s[start : end+1]
+}
+
+// keepLease 在任务处理期间定期续约，返回的函数用于停止续约
+func (w *Worker) keepLease(ctx context.Context, d *queue.Delivery) func() {
+	leaseCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(queue.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+				if err := w.q.Extend(leaseCtx, d); err != nil && leaseCtx.Err() == nil {
+					log.Printf("[Worker] Extend lease for task %s failed: %v", d.ID, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done // 确保 Ack 之后不会再有续约写回租约
 	}
-	return s
 }
@@ -47,7 +47,7 @@ export default function AdminPage() {
 
   useEffect(() => {
     const secret = window.localStorage.getItem("fh_secret");
-    if (!secret || secret !== "loveriver") {
+    if (!secret) {
       router.push("/");
       return;
     }
@@ -5,7 +5,7 @@ import Image from "next/image";
 import Link from "next/link";
 import { useEffect, useState } from "react";
 
-import { askQuestion, getDailyPoem, getUsage } from "../lib/api";
+import { askQuestion, getDailyPoem, getUsage, verifyAdminSecret } from "../lib/api";
 import { Output } from "../types";
 import ResultDisplay from "../components/ResultDisplay";
 import LoadingAnimation from "../components/LoadingAnimation";
@@ -41,9 +41,18 @@ export default function HomePage() {
   useEffect(() => {
     getDailyPoem().then((res) => setPoem(res.poem)).catch(() => {});
 
-    // Check dev mode
-    if (window.localStorage.getItem("fh_secret") === "loveriver") {
-      setDevModeActive(true);
+    // Check dev mode: only the server knows whether the stored secret is valid
+    const storedSecret = window.localStorage.getItem("fh_secret");
+    if (storedSecret) {
+      verifyAdminSecret(storedSecret)
+        .then((ok) => {
+          if (ok) {
+            setDevModeActive(true);
+          } else {
+            window.localStorage.removeItem("fh_secret");
+          }
+        })
+        .catch(() => {});
     }
 
     const key = "fh_device";
@@ -159,9 +168,10 @@ export default function HomePage() {
     setPressProgress(0);
   };
   
-  const handleDevSubmit = () => {
-    if (devSecret === "loveriver") {
-      window.localStorage.setItem("fh_secret", "loveriver");
+  const handleDevSubmit = async () => {
+    const ok = await verifyAdminSecret(devSecret).catch(() => false);
+    if (ok) {
+      window.localStorage.setItem("fh_secret", devSecret);
       setDevModeActive(true);
       setShowDevModal(false);
       setDevToast("天机已开");
@@ -36,7 +36,7 @@ export async function register(username: string, password: string) {
     });
     if (!res.ok) {
         const error = await res.json(); 
-        throw new Error(error.error || "Register failed");
+        throw new Error(error.message || error.error || "Register failed");
     }
     return res.json();
 }
@@ -49,7 +49,8 @@ export async function login(username: string, password: string) {
         body: JSON.stringify({ username, password }),
     });
     if (!res.ok) {
-        throw new Error("Invalid credentials");
+        const error = await res.json().catch(() => ({}));
+        throw new Error(error.message || "Invalid credentials");
     }
     return res.json();
 }
@@ -251,6 +252,18 @@ export async function getBlessing() {
   return res.json();
 }
 
+// verifyAdminSecret asks the server whether secret is the admin secret
+export async function verifyAdminSecret(secret: string): Promise<boolean> {
+  const res = await fetch(`${API_BASE}/api/admin/quota/plans`, {
+    ...fetchOptions,
+    headers: {
+      ...getHeaders(),
+      "X-Admin-Secret": secret
+    }
+  });
+  return res.ok;
+}
+
 export async function getAdminQuestions(secret: string) {
   const res = await fetch(`${API_BASE}/api/admin/questions`, {
     ...fetchOptions,
@@ -388,25 +401,85 @@ export interface LoveProbeResponse {
 }
 
 // 异步任务相关
-export type TaskStatus = "pending" | "processing" | "completed" | "failed";
+export type TaskStatus = "pending" | "processing" | "completed" | "failed" | "cancelled";
 
 export interface TaskResult<T = any> {
     status: TaskStatus;
     result?: T;
     error?: string;
+    // 仅排队中返回：前方还有 ahead 位，约 eta_seconds 秒后开始推演
+    position?: number;
+    ahead?: number;
+    eta_seconds?: number;
 }
 
-export async function getTaskStatus<T>(taskId: string) {
-    const res = await fetch(`${API_BASE}/api/task/${taskId}`, { headers: getHeaders() });
+// 只有提交者（同一登录用户或设备）能看到任务结果
+export async function getTaskStatus<T>(taskId: string, deviceHash: string) {
+    const res = await fetch(`${API_BASE}/api/task/${taskId}?device_hash=${deviceHash}`, { ...fetchOptions, headers: getHeaders() });
     if (!res.ok) throw new Error("Check task failed");
     return res.json() as Promise<TaskResult<T>>;
 }
 
-export async function pollTask<T>(taskId: string, interval = 1500, timeout = 120000): Promise<T> {
+// 通过 SSE 等待任务结束；连接失败或中途断开时返回 null，由调用方退回轮询
+function waitTaskEvents<T>(
+    taskId: string,
+    deviceHash: string,
+    timeout: number,
+    onProgress?: (status: TaskResult<T>) => void,
+): Promise<T | null> {
+    if (typeof window === "undefined" || typeof EventSource === "undefined") {
+        return Promise.resolve(null);
+    }
+    return new Promise((resolve, reject) => {
+        const source = new EventSource(`${API_BASE}/api/task/${taskId}/events?device_hash=${deviceHash}`, { withCredentials: true });
+        const timer = setTimeout(() => finish(() => resolve(null)), timeout);
+        const finish = (fn: () => void) => {
+            clearTimeout(timer);
+            source.close();
+            fn();
+        };
+
+        source.onmessage = (event) => {
+            if (event.data === "[DONE]") {
+                finish(() => resolve(null));
+                return;
+            }
+            let data: TaskResult<T>;
+            try {
+                data = JSON.parse(event.data);
+            } catch {
+                return;
+            }
+            onProgress?.(data);
+            if (data.status === "completed" && data.result) {
+                finish(() => resolve(data.result as T));
+            } else if (data.status === "failed") {
+                finish(() => reject(new Error(data.error || "大师推演遇到了困难，请重试")));
+            } else if (data.status === "cancelled") {
+                finish(() => reject(new Error("task_cancelled")));
+            }
+        };
+        source.onerror = () => finish(() => resolve(null));
+    });
+}
+
+export async function pollTask<T>(
+    taskId: string,
+    deviceHash: string,
+    interval = 1500,
+    timeout = 120000,
+    onProgress?: (status: TaskResult<T>) => void,
+): Promise<T> {
     const startTime = Date.now();
+
+    const pushed = await waitTaskEvents<T>(taskId, deviceHash, timeout, onProgress);
+    if (pushed !== null) {
+        return pushed;
+    }
     
     while (Date.now() - startTime < timeout) {
-        const data = await getTaskStatus<T>(taskId);
+        const data = await getTaskStatus<T>(taskId, deviceHash);
+        onProgress?.(data);
         
         if (data.status === "completed" && data.result) {
             return data.result;
@@ -415,6 +488,10 @@ export async function pollTask<T>(taskId: string, interval = 1500, timeout = 120
         if (data.status === "failed") {
             throw new Error(data.error || "大师推演遇到了困难，请重试");
         }
+
+        if (data.status === "cancelled") {
+            throw new Error("task_cancelled");
+        }
         
         // Wait
         await new Promise(resolve => setTimeout(resolve, interval));
@@ -422,6 +499,16 @@ export async function pollTask<T>(taskId: string, interval = 1500, timeout = 120
     throw new Error("大师思考时间过长，请稍后在历史记录中查看");
 }
 
+// 撤回排队中或推演中的任务；推演已完成时返回 false
+export async function cancelTask(taskId: string, deviceHash: string) {
+    const res = await fetch(`${API_BASE}/api/task/${taskId}?device_hash=${deviceHash}`, {
+        ...fetchOptions,
+        method: "DELETE",
+        headers: getHeaders(),
+    });
+    return res.ok;
+}
+
 export async function submitLoveProbe(req: Omit<LoveProbeRequest, 'device_hash'> & { deviceHash: string }) {
     const { deviceHash, ...rest } = req;
     const res = await fetch(`${API_BASE}/api/love`, {
@@ -437,7 +524,7 @@ export async function submitLoveProbe(req: Omit<LoveProbeRequest, 'device_hash'>
     const data = await res.json();
     if (data.task_id) {
         // 开始轮询
-        return pollTask<LoveProbeResponse>(data.task_id);
+        return pollTask<LoveProbeResponse>(data.task_id, deviceHash);
     }
     // 兼容旧接口（如果后端回滚）
     return data as LoveProbeResponse;
@@ -465,7 +552,7 @@ export async function askQuestion(question: string, deviceHash: string, secret?:
       // 轮询结果
       // 结果结构: { divination_id: number, result: Output }
       // 注意：后端 Worker processQuestion 返回的就是这个结构
-      return pollTask<any>(data.task_id); 
+      return pollTask<any>(data.task_id, deviceHash); 
   }
   return data;
 }